@@ -0,0 +1,66 @@
+package pages
+
+import (
+	"errors"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+// ErrAttrsTooLarge is returned if the attributes of an entry don't fit into
+// its entryPage
+var ErrAttrsTooLarge = errors.New("attributes exceed the maximum size")
+
+// SetAttr sets the attribute key of the entry to value. A nil value removes
+// the attribute. The marshalled attributes of an entry can't exceed a few KiB
+func (e *Entry) SetAttr(key string, value []byte) error {
+	if key == "" {
+		return errors.New("attribute key can't be empty")
+	}
+	if e.cow != nil {
+		return errors.New("attributes can't be modified through a copy-on-write entry")
+	}
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return err
+	}
+	if err := e.checkWritable(); err != nil {
+		return err
+	}
+
+	attrs, err := readAttrs(e.ep.pp, e.ep.generation)
+	if err != nil {
+		return build.ExtendErr("failed to read attributes", err)
+	}
+	if value == nil {
+		delete(attrs, key)
+	} else {
+		attrs[key] = value
+	}
+	return writeAttrs(e.ep.pp, e.ep.generation, attrs)
+}
+
+// GetAttr returns the value of the attribute key. The returned bool is false
+// if the entry doesn't have the attribute
+func (e *Entry) GetAttr(key string) ([]byte, bool, error) {
+	attrs, err := e.Attrs()
+	if err != nil {
+		return nil, false, err
+	}
+	value, exists := attrs[key]
+	return value, exists, nil
+}
+
+// Attrs returns all the attributes of the entry
+func (e *Entry) Attrs() (map[string][]byte, error) {
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return nil, err
+	}
+	attrs, err := readAttrs(e.ep.pp, e.ep.generation)
+	if err != nil {
+		return nil, build.ExtendErr("failed to read attributes", err)
+	}
+	return attrs, nil
+}
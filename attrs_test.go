@@ -0,0 +1,97 @@
+package pages
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestAttrs tests if the attributes of an entry are persisted and if the
+// size of the attributes is limited
+func TestAttrs(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and set some attributes
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := map[string][]byte{
+		"content-type": []byte("text/plain"),
+		"created-at":   []byte("2018-05-01T12:00:00Z"),
+		"checksum":     fastrand.Bytes(32),
+	}
+	for key, value := range attrs {
+		if err := entry.SetAttr(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Remove one of them
+	if err := entry.SetAttr("created-at", nil); err != nil {
+		t.Fatal(err)
+	}
+	delete(attrs, "created-at")
+
+	// Attributes that exceed the maximum size should be rejected
+	if err := entry.SetAttr("large", make([]byte, maxAttrsSize)); err != ErrAttrsTooLarge {
+		t.Fatalf("error should be %v but was %v", ErrAttrsTooLarge, err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the PageManager and read the attributes
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pt.pm, err = New(pt.pm.file.(*os.File).Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAttrs, err := entry.Attrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readAttrs) != len(attrs) {
+		t.Fatalf("There should be %v attributes but there were %v", len(attrs), len(readAttrs))
+	}
+	for key, value := range attrs {
+		readValue, exists, err := entry.GetAttr(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists || !bytes.Equal(readValue, value) {
+			t.Errorf("Attribute %v should be %v but was %v", key, value, readValue)
+		}
+	}
+	if _, exists, err := entry.GetAttr("created-at"); err != nil || exists {
+		t.Errorf("Removed attribute shouldn't exist: %v", err)
+	}
+
+	// A new entry that reuses the entryPage shouldn't inherit the attributes
+	if err := pt.pm.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	entry, _, err = pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAttrs, err = entry.Attrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readAttrs) != 0 {
+		t.Errorf("New entry shouldn't have attributes but had %v", readAttrs)
+	}
+}
@@ -0,0 +1,48 @@
+package pages
+
+import "time"
+
+type (
+	// Clock is the source of time used by the PageManager. It can be replaced
+	// using the Options to control the timing of background tasks in tests
+	Clock interface {
+		// NewTicker returns a Ticker that ticks every d
+		NewTicker(d time.Duration) Ticker
+
+		// Now returns the current time
+		Now() time.Time
+	}
+
+	// Ticker delivers the ticks of a Clock
+	Ticker interface {
+		// C returns the channel on which the ticks are delivered
+		C() <-chan time.Time
+
+		// Stop turns off the Ticker
+		Stop()
+	}
+
+	// realClock is the Clock that is used if no other Clock is specified. It
+	// uses the time package
+	realClock struct{}
+
+	// realTicker wraps a time.Ticker to implement the Ticker interface
+	realTicker struct {
+		*time.Ticker
+	}
+)
+
+// NewTicker returns a time.Ticker that ticks every d
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// Now returns time.Now
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// C returns the channel of the time.Ticker
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
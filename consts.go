@@ -11,6 +11,90 @@ const (
 	// point to. 8 bytes for the number of entries and 8 for each entry
 	numPageEntries = (pageSize - 8) / 8.0
 
+	// numTreeSlots is the number of entries at the beginning of a tieredPage
+	// that are reserved for the roots of the different tree heights. The
+	// remaining space of the page can be used for metadata
+	numTreeSlots = 8
+
+	// generationOff is the offset of the generation within an entryPage. For
+	// the freePages entryPage it is the last generation that was assigned to
+	// an entry
+	generationOff = numTreeSlots * tieredPageEntrySize
+
+	// contentHashOff is the offset of the cached content hash within an
+	// entryPage. It is preceded by the generation of the entry the hash
+	// belongs to
+	contentHashOff = generationOff + 8
+
+	// attrsOff is the offset of the attributes within an entryPage. They
+	// follow the cached content hash and are preceded by the generation of
+	// the entry they belong to and their length
+	attrsOff = contentHashOff + 8 + 32
+
+	// maxAttrsSize is the maximum size of the marshalled attributes of an
+	// entry. They end before the marker of the entryPage
+	maxAttrsSize = entryMarkerOff - attrsOff - 12
+
+	// entryMarkerOff is the offset of the marker that identifies a page as
+	// the entryPage of a live entry. It precedes the modification time
+	entryMarkerOff = modTimeOff - 8
+
+	// entryMarker is xored with the offset of an entryPage to get the
+	// marker that is stored on it. Including the offset prevents copies of
+	// an entryPage at other offsets from passing as entryPages
+	entryMarker = 0x70616765656e7472
+
+	// modTimeOff is the offset of the time of the last modification of an
+	// entry within an entryPage. It precedes the maximum size and is preceded
+	// by the generation of the entry it belongs to
+	modTimeOff = maxSizeOff - 16
+
+	// maxSizeOff is the offset of the maximum size of an entry within an
+	// entryPage. It is stored at the end of the page and preceded by the
+	// generation of the entry it belongs to
+	maxSizeOff = pageSize - 16
+
+	// freeListDirtyOff is the offset of the flag within the freePages
+	// entryPage that indicates that free pages were kept in memory without
+	// being persisted
+	freeListDirtyOff = generationOff + 8
+
+	// txnLogOff is the offset of the Identifier of the entry that contains
+	// the log of a committed transaction within the freePages entryPage. It
+	// is 0 if no transaction needs to be replayed
+	txnLogOff = freeListDirtyOff + 8
+
+	// indexOff is the offset of the Identifier of the index entry used by
+	// Put and Get within the freePages entryPage. It is 0 if no index was
+	// created yet
+	indexOff = txnLogOff + 8
+
+	// replaceLogOff is the offset of the log of an unfinished Entry.Replace
+	// within the freePages entryPage. It contains the Identifiers of the
+	// replaced entry and of the scratch entry followed by the tree slots of
+	// the scratch entry once its data is durable
+	replaceLogOff = indexOff + 8
+
+	// replaceLogSize is the size of the log of an unfinished Entry.Replace
+	replaceLogSize = 16 + numTreeSlots*tieredPageEntrySize
+
+	// directoryOff is the offset of the first page of the directory of live
+	// entries within the freePages entryPage
+	directoryOff = replaceLogOff + replaceLogSize
+
+	// dirSlotsPerPage is the number of Identifiers a page of the directory
+	// can hold. They are followed by the offset of the next page of the
+	// directory or 0 for the last page
+	dirSlotsPerPage = pageSize/8 - 1
+
+	// metaExtentPages is the number of pages that are allocated at once for
+	// metadata if SeparateMetadata is enabled
+	metaExtentPages = 64
+
+	// progressInterval is the number of bytes after which the progress of
+	// an operation is reported
+	progressInterval = 64 * pageSize
+
 	// freeOff is the offset of the freePages entryPage relative to the start
 	// of the file
 	freeOff = 0
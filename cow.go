@@ -0,0 +1,194 @@
+package pages
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+var (
+	// ErrCOWDone is returned if a copy-on-write Entry is committed or
+	// aborted more than once
+	ErrCOWDone = errors.New("copy-on-write entry was already committed or aborted")
+)
+
+type (
+	// cowState is the state of an Entry that was opened with OpenCOW
+	cowState struct {
+		// mu serializes copying the entry with committing and aborting
+		mu *sync.Mutex
+
+		// orig is the entryPage of the original entry and generation is the
+		// generation of the entry when it was opened
+		orig       *entryPage
+		generation uint64
+
+		// scratch is a handle of the scratch entry that receives the
+		// modifications after the first write. It is nil until then
+		scratch   *Entry
+		scratchID Identifier
+	}
+)
+
+// OpenCOW opens the entry with the specified Identifier for copy-on-write.
+// Reads are served by the entry until the returned Entry is modified for the
+// first time. Then the data is copied to a scratch entry of newly allocated
+// pages which receives all modifications. commit swaps the tree of the entry
+// with the tree of the scratch entry and abort discards the scratch entry.
+// Afterwards the Entry is a regular handle of the entry that needs to be
+// closed. The Entry can't be used concurrently and its attributes can't be
+// modified
+func (p *PageManager) OpenCOW(id Identifier) (e *Entry, commit func() error, abort func() error, err error) {
+	e, err = p.Open(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	e.cow = &cowState{
+		mu:         new(sync.Mutex),
+		orig:       e.ep,
+		generation: e.generation,
+	}
+	return e, e.managedCommitCOW, e.managedAbortCOW, nil
+}
+
+// managedCopyOnWrite copies the data of a copy-on-write Entry to a scratch
+// entry before the Entry is modified for the first time and points the Entry
+// to the scratch entry
+func (e *Entry) managedCopyOnWrite() error {
+	if e.cow == nil {
+		return nil
+	}
+	e.cow.mu.Lock()
+	defer e.cow.mu.Unlock()
+	if e.cow.scratch != nil {
+		return nil
+	}
+
+	// Copy the data of the entry. Holes are copied as zeros
+	scratch, scratchID, err := e.pm.Create()
+	if err != nil {
+		return build.ExtendErr("failed to create scratch entry", err)
+	}
+	size, err := e.Size()
+	if err == nil {
+		_, err = io.Copy(scratch, io.NewSectionReader(e, 0, size))
+	}
+	if err != nil {
+		scratch.Close()
+		if deleteErr := e.pm.Delete(scratchID); deleteErr != nil {
+			return build.ExtendErr(fmt.Sprintf("failed to delete scratch entry after error '%v'", err), deleteErr)
+		}
+		return build.ExtendErr("failed to copy entry", err)
+	}
+	e.cow.scratch = scratch
+	e.cow.scratchID = scratchID
+	e.ep = scratch.ep
+	e.generation = scratch.generation
+	return nil
+}
+
+// managedCommitCOW replaces the tree of the original entry with the tree of
+// the scratch entry and deletes the scratch entry together with the old tree
+func (e *Entry) managedCommitCOW() error {
+	cow := e.cow
+	if cow == nil {
+		return ErrCOWDone
+	}
+	cow.mu.Lock()
+	defer cow.mu.Unlock()
+	e.cow = nil
+	e.ep = cow.orig
+	e.generation = cow.generation
+	if cow.scratch == nil {
+		// The entry wasn't modified
+		return nil
+	}
+	if err := cow.scratch.Flush(); err != nil {
+		return build.ExtendErr("failed to flush scratch entry", err)
+	}
+	if err := e.swapTree(cow.scratch.ep); err != nil {
+		return build.ExtendErr("failed to swap trees", err)
+	}
+	if err := cow.scratch.Close(); err != nil {
+		return err
+	}
+	return e.pm.Delete(cow.scratchID)
+}
+
+// managedAbortCOW deletes the scratch entry of a copy-on-write Entry
+func (e *Entry) managedAbortCOW() error {
+	cow := e.cow
+	if cow == nil {
+		return ErrCOWDone
+	}
+	cow.mu.Lock()
+	defer cow.mu.Unlock()
+	e.cow = nil
+	e.ep = cow.orig
+	e.generation = cow.generation
+	if cow.scratch == nil {
+		return nil
+	}
+	if err := cow.scratch.Close(); err != nil {
+		return err
+	}
+	return e.pm.Delete(cow.scratchID)
+}
+
+// swapTree swaps the pageTable tree of the entry with the tree of another
+// entryPage on disk and in memory. The other entryPage is updated and synced
+// first to leave the entry unchanged if the swap is interrupted and to never
+// let the entry point to a tree the other entryPage points to as well. The
+// slots of each entryPage are written at once to not mix the slots of both
+// trees
+func (e *Entry) swapTree(other *entryPage) error {
+	if err := e.ep.managedLoadTree(); err != nil {
+		return err
+	}
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return err
+	}
+	if err := e.checkWritable(); err != nil {
+		return err
+	}
+	if err := e.ep.flushTables(); err != nil {
+		return err
+	}
+	if err := e.invalidateHash(); err != nil {
+		return err
+	}
+	other.mu.Lock()
+	defer other.mu.Unlock()
+
+	// Swap the slots of the entryPages
+	slots := make([]byte, numTreeSlots*tieredPageEntrySize)
+	otherSlots := make([]byte, numTreeSlots*tieredPageEntrySize)
+	if _, err := e.ep.pp.readAt(slots, 0); err != nil {
+		return build.ExtendErr("failed to read slots", err)
+	}
+	if _, err := other.pp.readAt(otherSlots, 0); err != nil {
+		return build.ExtendErr("failed to read slots", err)
+	}
+	if _, err := other.pp.writeAt(slots, 0); err != nil {
+		return build.ExtendErr("failed to write slots", err)
+	}
+	if err := e.pm.file.Sync(); err != nil {
+		return build.ExtendErr("failed to sync slots", err)
+	}
+	if _, err := e.ep.pp.writeAt(otherSlots, 0); err != nil {
+		return build.ExtendErr("failed to write slots", err)
+	}
+
+	// Swap the trees in memory
+	e.ep.root, other.root = other.root, e.ep.root
+	e.ep.pages, other.pages = other.pages, e.ep.pages
+	e.ep.usedSize, other.usedSize = other.usedSize, e.ep.usedSize
+	e.ep.records, e.ep.recordsEnd = nil, 0
+	other.records, other.recordsEnd = nil, 0
+	return e.touch()
+}
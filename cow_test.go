@@ -0,0 +1,134 @@
+package pages
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestOpenCOW tests if the modifications of a copy-on-write entry only become
+// visible after they are committed
+func TestOpenCOW(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	path := pt.pm.file.(*os.File).Name()
+
+	// Create an entry
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(3*pageSize + 10)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	newData := append(append([]byte(nil), data[:pageSize]...), fastrand.Bytes(pageSize)...)
+
+	// readAll reads the whole entry through a new handle
+	readAll := func(pm *PageManager) []byte {
+		entry, err := pm.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer entry.Close()
+		readData, err := ioutil.ReadAll(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return readData
+	}
+
+	// modify truncates the copy-on-write entry and overwrites its second
+	// page
+	modify := func(cow *Entry) {
+		if err := cow.Truncate(2 * pageSize); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cow.WriteAt(newData[pageSize:], pageSize); err != nil {
+			t.Fatal(err)
+		}
+		readData := make([]byte, 2*pageSize)
+		if _, err := cow.ReadAt(readData, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(readData, newData) {
+			t.Fatal("Copy-on-write entry should contain the new data")
+		}
+		if !bytes.Equal(readAll(pt.pm), data) {
+			t.Fatal("Entry shouldn't be modified before commit")
+		}
+	}
+
+	// Modify the entry and abort
+	cow, commit, abort, err := pt.pm.OpenCOW(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cow.SetAttr("key", []byte("value")); err == nil {
+		t.Error("Attributes of a copy-on-write entry shouldn't be modifiable")
+	}
+	modify(cow)
+	if err := abort(); err != nil {
+		t.Fatal(err)
+	}
+	if err := commit(); err != ErrCOWDone {
+		t.Fatalf("Commit should return %v but was %v", ErrCOWDone, err)
+	}
+	if !bytes.Equal(readAll(pt.pm), data) {
+		t.Fatal("Aborting should leave the entry unchanged")
+	}
+	if err := cow.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The scratch pages should have been freed
+	if orphans, err := pt.pm.FindOrphans([]Identifier{id}); err != nil || len(orphans) > 0 {
+		t.Fatalf("No pages should be leaked but %v were %v", len(orphans), err)
+	}
+
+	// Modify the entry again and commit
+	cow, commit, _, err = pt.pm.OpenCOW(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modify(cow)
+	if err := commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readAll(pt.pm), newData) {
+		t.Fatal("Committed modifications should be visible")
+	}
+
+	// The handle should be a regular handle of the entry afterwards
+	readData := make([]byte, len(newData))
+	if _, err := cow.ReadAt(readData, 0); err != nil || !bytes.Equal(readData, newData) {
+		t.Fatal("Handle should read the committed data", err)
+	}
+	if err := cow.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if orphans, err := pt.pm.FindOrphans([]Identifier{id}); err != nil || len(orphans) > 0 {
+		t.Fatalf("No pages should be leaked but %v were %v", len(orphans), err)
+	}
+
+	// The committed data should survive reopening the PageManager
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pt.pm, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readAll(pt.pm), newData) {
+		t.Fatal("Committed modifications should be persisted")
+	}
+}
@@ -0,0 +1,67 @@
+package pages
+
+import "io"
+
+type (
+	// Cursor is a read-only position within an Entry. Multiple Cursors of
+	// the same Entry can be used to read from different positions without
+	// opening the Entry multiple times
+	Cursor struct {
+		// e is the Entry the Cursor reads from
+		e *Entry
+
+		// cursorOff is the offset of the cursor from the start of the current
+		// page it is pointed at
+		cursorOff int64
+
+		// cursorPage is the index of the page in pages to which the cursor points
+		cursorPage int64
+
+		// follow indicates that reaching the end of the entry returns (0,
+		// nil) instead of io.EOF since more data might be appended
+		follow bool
+	}
+)
+
+// NewCursor returns a new Cursor that points to the start of the entry
+func (e *Entry) NewCursor() *Cursor {
+	return &Cursor{
+		e: e,
+	}
+}
+
+// NewFollowCursor returns a new Cursor in follow mode that points to the start
+// of the entry. Instead of returning io.EOF at the end of the entry, a follow
+// Cursor returns (0, nil) and continues reading data that is appended later.
+// Callers need to poll it since Read doesn't block
+func (e *Entry) NewFollowCursor() *Cursor {
+	return &Cursor{
+		e:      e,
+		follow: true,
+	}
+}
+
+// Read tries to read len(p) bytes from the current position of the Cursor. If
+// the Cursor is in follow mode, reaching the end of the entry returns (0, nil)
+func (c *Cursor) Read(p []byte) (int, error) {
+	c.e.ep.mu.RLock()
+	defer c.e.ep.mu.RUnlock()
+	if err := c.e.checkGeneration(); err != nil {
+		return 0, err
+	}
+	n, err := c.e.read(p, &c.cursorPage, &c.cursorOff)
+	if err == io.EOF && c.follow {
+		return n, nil
+	}
+	return n, err
+}
+
+// Seek moves the Cursor to offset relative to whence
+func (c *Cursor) Seek(offset int64, whence int) (int64, error) {
+	c.e.ep.mu.RLock()
+	defer c.e.ep.mu.RUnlock()
+	if err := c.e.checkGeneration(); err != nil {
+		return 0, err
+	}
+	return c.e.seekWhence(offset, whence, &c.cursorPage, &c.cursorOff)
+}
@@ -0,0 +1,136 @@
+package pages
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestCursor tests if multiple Cursors can read different regions of the
+// same entry independently
+func TestCursor(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and write some data
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(4*pageSize + 100)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create two cursors and move the second one to the middle of the entry
+	c1 := entry.NewCursor()
+	c2 := entry.NewCursor()
+	offset := int64(2*pageSize + 10)
+	if off, err := c2.Seek(offset, io.SeekStart); err != nil || off != offset {
+		t.Fatalf("Seek should return %v but returned %v %v", offset, off, err)
+	}
+
+	// Read alternately with both cursors
+	readData1 := make([]byte, 0, len(data))
+	readData2 := make([]byte, 0, len(data)-int(offset))
+	buf := make([]byte, 1000)
+	for {
+		n1, err1 := c1.Read(buf)
+		readData1 = append(readData1, buf[:n1]...)
+		n2, err2 := c2.Read(buf)
+		readData2 = append(readData2, buf[:n2]...)
+		if err1 == io.EOF && err2 == io.EOF {
+			break
+		}
+		if (err1 != nil && err1 != io.EOF) || (err2 != nil && err2 != io.EOF) {
+			t.Fatal(err1, err2)
+		}
+	}
+	if bytes.Compare(data, readData1) != 0 {
+		t.Error("Data read by first cursor doesn't match written data")
+	}
+	if bytes.Compare(data[offset:], readData2) != 0 {
+		t.Error("Data read by second cursor doesn't match written data")
+	}
+
+	// The cursor of the entry shouldn't have moved
+	if off, err := entry.Seek(0, io.SeekCurrent); err != nil || off != int64(len(data)) {
+		t.Errorf("Entry's cursor should be at %v but was at %v %v", len(data), off, err)
+	}
+
+	// Seeking relative to the current position should work
+	if _, err := c1.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if off, err := c1.Seek(offset, io.SeekCurrent); err != nil || off != offset {
+		t.Errorf("Seek should return %v but returned %v %v", offset, off, err)
+	}
+}
+
+// TestFollowCursor tests if a Cursor in follow mode returns (0, nil) at the end
+// of the entry and picks up data that is appended afterwards
+func TestFollowCursor(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and write some data
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	data := fastrand.Bytes(pageSize + 100)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drain the existing data
+	c := entry.NewFollowCursor()
+	readData := make([]byte, len(data))
+	if n, err := io.ReadFull(c, readData); err != nil || n != len(data) {
+		t.Fatalf("Should read %v bytes but read %v %v", len(data), n, err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Fatal("Data read by cursor doesn't match written data")
+	}
+
+	// The end of the entry should be signaled by (0, nil)
+	buf := make([]byte, 100)
+	if n, err := c.Read(buf); n != 0 || err != nil {
+		t.Fatalf("Read at the end should return (0, nil) but returned (%v, %v)", n, err)
+	}
+
+	// A normal cursor should still return io.EOF
+	normal := entry.NewCursor()
+	if _, err := normal.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := normal.Read(buf); err != io.EOF {
+		t.Fatalf("Read at the end should return %v but returned %v", io.EOF, err)
+	}
+
+	// Append data to the partial last page and a new page. The cursor should
+	// pick it up
+	moreData := fastrand.Bytes(pageSize)
+	if _, err := entry.Write(moreData); err != nil {
+		t.Fatal(err)
+	}
+	readData = make([]byte, len(moreData))
+	if n, err := io.ReadFull(c, readData); err != nil || n != len(moreData) {
+		t.Fatalf("Should read %v bytes but read %v %v", len(moreData), n, err)
+	}
+	if !bytes.Equal(moreData, readData) {
+		t.Fatal("Appended data read by cursor doesn't match written data")
+	}
+	if n, err := c.Read(buf); n != 0 || err != nil {
+		t.Fatalf("Read at the end should return (0, nil) but returned (%v, %v)", n, err)
+	}
+}
@@ -0,0 +1,151 @@
+package pages
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+// readDirectoryHead reads the offset of the first page of the directory from
+// the freePages entryPage
+func readDirectoryHead(pp *physicalPage) (int64, error) {
+	data := make([]byte, 8)
+	if _, err := pp.readAt(data, directoryOff); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(data)), nil
+}
+
+// writeDirectoryHead writes the offset of the first page of the directory to
+// the freePages entryPage
+func writeDirectoryHead(pp *physicalPage, off int64) error {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(off))
+	if _, err := pp.writeAt(data, directoryOff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadDirectory reads the directory of the live entries from disk. Slots that
+// repeat an Identifier are cleared. The p.mu lock needs to be acquired
+func (p *PageManager) loadDirectory() error {
+	p.directory = make(map[Identifier]int64)
+	p.dirPages = nil
+	p.freeSlots = nil
+	stat, err := p.file.Stat()
+	if err != nil {
+		return build.ExtendErr("failed to get size of file", err)
+	}
+	off, err := readDirectoryHead(p.freePages.pp)
+	if err != nil {
+		return build.ExtendErr("failed to read first page of directory", err)
+	}
+	visited := make(map[int64]struct{})
+	for off != 0 {
+		if err := checkOffset(off, stat.Size()); err != nil {
+			return build.ExtendErr("invalid page in directory", err)
+		}
+		if _, exists := visited[off]; exists {
+			return fmt.Errorf("page at %v is referenced twice by the directory", off)
+		}
+		visited[off] = struct{}{}
+		pp := &physicalPage{
+			file:     p.file,
+			fileOff:  off,
+			usedSize: pageSize,
+			strict:   p.opts.StrictMode,
+		}
+		data := make([]byte, pageSize)
+		if _, err := pp.readAt(data, 0); err != nil {
+			return build.ExtendErr(fmt.Sprintf("failed to read directory page at %v", off), err)
+		}
+		p.dirPages = append(p.dirPages, pp)
+		for i := dirSlotsPerPage - 1; i >= 0; i-- {
+			slotOff := off + int64(i)*8
+			id := Identifier(binary.LittleEndian.Uint64(data[i*8:]))
+			if id == 0 {
+				p.freeSlots = append(p.freeSlots, slotOff)
+				continue
+			}
+			if _, exists := p.directory[id]; exists {
+				if err := p.writeSlot(slotOff, 0); err != nil {
+					return build.ExtendErr("failed to clear repeated Identifier", err)
+				}
+				p.freeSlots = append(p.freeSlots, slotOff)
+				continue
+			}
+			p.directory[id] = slotOff
+		}
+		off = int64(binary.LittleEndian.Uint64(data[dirSlotsPerPage*8:]))
+	}
+	return nil
+}
+
+// registerEntry adds id to the directory. A new page is chained to the
+// directory if all slots are used. The p.mu lock needs to be acquired
+func (p *PageManager) registerEntry(id Identifier) error {
+	if len(p.freeSlots) == 0 {
+		if err := p.extendDirectory(); err != nil {
+			return build.ExtendErr("failed to extend directory", err)
+		}
+	}
+	slotOff := p.freeSlots[len(p.freeSlots)-1]
+	if err := p.writeSlot(slotOff, id); err != nil {
+		return build.ExtendErr("failed to register entry", err)
+	}
+	p.freeSlots = p.freeSlots[:len(p.freeSlots)-1]
+	p.directory[id] = slotOff
+	return nil
+}
+
+// unregisterEntry removes id from the directory. The p.mu lock needs to be
+// acquired
+func (p *PageManager) unregisterEntry(id Identifier) error {
+	slotOff, exists := p.directory[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := p.writeSlot(slotOff, 0); err != nil {
+		return build.ExtendErr("failed to unregister entry", err)
+	}
+	delete(p.directory, id)
+	p.freeSlots = append(p.freeSlots, slotOff)
+	return nil
+}
+
+// extendDirectory chains a new empty page to the directory. The page is
+// cleared before it is referenced. The p.mu lock needs to be acquired
+func (p *PageManager) extendDirectory() error {
+	pp, err := p.allocateMetaPage()
+	if err != nil {
+		return err
+	}
+	if _, err := pp.writeAt(make([]byte, pageSize), 0); err != nil {
+		return build.ExtendErr("failed to clear directory page", err)
+	}
+	if len(p.dirPages) == 0 {
+		err = writeDirectoryHead(p.freePages.pp, pp.fileOff)
+	} else {
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, uint64(pp.fileOff))
+		_, err = p.dirPages[len(p.dirPages)-1].writeAt(data, dirSlotsPerPage*8)
+	}
+	if err != nil {
+		return build.ExtendErr("failed to chain directory page", err)
+	}
+	p.dirPages = append(p.dirPages, pp)
+	for i := dirSlotsPerPage - 1; i >= 0; i-- {
+		p.freeSlots = append(p.freeSlots, pp.fileOff+int64(i)*8)
+	}
+	return nil
+}
+
+// writeSlot writes id to the slot of the directory at slotOff
+func (p *PageManager) writeSlot(slotOff int64, id Identifier) error {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(id))
+	_, err := writeFull(p.file, data, slotOff)
+	return err
+}
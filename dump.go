@@ -0,0 +1,106 @@
+package pages
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+type (
+	// DumpInfo is the document written by DumpJSON
+	DumpInfo struct {
+		// PageSize is the size of a page in bytes
+		PageSize int64 `json:"pageSize"`
+
+		// FileSize is the size of the file in bytes
+		FileSize int64 `json:"fileSize"`
+
+		// FreeListDirty indicates that the free pages on disk are incomplete
+		FreeListDirty bool `json:"freeListDirty"`
+
+		// Entries are the dumped entries
+		Entries []DumpEntryInfo `json:"entries"`
+
+		// FreePages are the sorted offsets of the free pages
+		FreePages []int64 `json:"freePages"`
+	}
+
+	// DumpEntryInfo describes an entry of a DumpInfo
+	DumpEntryInfo struct {
+		// ID is the Identifier of the entry
+		ID Identifier `json:"id"`
+
+		// Size is the size of the entry in bytes
+		Size int64 `json:"size"`
+
+		// Height is the height of the entry's pageTable tree
+		Height int64 `json:"height"`
+	}
+)
+
+// DumpJSON writes a JSON document of the file, the entries with the specified
+// Identifiers and the free pages to w. It is meant for tools that monitor the
+// PageManager. Entries that are written to concurrently are dumped with the
+// size they had when they were visited
+func (p *PageManager) DumpJSON(w io.Writer, ids []Identifier) error {
+	info := DumpInfo{
+		PageSize:  pageSize,
+		Entries:   make([]DumpEntryInfo, 0, len(ids)),
+		FreePages: []int64{},
+	}
+
+	// Describe the entries. Their trees don't need to be loaded since the
+	// size and height are stored on the entryPage. Uncached entries are only
+	// peeked at to not create the roots of reserved entries
+	for _, id := range ids {
+		p.mu.Lock()
+		ep, cached := p.entryPages[id]
+		if !cached {
+			var err error
+			ep, err = p.peekEntryPage(id, true)
+			if err != nil {
+				p.mu.Unlock()
+				return build.ExtendErr(fmt.Sprintf("failed to load entry %v", id), err)
+			}
+		}
+		p.mu.Unlock()
+
+		ep.mu.RLock()
+		entryInfo := DumpEntryInfo{
+			ID:   id,
+			Size: ep.usedSize,
+		}
+		if ep.root != nil {
+			entryInfo.Height = ep.root.height
+		}
+		info.Entries = append(info.Entries, entryInfo)
+		ep.mu.RUnlock()
+	}
+
+	// Describe the file and the free pages
+	p.mu.Lock()
+	stat, err := p.file.Stat()
+	if err != nil {
+		p.mu.Unlock()
+		return build.ExtendErr("failed to get size of file", err)
+	}
+	info.FileSize = stat.Size()
+	info.FreeListDirty = p.freeListDirty
+	for _, page := range p.freePages.pages {
+		info.FreePages = append(info.FreePages, page.fileOff)
+	}
+	for _, page := range p.freePages.pagesToFree {
+		info.FreePages = append(info.FreePages, page.fileOff)
+	}
+	p.mu.Unlock()
+	sort.Slice(info.FreePages, func(i, j int) bool {
+		return info.FreePages[i] < info.FreePages[j]
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(info)
+}
@@ -0,0 +1,120 @@
+package pages
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestDumpJSON tests if the JSON document written by DumpJSON can be decoded
+// and describes the entries and free pages
+func TestDumpJSON(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create entries of different sizes. The last one has a tree with 2
+	// levels and stays open
+	sizes := []int64{0, 100, 3 * pageSize, (numPageEntries + 1) * pageSize}
+	var ids []Identifier
+	for i, size := range sizes {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write(fastrand.Bytes(int(size))); err != nil {
+			t.Fatal(err)
+		}
+		if i < len(sizes)-1 {
+			if err := entry.Close(); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			defer entry.Close()
+		}
+		ids = append(ids, id)
+	}
+
+	// Delete an entry to get free pages
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(2 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dump and decode the document
+	var buf bytes.Buffer
+	if err := pt.pm.DumpJSON(&buf, ids); err != nil {
+		t.Fatal(err)
+	}
+	var info DumpInfo
+	if err := json.Unmarshal(buf.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.PageSize != pageSize {
+		t.Errorf("Page size should be %v but was %v", pageSize, info.PageSize)
+	}
+	if len(info.Entries) != len(sizes) {
+		t.Fatalf("There should be %v entries but there were %v", len(sizes), len(info.Entries))
+	}
+	for i, e := range info.Entries {
+		if e.ID != ids[i] || e.Size != sizes[i] {
+			t.Errorf("Entry %v should be %v with size %v but was %v with size %v", i, ids[i], sizes[i], e.ID, e.Size)
+		}
+	}
+	if height := info.Entries[len(sizes)-1].Height; height != 1 {
+		t.Errorf("Height of the last entry should be %v but was %v", 1, height)
+	}
+	if len(info.FreePages) != pt.pm.freePages.availablePages() {
+		t.Errorf("There should be %v free pages but there were %v", pt.pm.freePages.availablePages(), len(info.FreePages))
+	}
+	for _, off := range info.FreePages {
+		if off%pageSize != 0 || off >= info.FileSize {
+			t.Errorf("Free page at %v isn't a page of the file of size %v", off, info.FileSize)
+		}
+	}
+
+	// Dumping a reserved entry shouldn't create its root
+	reservedID, err := pt.pm.Reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := pt.pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := pt.pm.DumpJSON(&buf, []Identifier{reservedID}); err != nil {
+		t.Fatal(err)
+	}
+	info = DumpInfo{}
+	if err := json.Unmarshal(buf.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if e := info.Entries[0]; e.Size != 0 || e.Height != 0 {
+		t.Errorf("Reserved entry should have size 0 and height 0 but had %v and %v", e.Size, e.Height)
+	}
+	stat2, err := pt.pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat2.Size() != stat.Size() {
+		t.Errorf("Dumping shouldn't grow the file from %v to %v bytes", stat.Size(), stat2.Size())
+	}
+	pp := &physicalPage{file: pt.pm.file, fileOff: int64(reservedID), usedSize: pageSize}
+	if _, rootOff, err := readEntryPageEntry(pp, 0); err != nil || rootOff != 0 {
+		t.Errorf("Root of reserved entry shouldn't be written: %v %v", rootOff, err)
+	}
+}
@@ -1,12 +1,40 @@
 package pages
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"sync/atomic"
+	"time"
 
 	"github.com/NebulousLabs/Sia/build"
 )
 
+var (
+	// ErrStaleHandle is returned by operations on an Entry that was deleted
+	// after it was opened
+	ErrStaleHandle = errors.New("entry handle is stale")
+
+	// ErrLocked is returned if an entry is opened exclusively while another
+	// exclusive handle is open or if a shared handle tries to modify an
+	// exclusively opened entry
+	ErrLocked = errors.New("entry is locked by an exclusive handle")
+
+	// ErrHole is returned if a byte belongs to a hole and isn't stored on
+	// disk
+	ErrHole = errors.New("byte belongs to a hole")
+
+	// ErrEntryTooLarge is returned if a write would grow an entry beyond
+	// the maximum entry size
+	ErrEntryTooLarge = errors.New("entry would exceed the maximum entry size")
+
+	// ErrEntryMaxSize is returned if a write would grow an entry beyond the
+	// maximum size that was set with SetMaxSize
+	ErrEntryMaxSize = errors.New("entry would exceed its maximum size")
+)
+
 type (
 	// Entry is a single entry in the database. It implements the
 	// ReadWriteSeeker interface to enable easy writes to the file
@@ -23,25 +51,161 @@ type (
 
 		// cursorPage is the index of the page in pages to which the cursor points
 		cursorPage int64
+
+		// generation is the generation of the entry at the time it was
+		// opened. If it doesn't match the entryPage's generation anymore the
+		// handle is stale
+		generation uint64
+
+		// exclusive indicates that the handle was opened with OpenExclusive
+		exclusive bool
+
+		// cow is the state of a handle that was opened with OpenCOW. It is
+		// nil for regular handles
+		cow *cowState
+	}
+
+	// EntryStat describes an entry
+	EntryStat struct {
+		// Size is the size of the entry in bytes
+		Size int64
+
+		// ModTime is the time of the last modification of the entry. It is
+		// the zero time if TrackModTime isn't enabled
+		ModTime time.Time
+	}
+
+	// Range is a range of bytes of an entry that is read by ReadRanges
+	Range struct {
+		// Off is the offset of the first byte of the range
+		Off int64
+
+		// Length is the number of bytes of the range
+		Length int64
+	}
+
+	// PageState describes a single page of an entry
+	PageState struct {
+		// Index is the index of the page within the entry
+		Index int64
+
+		// FileOff is the offset of the page within the backing file
+		FileOff int64
+
+		// UsedSize is the number of bytes of the page that are used by the
+		// entry
+		UsedSize int64
+
+		// Full indicates if all the bytes of the page are used
+		Full bool
+
+		// Hole indicates that no page is allocated for the index. Holes
+		// read as zeros
+		Hole bool
+	}
+
+	// entryReaderAt is the io.ReaderAt returned by Entry.ReaderAt. It only
+	// reads the first size bytes of the entry
+	entryReaderAt struct {
+		e    *Entry
+		size int64
 	}
 )
 
-// Close is a no-op
+// Close releases the handle of the entry. An exclusive handle releases its
+// lock. Once the last handle of an unpinned entry is closed, its entryPage
+// becomes idle and stays cached until it is evicted
 func (e *Entry) Close() error {
 	e.ep.pm.mu.Lock()
 	defer e.ep.pm.mu.Unlock()
-	// If the remaining entries pointing to this entryPage is 0 we can delete
-	// it from the map
+	e.close()
+	return nil
+}
+
+// close is a helper function for Close. The pm.mu lock needs to be acquired
+func (e *Entry) close() {
+	// Release the entry if the handle is exclusive
+	if e.exclusive {
+		atomic.StoreUint32(&e.ep.atomicExclusive, 0)
+	}
+
+	// If the remaining entries pointing to this entryPage is 0 it becomes
+	// idle and might be evicted from the map. The map might already contain
+	// a different entryPage for the identifier if the entry was deleted.
 	e.ep.instanceCounter--
-	if e.ep.instanceCounter == 0 {
-		delete(e.ep.pm.entryPages, Identifier(e.ep.pp.fileOff))
+	id := Identifier(e.ep.pp.fileOff)
+	if e.ep.instanceCounter == 0 && atomic.LoadInt32(&e.ep.atomicPinned) == 0 && e.ep.pm.entryPages[id] == e.ep {
+		e.ep.pm.addIdleEntry(id)
+	}
+}
+
+// Pin keeps the entryPage and the pageTables of the entry in memory even if
+// all handles of the entry are closed and the cache is full. Every call to
+// Pin needs to be matched by a call to Unpin
+func (e *Entry) Pin() {
+	e.pm.mu.Lock()
+	defer e.pm.mu.Unlock()
+	atomic.AddInt32(&e.ep.atomicPinned, 1)
+}
+
+// Unpin releases a pin of the entry. Once the last pin is released, the
+// entry is cached like any other entry
+func (e *Entry) Unpin() error {
+	e.pm.mu.Lock()
+	defer e.pm.mu.Unlock()
+	if atomic.LoadInt32(&e.ep.atomicPinned) == 0 {
+		return errors.New("entry isn't pinned")
+	}
+	if atomic.AddInt32(&e.ep.atomicPinned, -1) > 0 {
+		return nil
+	}
+
+	// An unpinned entry without open handles becomes idle
+	id := Identifier(e.ep.pp.fileOff)
+	if e.ep.instanceCounter == 0 && e.pm.entryPages[id] == e.ep {
+		e.pm.addIdleEntry(id)
+	}
+	return nil
+}
+
+// checkWritable returns ErrLocked if the entry was opened exclusively by
+// another handle. Otherwise the entry is about to be modified and is marked as
+// unsynced. Modifications that only hold the ep.mu read lock can race with
+// Sync and need to mark the entry again once they are done
+func (e *Entry) checkWritable() error {
+	if !e.exclusive && atomic.LoadUint32(&e.ep.atomicExclusive) == 1 {
+		return ErrLocked
+	}
+	e.markUnsynced()
+	return nil
+}
+
+// markUnsynced marks the entry as modified since it was last synced
+func (e *Entry) markUnsynced() {
+	atomic.StoreUint32(&e.ep.atomicUnsynced, 1)
+}
+
+// checkMaxSize returns ErrEntryMaxSize if size exceeds the maximum size of
+// the entry. The ep.mu read lock needs to be acquired
+func (e *Entry) checkMaxSize(size int64) error {
+	if e.ep.maxSize > 0 && size > e.ep.maxSize {
+		return ErrEntryMaxSize
+	}
+	return nil
+}
+
+// checkGeneration returns ErrStaleHandle if the entry was deleted after the
+// handle was opened. The ep.mu read lock needs to be acquired
+func (e *Entry) checkGeneration() error {
+	if e.generation != e.ep.generation {
+		return ErrStaleHandle
 	}
 	return nil
 }
 
 // read is a helper function that reads at a specific cursorPage and offset
 func (e *Entry) read(p []byte, cursorPage *int64, cursorOff *int64) (n int, err error) {
-	if len(e.ep.pages) == 0 {
+	if e.ep.numPages() == 0 {
 		return 0, io.EOF
 	}
 
@@ -54,13 +218,32 @@ func (e *Entry) read(p []byte, cursorPage *int64, cursorOff *int64) (n int, err
 	readData := make([]byte, bytesToRead)
 	for bytesToRead > 0 {
 		// Abort if no more pages are left to read
-		if *cursorPage >= int64(len(e.ep.pages)) {
+		if *cursorPage >= e.ep.numPages() {
 			break
 		}
 
 		// Read the data from the page
+		var page *physicalPage
+		page, err = e.ep.page(*cursorPage)
+		if err != nil {
+			return 0, err
+		}
 		var bytesRead int
-		bytesRead, err = e.ep.pages[*cursorPage].readAt(readData, *cursorOff)
+		if page == nil {
+			// The page is a hole which reads as zeros
+			bytesRead, err = readHole(readData[:bytesToRead], *cursorOff, e.ep.holeSize(*cursorPage))
+		} else {
+			bytesRead, err = page.readAt(readData[:bytesToRead], *cursorOff)
+			if err != nil && err != io.EOF && e.pm.opts.ReadErrorPolicy == ReadErrorSkipZero {
+				// Salvage the rest of the entry by reading the page as
+				// zeros
+				bytesRead, err = readHole(readData[:bytesToRead], *cursorOff, page.usedSize)
+			}
+		}
+		if err == io.EOF {
+			// The end of the last page was reached
+			break
+		}
 		if err != nil {
 			return 0, err
 		}
@@ -85,17 +268,152 @@ func (e *Entry) read(p []byte, cursorPage *int64, cursorOff *int64) (n int, err
 	return copyDest, nil
 }
 
-// Read tries to read len(p) bytes from the current cursor position
+// readHole is a helper function that reads from a hole of size bytes
+// starting at off
+func readHole(b []byte, off int64, size int64) (int, error) {
+	if off >= size {
+		return 0, io.EOF
+	}
+	length := int64(len(b))
+	if length > size-off {
+		length = size - off
+	}
+	for i := range b[:length] {
+		b[i] = 0
+	}
+	return int(length), nil
+}
+
+// Read tries to read len(p) bytes from the current cursor position. Like
+// os.File, reading 0 bytes returns immediately
 func (e *Entry) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
 	e.ep.mu.RLock()
 	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, err
+	}
 	return e.read(p, &e.cursorPage, &e.cursorOff)
 }
 
-// ReadAt reads from a specific offset
+// ReadContext works like Read but returns ctx.Err() if ctx is done before the
+// read finishes. The cursor is only advanced by reads that finished. An
+// abandoned read keeps running in the background until the storage responds
+func (e *Entry) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// Read into a separate buffer using a copy of the cursor to not modify
+	// the caller's state after the read was abandoned
+	type readResult struct {
+		n          int
+		cursorPage int64
+		cursorOff  int64
+		err        error
+	}
+	done := make(chan readResult, 1)
+	buf := make([]byte, len(p))
+	cursorPage, cursorOff := e.cursorPage, e.cursorOff
+	go func() {
+		e.ep.mu.RLock()
+		defer e.ep.mu.RUnlock()
+		if err := e.checkGeneration(); err != nil {
+			done <- readResult{err: err}
+			return
+		}
+		n, err := e.read(buf, &cursorPage, &cursorOff)
+		done <- readResult{n, cursorPage, cursorOff, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			return r.n, r.err
+		}
+		copy(p, buf[:r.n])
+		e.cursorPage, e.cursorOff = r.cursorPage, r.cursorOff
+		return r.n, nil
+	}
+}
+
+// ReadAtLeast reads at least min bytes into p from the current cursor
+// position like io.ReadAtLeast. Fewer bytes are only read if the end of the
+// entry is reached in which case io.ErrUnexpectedEOF is returned. If no bytes
+// were read, io.EOF is returned
+func (e *Entry) ReadAtLeast(p []byte, min int) (int, error) {
+	if len(p) < min {
+		return 0, io.ErrShortBuffer
+	}
+
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, err
+	}
+	n, err := e.read(p, &e.cursorPage, &e.cursorOff)
+	if err == io.EOF && min == 0 {
+		return 0, nil
+	}
+	if err != nil {
+		return n, err
+	}
+	if n < min {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+// Peek returns the next n bytes from the current cursor position without
+// advancing the cursor. If fewer than n bytes are left, the remaining bytes are
+// returned together with io.ErrUnexpectedEOF. If no bytes are left, io.EOF is
+// returned
+func (e *Entry) Peek(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("can't peek %v bytes", n)
+	}
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return []byte{}, nil
+	}
+
+	// Read from the cursor's offset without moving the cursor
+	off := e.cursorPage*pageSize + e.cursorOff
+	cursorPage := int64(0)
+	cursorOff := int64(0)
+	if err := e.seek(off, &cursorPage, &cursorOff); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	read, err := e.read(b, &cursorPage, &cursorOff)
+	if err != nil {
+		return nil, err
+	}
+	if read < n {
+		return b[:read], io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
+
+// ReadAt reads from a specific offset. Like os.File, reading 0 bytes from a
+// valid offset returns immediately
 func (e *Entry) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 && off >= 0 {
+		return 0, nil
+	}
 	e.ep.mu.RLock()
 	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, err
+	}
 
 	// Seek to the offset from the beginning of the file
 	cursorPage := int64(0)
@@ -108,6 +426,119 @@ func (e *Entry) ReadAt(p []byte, off int64) (int, error) {
 	return e.read(p, &cursorPage, &cursorOff)
 }
 
+// Stat returns the size of the entry and the time of its last modification
+func (e *Entry) Stat() (EntryStat, error) {
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return EntryStat{}, err
+	}
+	e.ep.hashMu.Lock()
+	defer e.ep.hashMu.Unlock()
+	stat := EntryStat{Size: e.ep.usedSize}
+	if e.ep.modTime != 0 {
+		stat.ModTime = time.Unix(0, e.ep.modTime)
+	}
+	return stat, nil
+}
+
+// ReadRanges reads multiple ranges of the entry while holding the read lock
+// only once and returns their data in the same order. All ranges need to be
+// within the entry
+func (e *Entry) ReadRanges(ranges []Range) ([][]byte, error) {
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return nil, err
+	}
+
+	datas := make([][]byte, 0, len(ranges))
+	for i, r := range ranges {
+		if r.Off < 0 || r.Length < 0 || r.Off > e.ep.usedSize-r.Length {
+			return nil, fmt.Errorf("range %v of %v bytes at %v is out of bounds", i, r.Length, r.Off)
+		}
+		data := make([]byte, r.Length)
+		if err := e.readFull(data, r.Off); err != nil {
+			return nil, build.ExtendErr(fmt.Sprintf("failed to read range %v", i), err)
+		}
+		datas = append(datas, data)
+	}
+	return datas, nil
+}
+
+// ReaderAt returns an io.ReaderAt for the entry together with the size of the
+// entry. The reader only reads the bytes within that size and returns io.EOF
+// if fewer than the requested bytes are read. It can be used to create an
+// io.SectionReader
+func (e *Entry) ReaderAt() (io.ReaderAt, int64) {
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	return entryReaderAt{e: e, size: e.ep.usedSize}, e.ep.usedSize
+}
+
+// ReadAt reads len(p) bytes starting at off. If fewer bytes are read because
+// the end of the reader was reached, io.EOF is returned
+func (r entryReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("Cannot read at negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	truncated := false
+	if int64(len(p)) > r.size-off {
+		p = p[:r.size-off]
+		truncated = true
+	}
+	n, err := r.e.ReadAt(p, off)
+	if err == nil && (truncated || n < len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// ReadAtData reads from a specific offset like ReadAt but doesn't read holes as
+// zeros. If the range contains a hole, only the bytes in front of the first
+// hole are read and returned together with io.EOF
+func (e *Entry) ReadAtData(p []byte, off int64) (int, error) {
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, err
+	}
+
+	// Seek to the offset from the beginning of the file
+	cursorPage := int64(0)
+	cursorOff := int64(0)
+	if err := e.seek(off, &cursorPage, &cursorOff); err != nil {
+		return 0, err
+	}
+
+	// Only read up to the first hole
+	hole := false
+	for i := cursorPage; i*pageSize < off+int64(len(p)) && i < e.ep.numPages(); i++ {
+		page, err := e.ep.page(i)
+		if err != nil {
+			return 0, err
+		}
+		if page == nil && i*pageSize <= off {
+			return 0, io.EOF
+		}
+		if page == nil {
+			p = p[:i*pageSize-off]
+			hole = true
+			break
+		}
+	}
+
+	// Read the data
+	n, err := e.read(p, &cursorPage, &cursorOff)
+	if err == nil && hole {
+		err = io.EOF
+	}
+	return n, err
+}
+
 // seek is a helper function that seeks a specific offset starting at a
 // specified cursorPage and cursorOffset. It doesn't modify the Entry's fields
 // but instead the input values
@@ -123,8 +554,8 @@ func (e *Entry) seek(offset int64, cursorPage *int64, cursorOff *int64) error {
 	// If the page number is higher than the number of available pages set it to
 	// the number of available pages at offset 0 to signal other functions that
 	// we cannot continue reading
-	if cursorPageNew >= int64(len(e.ep.pages)) {
-		cursorPageNew = int64(len(e.ep.pages))
+	if cursorPageNew >= e.ep.numPages() {
+		cursorPageNew = e.ep.numPages()
 		cursorOffNew = 0
 	}
 
@@ -138,7 +569,15 @@ func (e *Entry) seek(offset int64, cursorPage *int64, cursorOff *int64) error {
 func (e *Entry) Seek(offset int64, whence int) (int64, error) {
 	e.ep.mu.RLock()
 	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, err
+	}
+	return e.seekWhence(offset, whence, &e.cursorPage, &e.cursorOff)
+}
 
+// seekWhence is a helper function that moves the specified cursor to offset
+// relative to whence and returns the new offset of the cursor
+func (e *Entry) seekWhence(offset int64, whence int, cursorPage *int64, cursorOff *int64) (int64, error) {
 	// Calculate the correct page and page offset
 	var pageNum int64
 	var pageOff int64
@@ -148,10 +587,10 @@ func (e *Entry) Seek(offset int64, whence int) (int64, error) {
 		pageNum = 0
 		pageOff = 0
 	case io.SeekCurrent:
-		pageNum = e.cursorPage
-		pageOff = e.cursorOff
+		pageNum = *cursorPage
+		pageOff = *cursorOff
 	case io.SeekEnd:
-		pageNum = int64(len(e.ep.pages))
+		pageNum = e.ep.numPages()
 		pageOff = 0
 	}
 
@@ -160,143 +599,1029 @@ func (e *Entry) Seek(offset int64, whence int) (int64, error) {
 		return 0, err
 	}
 
-	e.cursorPage = pageNum
-	e.cursorOff = pageOff
-
-	return e.cursorPage*pageSize + e.cursorOff, nil
-}
+	*cursorPage = pageNum
+	*cursorOff = pageOff
 
-// Sync calls sync on the underlying file of the Page Manager
-func (e *Entry) Sync() error {
-	return e.pm.file.Sync()
+	return *cursorPage*pageSize + *cursorOff, nil
 }
 
-// Truncate shortens an entry to size bytes
-func (e *Entry) Truncate(size int64) error {
-	e.ep.mu.Lock()
-	defer e.ep.mu.Unlock()
-
-	// Recursively truncate the tree
-	_, pagesToFree1, err := e.ep.recursiveTruncate(e.ep.root, size)
-	if err != nil {
-		return err
-	}
-
-	// Defrag the tree afterwards
-	pagesToFree2, err := e.ep.defrag()
-	if err != nil {
-		return err
+// Size returns the size of the entry in bytes. The size is read from disk once
+// when the entry is loaded and kept up to date in memory afterwards
+func (e *Entry) Size() (int64, error) {
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, err
 	}
-
-	// Free pages
-	return e.pm.freePages.addPages(append(pagesToFree1, pagesToFree2...))
+	return e.ep.usedSize, nil
 }
 
-// write is a helper function that writes at a specific cursorPage and offset
-func (e *Entry) write(p []byte, cursorPage *int64, cursorOff *int64) (int, error) {
-	// Get the amount of bytes the caller would like to write
-	bytesToWrite := int64(len(p))
-
-	// Inform the entryPage about new pages and the increase data usage
-	byteIncrease := int64(0)
-	addedPages := make([]*physicalPage, 0)
-
-	// backup cursorPage and cursorOff in case we need to reset the loop
-	bCursorPage := *cursorPage
-	bCursorOff := *cursorOff
-
-	// Write until all the bytes are written. If necessary allocate new pages
-	writeCursor := 0
-	appending := false
-	for bytesToWrite > 0 {
-		// Check if we are going to add a new page or extend the last page
-		if !appending &&
-			(*cursorPage >= int64(len(e.ep.pages)) ||
-				(*cursorPage == int64(len(e.ep.pages)-1) &&
-					*cursorOff+bytesToWrite > e.ep.pages[*cursorPage].usedSize)) {
-			// Seems like we are appending now. Change to write lock and
-			// restart loop.
-			appending = true
-			e.ep.mu.RUnlock()
-			e.ep.mu.Lock()
-			defer e.ep.mu.RLock()
-			defer e.ep.mu.Unlock()
-
-			// Reset loop
-			*cursorPage = bCursorPage
-			*cursorOff = bCursorOff
-			bytesToWrite = int64(len(p))
-			writeCursor = 0
-			byteIncrease = int64(0)
-			addedPages = make([]*physicalPage, 0)
-			continue
-		}
-
-		if *cursorPage >= int64(len(e.ep.pages)) {
-			// Allocate new page if necessary
-			newPage, err := e.pm.managedAllocatePage()
-			if err != nil {
-				return 0, err
-			}
-			// Add it to the list of pages and addedPages
-			addedPages = append(addedPages, newPage)
-			e.ep.pages = append(e.ep.pages, newPage)
+// TreeShape returns the height of the entry's pageTable tree, the maximum
+// number of children of a pageTable and the number of pages of the entry
+// including holes. A tree of height 0 consists of a single pageTable
+func (e *Entry) TreeShape() (height int64, fanout int, pageCount int64) {
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	return e.ep.root.height, numPageEntries, e.ep.numPages()
+}
 
-			// If we still don't have enough pages mark this page as full
-			if *cursorPage >= int64(len(e.ep.pages)) {
-				newPage.usedSize = pageSize
-				byteIncrease += pageSize
-			}
-			continue
-		}
+// PageMap returns the state of all the pages of the entry ordered by their
+// index
+func (e *Entry) PageMap() ([]PageState, error) {
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return nil, err
+	}
 
-		// Write parts of the data to the page and remember the size increase
-		// of the page
-		page := e.ep.pages[*cursorPage]
-		usedPageSize := page.usedSize
-		bytesWritten, err := page.writeAt(p[writeCursor:], *cursorOff)
-		byteIncrease += (page.usedSize - usedPageSize)
+	states := make([]PageState, 0, e.ep.numPages())
+	for i := int64(0); i < e.ep.numPages(); i++ {
+		page, err := e.ep.page(i)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-
-		// Adjust the remaining bytesToWrite and the cursor position
-		bytesToWrite -= int64(bytesWritten)
-		err = e.seek(int64(bytesWritten), cursorPage, cursorOff)
-		if err != nil {
-			return 0, err
+		if page == nil {
+			holeSize := e.ep.holeSize(i)
+			states = append(states, PageState{
+				Index:    i,
+				UsedSize: holeSize,
+				Full:     holeSize == pageSize,
+				Hole:     true,
+			})
+			continue
 		}
+		states = append(states, PageState{
+			Index:    i,
+			FileOff:  page.fileOff,
+			UsedSize: page.usedSize,
+			Full:     page.usedSize == pageSize,
+		})
+	}
+	return states, nil
+}
 
-		// Increment the writeCursor of the input data
-		writeCursor += bytesWritten
+// PhysicalOffset returns the offset within the file of the byte at logicalOff
+// and the number of contiguous bytes of the entry that start at that offset
+// within the same page. ErrHole is returned if the byte isn't stored on disk
+func (e *Entry) PhysicalOffset(logicalOff int64) (fileOff int64, contiguousLen int64, err error) {
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, 0, err
 	}
-	err := e.ep.addPages(addedPages, byteIncrease)
-	if err != nil {
-		return 0, build.ExtendErr("failed to add pages to entryPage", err)
+	if logicalOff < 0 || logicalOff >= e.ep.usedSize {
+		return 0, 0, fmt.Errorf("offset %v is out of bounds", logicalOff)
 	}
 
-	return len(p), nil
+	page, err := e.ep.page(logicalOff / pageSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	if page == nil {
+		return 0, 0, ErrHole
+	}
+	pageOff := logicalOff % pageSize
+	return page.fileOff + pageOff, page.usedSize - pageOff, nil
 }
 
-// Write tries to write len(p) byte to the current cursor position
-func (e *Entry) Write(p []byte) (int, error) {
+// Check verifies the internal invariants of the entry's pageTable tree and
+// returns an error if the entry is corrupt
+func (e *Entry) Check() error {
+	// Checking the entry requires the whole tree to be loaded
+	if err := e.ep.managedLoadTree(); err != nil {
+		return err
+	}
+
 	e.ep.mu.RLock()
 	defer e.ep.mu.RUnlock()
-	return e.write(p, &e.cursorPage, &e.cursorOff)
+	if err := e.checkGeneration(); err != nil {
+		return err
+	}
+	stat, err := e.pm.file.Stat()
+	if err != nil {
+		return build.ExtendErr("failed to get size of file", err)
+	}
+	return e.ep.check(stat.Size())
 }
 
-// WriteAt writes to a specific offset
-func (e *Entry) WriteAt(p []byte, off int64) (n int, err error) {
+// Sync calls sync on the underlying file of the Page Manager if the entry was
+// modified since it was last synced
+func (e *Entry) Sync() error {
 	e.ep.mu.RLock()
 	defer e.ep.mu.RUnlock()
-
-	// Seek to the offset from the beginning of the file
-	cursorPage := int64(0)
-	cursorOff := int64(0)
-	if err := e.seek(off, &cursorPage, &cursorOff); err != nil {
-		return 0, err
+	if err := e.checkGeneration(); err != nil {
+		return err
 	}
+	if atomic.SwapUint32(&e.ep.atomicUnsynced, 0) == 0 {
+		return nil
+	}
+	if err := e.pm.file.Sync(); err != nil {
+		atomic.StoreUint32(&e.ep.atomicUnsynced, 1)
+		return err
+	}
+	return nil
+}
 
-	// Write data
-	return e.write(p, &cursorPage, &cursorOff)
+// Flush writes the buffered pageTables and the metadata of the entry to disk
+// and syncs the file. The sync might also persist the writes of other entries
+// since they share the file but only the flushed entry is guaranteed to be
+// durable
+func (e *Entry) Flush() error {
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return err
+	}
+	if err := e.ep.flushTables(); err != nil {
+		return err
+	}
+	if err := writeTieredPageEntry(e.ep.pp, e.ep.root.height, e.ep.usedSize, e.ep.root.pp.fileOff); err != nil {
+		return build.ExtendErr("failed to write entry metadata", err)
+	}
+	if err := e.pm.file.Sync(); err != nil {
+		return err
+	}
+	atomic.StoreUint32(&e.ep.atomicUnsynced, 0)
+	return nil
+}
+
+// GrowFill extends an entry to size bytes and fills the added region with
+// the fill byte
+func (e *Entry) GrowFill(size int64, fill byte) error {
+	if err := e.managedCopyOnWrite(); err != nil {
+		return err
+	}
+	// Modifying the entry requires the whole tree to be loaded
+	if err := e.ep.managedLoadTree(); err != nil {
+		return err
+	}
+
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return err
+	}
+	if err := e.checkWritable(); err != nil {
+		return err
+	}
+
+	// GrowFill can't be used to shrink an entry
+	if size < e.ep.usedSize {
+		return errors.New("Cannot grow entry to a smaller size")
+	}
+	if err := e.grow(size, fill); err != nil {
+		return err
+	}
+	return e.touch()
+}
+
+// grow is a helper function that extends an entry to size bytes by writing
+// fill to the added region. The ep.mu write lock needs to be acquired.
+func (e *Entry) grow(size int64, fill byte) (err error) {
+	remainingBytes := size - e.ep.usedSize
+	if remainingBytes <= 0 {
+		return nil
+	}
+	if err := e.checkMaxSize(size); err != nil {
+		return err
+	}
+	if err := e.invalidateHash(); err != nil {
+		return err
+	}
+
+	// Undo the changes to the pages if growing the entry fails
+	numPages, lastPageSize := e.pagesSnapshot()
+	defer func() {
+		if err != nil {
+			err = e.rollbackPages(numPages, lastPageSize, err)
+		}
+	}()
+
+	// Prepare a page worth of fill data. Recycled pages are not guaranteed to
+	// be zeroed which is why we need to write the fill even if it is 0
+	fillData := bytes.Repeat([]byte{fill}, pageSize)
+
+	// Inform the entryPage about new pages and the increase data usage
+	byteIncrease := int64(0)
+	addedPages := make([]*physicalPage, 0)
+
+	// Fill up the pages that are already allocated first. These are the last
+	// page if it isn't full yet and the pages that were kept by TrimToSize
+	for index := e.ep.usedSize / pageSize; index < int64(len(e.ep.pages)) && remainingBytes > 0; index++ {
+		// Holes need to be materialized before they can be filled
+		page := e.ep.pages[index]
+		if page == nil {
+			page, err = e.materializePage(index)
+			if err != nil {
+				return err
+			}
+		}
+		length := pageSize - page.usedSize
+		if length > remainingBytes {
+			length = remainingBytes
+		}
+		bytesWritten, err := page.writeAt(fillData[:length], page.usedSize)
+		if err != nil {
+			return err
+		}
+		byteIncrease += int64(bytesWritten)
+		remainingBytes -= int64(bytesWritten)
+	}
+
+	// Allocate new pages for the remaining bytes
+	for remainingBytes > 0 {
+		newPage, err := e.pm.managedAllocatePage()
+		if err != nil {
+			return err
+		}
+		addedPages = append(addedPages, newPage)
+		e.ep.pages = append(e.ep.pages, newPage)
+
+		length := int64(pageSize)
+		if length > remainingBytes {
+			length = remainingBytes
+		}
+		bytesWritten, err := newPage.writeAt(fillData[:length], 0)
+		if err != nil {
+			return err
+		}
+		byteIncrease += int64(bytesWritten)
+		remainingBytes -= int64(bytesWritten)
+	}
+
+	if err := e.ep.addPages(addedPages, byteIncrease); err != nil {
+		return extendErr("failed to add pages to entryPage", err)
+	}
+	return nil
+}
+
+// growSparse is a helper function that extends an entry to size bytes. The
+// last page is zero-filled and the rest of the added region is added as holes.
+// The ep.mu write lock needs to be acquired.
+func (e *Entry) growSparse(size int64) error {
+	if err := e.checkMaxSize(size); err != nil {
+		return err
+	}
+
+	// Fill up the last page first
+	lastPageEnd := int64(len(e.ep.pages)) * pageSize
+	if lastPageEnd > size {
+		lastPageEnd = size
+	}
+	if err := e.grow(lastPageEnd, 0); err != nil {
+		return err
+	}
+	remainingBytes := size - e.ep.usedSize
+	if remainingBytes <= 0 {
+		return nil
+	}
+
+	// Add holes for the remaining bytes
+	numHoles := (remainingBytes + pageSize - 1) / pageSize
+	holes := make([]*physicalPage, numHoles)
+	e.ep.pages = append(e.ep.pages, holes...)
+	if err := e.ep.addPages(holes, remainingBytes); err != nil {
+		e.ep.pages = e.ep.pages[:len(e.ep.pages)-len(holes)]
+		return extendErr("failed to add holes to entryPage", err)
+	}
+	return nil
+}
+
+// Truncate changes the size of an entry to size bytes. If the entry grows,
+// the added region is zero-filled
+func (e *Entry) Truncate(size int64) error {
+	_, _, err := e.TruncateN(size)
+	return err
+}
+
+// TruncateN works like Truncate but also returns the number of bytes and
+// pages that were freed. The freed pages include the pageTables that are no
+// longer needed
+func (e *Entry) TruncateN(size int64) (freedBytes int64, freedPages int, err error) {
+	if err := e.managedCopyOnWrite(); err != nil {
+		return 0, 0, err
+	}
+	// Shrinking a partially recovered tree only loads the pageTables that
+	// are truncated
+	if err := e.ep.managedPrepareTruncate(size); err != nil {
+		return 0, 0, err
+	}
+
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, 0, err
+	}
+	if err := e.checkWritable(); err != nil {
+		return 0, 0, err
+	}
+	return e.truncate(size)
+}
+
+// truncate is a helper function that changes the size of an entry and returns
+// the number of freed bytes and pages. The ep.mu write lock needs to be
+// acquired
+func (e *Entry) truncate(size int64) (int64, int, error) {
+	usedSize := e.ep.usedSize
+	pagesToFree, err := e.resize(size)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Free pages
+	if err := e.pm.managedAddFreePages(pagesToFree); err != nil {
+		return 0, 0, err
+	}
+	if size >= usedSize {
+		return 0, 0, nil
+	}
+	return usedSize - e.ep.usedSize, len(pagesToFree), nil
+}
+
+// resize is a helper function for truncate that changes the size of an entry.
+// It returns the pages that are no longer needed without freeing them. The
+// ep.mu write lock needs to be acquired
+func (e *Entry) resize(size int64) ([]*physicalPage, error) {
+	if size < 0 {
+		return nil, errors.New("Cannot truncate entry to negative size")
+	}
+
+	// Grow the entry if necessary
+	if size > e.ep.usedSize {
+		if err := e.grow(size, 0); err != nil {
+			return nil, err
+		}
+		return nil, e.touch()
+	}
+	usedSize := e.ep.usedSize
+	if err := e.invalidateHash(); err != nil {
+		return nil, err
+	}
+
+	// Clearing the entry doesn't need to remove the pages one by one
+	prog := e.pm.newProgress(usedSize - size)
+	var pagesToFree []*physicalPage
+	var err error
+	if size == 0 && usedSize > 0 {
+		pagesToFree, err = e.ep.truncateAll()
+	} else {
+		pagesToFree, err = e.ep.truncateTree(size, prog)
+	}
+	if err != nil {
+		return nil, err
+	}
+	prog.update(usedSize - size)
+	return pagesToFree, e.touch()
+}
+
+// managedResize changes the size of an entry like Truncate but returns the
+// pages that are no longer needed instead of freeing them
+func (e *Entry) managedResize(size int64) ([]*physicalPage, error) {
+	// Shrinking a partially recovered tree only loads the pageTables that
+	// are truncated
+	if err := e.ep.managedPrepareTruncate(size); err != nil {
+		return nil, err
+	}
+
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return nil, err
+	}
+	if err := e.checkWritable(); err != nil {
+		return nil, err
+	}
+	return e.resize(size)
+}
+
+// TrimToSize shrinks an entry to size bytes without freeing its pages. The
+// unused bytes of the new last page are zeroed and the pages after it are
+// kept for subsequent writes to the entry
+func (e *Entry) TrimToSize(size int64) error {
+	if err := e.managedCopyOnWrite(); err != nil {
+		return err
+	}
+	// Modifying the entry requires the whole tree to be loaded
+	if err := e.ep.managedLoadTree(); err != nil {
+		return err
+	}
+
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return err
+	}
+	if err := e.checkWritable(); err != nil {
+		return err
+	}
+
+	// TrimToSize can't be used to grow an entry
+	if size < 0 || size > e.ep.usedSize {
+		return fmt.Errorf("Cannot trim entry of size %v to %v", e.ep.usedSize, size)
+	}
+	if size == e.ep.usedSize {
+		return nil
+	}
+	if err := e.invalidateHash(); err != nil {
+		return err
+	}
+
+	// Zero the unused bytes of the new last page and mark the pages after it
+	// as unused
+	for index := size / pageSize; index < int64(len(e.ep.pages)); index++ {
+		page := e.ep.pages[index]
+		if page == nil {
+			continue
+		}
+		usedSize := size - index*pageSize
+		if usedSize < 0 {
+			usedSize = 0
+		}
+		if usedSize > 0 && usedSize < page.usedSize {
+			if _, err := page.writeAt(make([]byte, page.usedSize-usedSize), usedSize); err != nil {
+				return build.ExtendErr("failed to zero the end of the last page", err)
+			}
+		}
+		page.usedSize = usedSize
+	}
+
+	// Update the usedSize on disk
+	e.ep.usedSize = size
+	if err := writeTieredPageEntry(e.ep.pp, e.ep.root.height, e.ep.usedSize, e.ep.root.pp.fileOff); err != nil {
+		return err
+	}
+	return e.touch()
+}
+
+// Defragment moves the pages of the entry to a contiguous run of newly
+// allocated pages at the end of the file and frees the old pages afterwards.
+// Holes stay holes and pages that only contain zeros aren't relocated but
+// become holes
+func (e *Entry) Defragment() error {
+	if err := e.managedCopyOnWrite(); err != nil {
+		return err
+	}
+	// Modifying the entry requires the whole tree to be loaded
+	if err := e.ep.managedLoadTree(); err != nil {
+		return err
+	}
+
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return err
+	}
+	if err := e.checkWritable(); err != nil {
+		return err
+	}
+
+	// Collect the pages and check if they are contiguous already
+	var indices []uint64
+	var oldPages []*physicalPage
+	contiguous := true
+	for i, page := range e.ep.pages {
+		if page == nil {
+			continue
+		}
+		if len(oldPages) > 0 && page.fileOff != oldPages[len(oldPages)-1].fileOff+pageSize {
+			contiguous = false
+		}
+		indices = append(indices, uint64(i))
+		oldPages = append(oldPages, page)
+	}
+	if contiguous {
+		return nil
+	}
+
+	// Copy the data to the new pages. Zero pages are skipped and the unused
+	// new pages at the end of the run are returned
+	newPages, err := e.pm.managedAllocateExtent(int64(len(oldPages)))
+	if err != nil {
+		return build.ExtendErr("failed to allocate contiguous pages", err)
+	}
+	replacements := make([]*physicalPage, len(oldPages))
+	used := 0
+	data := make([]byte, pageSize)
+	for i, page := range oldPages {
+		// Pages that were kept beyond the end of the entry by TrimToSize
+		// don't contain data and are relocated without copying
+		if page.usedSize == 0 {
+			replacements[i] = newPages[used]
+			used++
+			continue
+		}
+		_, err = page.readAt(data[:page.usedSize], 0)
+		if err == nil && isZero(data[:page.usedSize]) {
+			continue
+		}
+		if err == nil {
+			_, err = newPages[used].writeAt(data[:page.usedSize], 0)
+		}
+		if err != nil {
+			// Return the new pages if the data couldn't be copied
+			if freeErr := e.pm.managedReturnExtent(newPages); freeErr != nil {
+				return build.ExtendErr(fmt.Sprintf("failed to free pages after error '%v'", err), freeErr)
+			}
+			return build.ExtendErr("failed to copy page", err)
+		}
+		replacements[i] = newPages[used]
+		used++
+	}
+
+	// Point the tree to the new pages. The data is the same which is why a
+	// crash in the middle only leaks the old pages
+	for i, index := range indices {
+		if err := e.ep.replacePage(index, replacements[i]); err != nil {
+			return build.ExtendErr("failed to replace page", err)
+		}
+	}
+	if err := e.pm.managedReturnExtent(newPages[used:]); err != nil {
+		return build.ExtendErr("failed to return unused pages", err)
+	}
+	return e.pm.managedAddFreePages(oldPages)
+}
+
+// isZero returns true if b only contains zeros
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Replace replaces the contents of the entry with the data read from r. The
+// data is written to new pages which are swapped with the pages of the entry
+// once they are durable. A crash leaves the entry with either the old or the
+// new contents. The old pages are freed after the swap was synced. The
+// scratch entry that holds the new pages is logged to finish or undo the
+// replacement when the PageManager is recovered after a crash
+func (e *Entry) Replace(r io.Reader) error {
+	if err := e.managedCopyOnWrite(); err != nil {
+		return err
+	}
+	p := e.pm
+	p.txnMu.Lock()
+	defer p.txnMu.Unlock()
+	id := Identifier(e.ep.pp.fileOff)
+	if logged, _, _, err := readReplaceLog(p.freePages.pp); err != nil {
+		return build.ExtendErr("failed to read replace log", err)
+	} else if logged != 0 {
+		return fmt.Errorf("replacement of entry %v wasn't finished", logged)
+	}
+
+	// Log the scratch entry before writing the new contents to it
+	scratch, scratchID, err := p.Create()
+	if err != nil {
+		return build.ExtendErr("failed to create scratch entry", err)
+	}
+	err = writeReplaceLog(p.freePages.pp, id, scratchID, nil)
+	if err == nil {
+		err = p.file.Sync()
+	}
+	if err == nil {
+		_, err = io.Copy(scratch, r)
+	}
+	if err == nil {
+		err = scratch.Flush()
+	}
+
+	// Log the tree of the scratch entry to finish the swap on recovery
+	slots := make([]byte, numTreeSlots*tieredPageEntrySize)
+	if err == nil {
+		_, err = scratch.ep.pp.readAt(slots, 0)
+	}
+	if err == nil {
+		err = writeReplaceLog(p.freePages.pp, id, scratchID, slots)
+	}
+	if err == nil {
+		err = p.file.Sync()
+	}
+	if err != nil {
+		scratch.Close()
+		return p.undoReplace(scratchID, err)
+	}
+
+	// Swap the trees. If that fails, the log is replayed on recovery
+	if err := e.swapTree(scratch.ep); err != nil {
+		scratch.Close()
+		return build.ExtendErr("failed to swap trees", err)
+	}
+	if err := p.file.Sync(); err != nil {
+		scratch.Close()
+		return build.ExtendErr("failed to sync swapped trees", err)
+	}
+
+	// Delete the scratch entry with the old tree before the log is cleared
+	if err := scratch.Close(); err != nil {
+		return err
+	}
+	return p.finishReplace(scratchID)
+}
+
+// undoReplace deletes the scratch entry of a replacement that failed before
+// the trees were swapped and clears the replace log
+func (p *PageManager) undoReplace(scratchID Identifier, cause error) error {
+	if err := p.finishReplace(scratchID); err != nil {
+		return build.ExtendErr(fmt.Sprintf("failed to delete scratch entry after error '%v'", cause), err)
+	}
+	return build.ExtendErr("failed to write scratch entry", cause)
+}
+
+// finishReplace deletes the scratch entry of a replacement and clears the
+// replace log afterwards. A scratch entry that no longer exists was deleted
+// before a crash
+func (p *PageManager) finishReplace(scratchID Identifier) error {
+	if p.Exists(scratchID) {
+		if err := p.Delete(scratchID); err != nil {
+			return build.ExtendErr("failed to delete scratch entry", err)
+		}
+		if err := p.file.Sync(); err != nil {
+			return build.ExtendErr("failed to sync deleted scratch entry", err)
+		}
+	}
+	if err := writeReplaceLog(p.freePages.pp, 0, 0, nil); err != nil {
+		return build.ExtendErr("failed to clear replace log", err)
+	}
+	return p.file.Sync()
+}
+
+// replayReplace finishes a replacement that was interrupted by a crash. If the
+// tree of the scratch entry wasn't logged, the scratch entry is deleted.
+// Otherwise the swap of the trees is completed before the scratch entry with
+// the old tree is deleted
+func (p *PageManager) replayReplace() error {
+	id, scratchID, slots, err := readReplaceLog(p.freePages.pp)
+	if err != nil {
+		return build.ExtendErr("failed to read replace log", err)
+	}
+	if id == 0 {
+		return nil
+	}
+	p.opts.Logger.Info("replaying replacement of entry %v", id)
+	if slots == nil || !p.Exists(scratchID) {
+		return p.finishReplace(scratchID)
+	}
+
+	// swapTree writes and syncs the scratch entry first. If the entry doesn't
+	// point to the new tree yet, the scratch entry either still points to
+	// the new tree or already to the old one
+	pp := &physicalPage{
+		file:     p.file,
+		fileOff:  int64(id),
+		usedSize: pageSize,
+		strict:   p.opts.StrictMode,
+	}
+	scratchPP := &physicalPage{
+		file:     p.file,
+		fileOff:  int64(scratchID),
+		usedSize: pageSize,
+		strict:   p.opts.StrictMode,
+	}
+	oldSlots := make([]byte, len(slots))
+	if _, err := pp.readAt(oldSlots, 0); err != nil {
+		return build.ExtendErr("failed to read slots", err)
+	}
+	if !bytes.Equal(oldSlots, slots) {
+		if _, err := scratchPP.writeAt(oldSlots, 0); err != nil {
+			return build.ExtendErr("failed to write slots", err)
+		}
+		if err := p.file.Sync(); err != nil {
+			return build.ExtendErr("failed to sync slots", err)
+		}
+		if _, err := pp.writeAt(slots, 0); err != nil {
+			return build.ExtendErr("failed to write slots", err)
+		}
+		if err := writeContentHash(pp, 0, nil); err != nil {
+			return build.ExtendErr("failed to invalidate content hash", err)
+		}
+		if err := p.file.Sync(); err != nil {
+			return build.ExtendErr("failed to sync slots", err)
+		}
+	}
+	return p.finishReplace(scratchID)
+}
+
+// write is a helper function that writes at a specific offset. The ep.mu read
+// lock needs to be acquired. Writes that stay within the used size of the
+// entry don't change its structure and are done in place while only holding
+// the read lock. Otherwise the lock is upgraded to the write lock for the
+// duration of the write.
+func (e *Entry) write(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("Cannot write at negative offset")
+	}
+	if off > e.pm.MaxEntrySize()-int64(len(p)) {
+		return 0, ErrEntryTooLarge
+	}
+	if err := e.checkMaxSize(off + int64(len(p))); err != nil {
+		return 0, err
+	}
+	if off+int64(len(p)) <= e.ep.usedSize && !e.ep.hasHoles(off, int64(len(p))) {
+		// Concurrent in-place writes to overlapping ranges are serialized
+		r := e.ep.ranges.lock(off, off+int64(len(p)))
+		defer e.ep.ranges.unlock(r)
+		return e.writeAndTouch(p, off)
+	}
+
+	// Seems like we are appending or writing to a hole. Change to write
+	// lock.
+	e.ep.mu.RUnlock()
+	e.ep.mu.Lock()
+	defer e.ep.mu.RLock()
+	defer e.ep.mu.Unlock()
+
+	// The entry might have been deleted or truncated while we didn't hold
+	// the lock
+	if err := e.checkGeneration(); err != nil {
+		return 0, err
+	}
+
+	// Zero-fill the entry up to the offset if the write starts beyond its
+	// end or leave holes if sparse writes are enabled
+	var err error
+	if e.pm.opts.SparseWrites {
+		err = e.growSparse(off)
+	} else {
+		err = e.grow(off, 0)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return e.writeAndTouch(p, off)
+}
+
+// writeAndTouch writes p to the pages of the entry at off like writePages and
+// marks the entry as unsynced and records the modification time afterwards. A
+// failed write might have modified some pages which is why it marks the entry
+// as well
+func (e *Entry) writeAndTouch(p []byte, off int64) (int, error) {
+	n, err := e.writePages(p, off)
+	if err != nil {
+		e.markUnsynced()
+		return n, err
+	}
+	return n, e.touch()
+}
+
+// writePages is a helper function that writes data to the pages of the entry
+// starting at off and allocates new pages if necessary. off must not be
+// beyond the end of the entry. The ep.mu write lock needs to be acquired if
+// the write appends data, otherwise the read lock will suffice
+func (e *Entry) writePages(p []byte, off int64) (n int, err error) {
+	if err := e.invalidateHash(); err != nil {
+		return 0, err
+	}
+
+	// Seek to the offset from the beginning of the file
+	cursorPage := int64(0)
+	cursorOff := int64(0)
+	if err := e.seek(off, &cursorPage, &cursorOff); err != nil {
+		return 0, err
+	}
+
+	// Undo the changes to the pages if the write fails
+	numPages, lastPageSize := e.pagesSnapshot()
+	defer func() {
+		if err != nil {
+			err = e.rollbackPages(numPages, lastPageSize, err)
+		}
+	}()
+
+	// Get the amount of bytes the caller would like to write
+	bytesToWrite := int64(len(p))
+
+	// Inform the entryPage about new pages and the increase data usage
+	byteIncrease := int64(0)
+	addedPages := make([]*physicalPage, 0)
+
+	// Write until all the bytes are written. If necessary allocate new pages
+	prog := e.pm.newProgress(bytesToWrite)
+	writeCursor := 0
+	for bytesToWrite > 0 {
+		// Allocate new page if necessary
+		if cursorPage >= int64(len(e.ep.pages)) {
+			newPage, err := e.pm.managedAllocatePage()
+			if err != nil {
+				return 0, err
+			}
+			// Add it to the list of pages and addedPages
+			addedPages = append(addedPages, newPage)
+			e.ep.pages = append(e.ep.pages, newPage)
+			continue
+		}
+
+		// Write parts of the data to the page and remember the size increase
+		// of the page
+		page := e.ep.pages[cursorPage]
+		if page == nil {
+			// Materialize the hole before writing to it
+			page, err = e.materializePage(cursorPage)
+			if err != nil {
+				return 0, err
+			}
+		}
+		usedPageSize := page.usedSize
+		bytesWritten, err := page.writeAt(p[writeCursor:], cursorOff)
+		byteIncrease += (page.usedSize - usedPageSize)
+		if err != nil {
+			return 0, err
+		}
+
+		// Adjust the remaining bytesToWrite and the cursor position
+		bytesToWrite -= int64(bytesWritten)
+		err = e.seek(int64(bytesWritten), &cursorPage, &cursorOff)
+		if err != nil {
+			return 0, err
+		}
+
+		// Increment the writeCursor of the input data
+		writeCursor += bytesWritten
+		prog.update(int64(writeCursor))
+	}
+	if err := e.ep.addPages(addedPages, byteIncrease); err != nil {
+		return 0, extendErr("failed to add pages to entryPage", err)
+	}
+
+	return len(p), nil
+}
+
+// materializePage replaces the hole at the specified index with a newly
+// allocated page that is zeroed up to the size of the hole. The ep.mu write
+// lock needs to be acquired
+func (e *Entry) materializePage(index int64) (*physicalPage, error) {
+	page, err := e.pm.managedAllocatePage()
+	if err != nil {
+		return nil, err
+	}
+	_, err = page.writeAt(make([]byte, e.ep.holeSize(index)), 0)
+	if err == nil {
+		err = e.ep.replacePage(uint64(index), page)
+	}
+	if err != nil {
+		// Free the page again if the hole couldn't be replaced
+		if freeErr := e.pm.managedAddFreePages([]*physicalPage{page}); freeErr != nil {
+			return nil, build.ExtendErr(fmt.Sprintf("failed to free page after error '%v'", err), freeErr)
+		}
+		return nil, extendErr("failed to materialize hole", err)
+	}
+	return page, nil
+}
+
+// pagesSnapshot returns the number of pages of the entry and the usedSize of
+// the last page. It is used together with rollbackPages to undo a failed
+// modification of the entry
+func (e *Entry) pagesSnapshot() (numPages int, lastPageSize int64) {
+	numPages = len(e.ep.pages)
+	if numPages > 0 && e.ep.pages[numPages-1] != nil {
+		lastPageSize = e.ep.pages[numPages-1].usedSize
+	} else if numPages > 0 {
+		lastPageSize = e.ep.holeSize(int64(numPages - 1))
+	}
+	return
+}
+
+// rollbackPages undoes the changes of a failed modification to the pages of
+// the entry. The pages that were appended after the first numPages pages are
+// removed and freed and the usedSize of the last remaining page is reset to
+// lastPageSize. It returns the error that caused the rollback. The ep.mu write
+// lock needs to be acquired if pages were appended
+func (e *Entry) rollbackPages(numPages int, lastPageSize int64, cause error) error {
+	if numPages > 0 && e.ep.pages[numPages-1] != nil && e.ep.pages[numPages-1].usedSize != lastPageSize {
+		e.ep.pages[numPages-1].usedSize = lastPageSize
+	}
+	if len(e.ep.pages) == numPages {
+		return cause
+	}
+
+	// Free the appended pages. Holes don't need to be freed
+	var pagesToFree []*physicalPage
+	for _, page := range e.ep.pages[numPages:] {
+		if page != nil {
+			pagesToFree = append(pagesToFree, page)
+		}
+	}
+	e.ep.pages = e.ep.pages[:numPages]
+	if err := e.pm.managedAddFreePages(pagesToFree); err != nil {
+		return build.ExtendErr(fmt.Sprintf("failed to free pages after error '%v'", cause), err)
+	}
+	return cause
+}
+
+// Write tries to write len(p) byte to the current cursor position. Like
+// os.File, writing 0 bytes returns immediately
+func (e *Entry) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := e.managedCopyOnWrite(); err != nil {
+		return 0, err
+	}
+	// Modifying the entry requires the whole tree to be loaded
+	if err := e.ep.managedLoadTree(); err != nil {
+		return 0, err
+	}
+
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, err
+	}
+	if err := e.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	// Write the data and move the cursor behind it
+	off := e.cursorPage*pageSize + e.cursorOff
+	n, err := e.write(p, off)
+	if err != nil {
+		return n, err
+	}
+	e.cursorPage = 0
+	e.cursorOff = 0
+	if err := e.seek(off+int64(n), &e.cursorPage, &e.cursorOff); err != nil {
+		return n, err
+	}
+	return n, e.pm.managedCheckpoint(int64(n))
+}
+
+// WriteAt writes to a specific offset. If the offset is beyond the end of the
+// entry, the gap is zero-filled or left as holes if SparseWrites is enabled.
+// Like os.File, writing 0 bytes to a valid offset returns immediately
+func (e *Entry) WriteAt(p []byte, off int64) (n int, err error) {
+	if len(p) == 0 && off >= 0 {
+		return 0, nil
+	}
+	if err := e.managedCopyOnWrite(); err != nil {
+		return 0, err
+	}
+	// Modifying the entry requires the whole tree to be loaded
+	if err := e.ep.managedLoadTree(); err != nil {
+		return 0, err
+	}
+
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, err
+	}
+	if err := e.checkWritable(); err != nil {
+		return 0, err
+	}
+	n, err = e.write(p, off)
+	if err != nil {
+		return n, err
+	}
+	return n, e.pm.managedCheckpoint(int64(n))
+}
+
+// WritePage writes p to the page of the entry with the specified index
+// starting at off bytes into the page. Only the pageSize-off bytes that fit
+// into the page are written. Like io.Writer, io.ErrShortWrite is returned
+// together with the number of written bytes if not all of p fit
+func (e *Entry) WritePage(index int64, p []byte, off int64) (int, error) {
+	if index < 0 {
+		return 0, errors.New("Cannot write to negative page index")
+	}
+	if off < 0 || off >= pageSize {
+		return 0, fmt.Errorf("Cannot write at offset %v of a page", off)
+	}
+	length := int64(len(p))
+	if length > pageSize-off {
+		length = pageSize - off
+	}
+	n, err := e.WriteAt(p[:length], index*pageSize+off)
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// SetMaxSize limits the size of the entry to n bytes. Writes and truncations
+// that would grow the entry beyond n bytes fail with ErrEntryMaxSize. The
+// limit is persisted on the entryPage. A limit of 0 removes it
+func (e *Entry) SetMaxSize(n int64) error {
+	if n < 0 {
+		return errors.New("maximum size can't be negative")
+	}
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return err
+	}
+	if err := e.checkWritable(); err != nil {
+		return err
+	}
+	if n > 0 && n < e.ep.usedSize {
+		return fmt.Errorf("entry of size %v already exceeds maximum size %v", e.ep.usedSize, n)
+	}
+	if err := writeMaxSize(e.ep.pp, e.ep.generation, n); err != nil {
+		return build.ExtendErr("failed to write maximum size", err)
+	}
+	e.ep.maxSize = n
+	return nil
 }
@@ -2,9 +2,14 @@ package pages
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/NebulousLabs/fastrand"
 )
@@ -442,3 +447,2392 @@ func TestWriteTruncateConcurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+// TestGrowFill tests if growing an entry with GrowFill pads the entry with the
+// fill byte
+func TestGrowFill(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Get a new entry
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write some data that doesn't fill a whole page
+	data := fastrand.Bytes(100)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatalf("Failed to write data: %v", err)
+	}
+
+	// Grow the entry to a few pages using 0xFF as the fill
+	size := int64(2*pageSize + 10)
+	if err := entry.GrowFill(size, 0xFF); err != nil {
+		t.Fatalf("Failed to grow entry: %v", err)
+	}
+	if entry.ep.usedSize != size {
+		t.Errorf("usedSize should be %v but was %v", size, entry.ep.usedSize)
+	}
+	if int64(len(entry.ep.pages)) != size/pageSize+1 {
+		t.Errorf("Entry should have %v pages but had %v", size/pageSize+1, len(entry.ep.pages))
+	}
+
+	// Read the data back. The original data should be followed by the padding
+	readData := make([]byte, size)
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatalf("Failed to read data: %v", err)
+	}
+	if bytes.Compare(data, readData[:len(data)]) != 0 {
+		t.Error("Original data was corrupted by GrowFill")
+	}
+	if bytes.Compare(bytes.Repeat([]byte{0xFF}, int(size)-len(data)), readData[len(data):]) != 0 {
+		t.Error("Padding doesn't match the fill byte")
+	}
+
+	// Shrinking the entry with GrowFill shouldn't work
+	if err := entry.GrowFill(size-1, 0xFF); err == nil {
+		t.Error("GrowFill shouldn't be able to shrink the entry")
+	}
+
+	// Growing with Truncate should zero-fill the entry. Truncate first to free
+	// pages that contain the padding to make sure recycled pages are zeroed
+	if err := entry.Truncate(int64(len(data))); err != nil {
+		t.Fatalf("Failed to truncate entry: %v", err)
+	}
+	if err := entry.Truncate(size); err != nil {
+		t.Fatalf("Failed to grow entry: %v", err)
+	}
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatalf("Failed to read data: %v", err)
+	}
+	if bytes.Compare(make([]byte, int(size)-len(data)), readData[len(data):]) != 0 {
+		t.Error("Growing with Truncate didn't zero-fill the entry")
+	}
+}
+
+// TestReadTruncateConcurrency tests if ReadAt and Read always see a consistent
+// view of an entry while other handles truncate it and the freed pages are
+// reused by another entry
+func TestReadTruncateConcurrency(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create the entry that is read and truncated
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	data := fastrand.Bytes(100 * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a second entry that reuses the freed pages
+	other, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	otherData := bytes.Repeat([]byte{0xFF}, len(data)/2)
+
+	// Define the reader's function. Whatever is read needs to match the
+	// original data
+	numThreads := 5
+	wg := new(sync.WaitGroup)
+	reader := func() {
+		defer wg.Done()
+		entry, err := pt.pm.Open(identifier)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer entry.Close()
+
+		readData := make([]byte, len(data))
+		for i := 0; i < 20; i++ {
+			n, err := entry.ReadAt(readData, 0)
+			if err != nil && err != io.EOF {
+				t.Error(err)
+				return
+			}
+			if bytes.Compare(data[:n], readData[:n]) != 0 {
+				t.Error("ReadAt returned data that wasn't written to the entry")
+				return
+			}
+			if _, err := entry.Seek(0, io.SeekStart); err != nil {
+				t.Error(err)
+				return
+			}
+			n, err = entry.Read(readData)
+			if err != nil && err != io.EOF {
+				t.Error(err)
+				return
+			}
+			if bytes.Compare(data[:n], readData[:n]) != 0 {
+				t.Error("Read returned data that wasn't written to the entry")
+				return
+			}
+		}
+	}
+
+	// Define the truncater's function. It truncates the entry using its own
+	// handle, lets the other entry reuse the freed pages and restores the
+	// entry afterwards
+	truncater := func() {
+		defer wg.Done()
+		entry, err := pt.pm.Open(identifier)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer entry.Close()
+
+		for i := 0; i < 20; i++ {
+			size := int64(fastrand.Intn(len(data)))
+			if err := entry.Truncate(size); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := other.Write(otherData); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := other.Truncate(0); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := entry.WriteAt(data[size:], size); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+
+	wg.Add(1)
+	go truncater()
+	for i := 0; i < numThreads; i++ {
+		wg.Add(1)
+		go reader()
+	}
+	wg.Wait()
+}
+
+// TestTruncateN tests if TruncateN returns the correct number of freed bytes
+// and pages
+func TestTruncateN(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Get a new entry
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write enough pages to create a tree with 2 levels
+	numPages := int64(numPageEntries + 10)
+	if _, err := entry.Write(fastrand.Bytes(int(numPages * pageSize))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncate half a page. No pages should be freed
+	size := numPages*pageSize - pageSize/2
+	freedBytes, freedPages, err := entry.TruncateN(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freedBytes != pageSize/2 || freedPages != 0 {
+		t.Errorf("Expected %v freed bytes and %v freed pages but was %v and %v",
+			pageSize/2, 0, freedBytes, freedPages)
+	}
+
+	// Truncate to a single page. This frees the data pages as well as the
+	// second leaf table and the root table of the tree
+	freedBytes, freedPages, err = entry.TruncateN(pageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freedBytes != size-pageSize || freedPages != int(numPages-1+2) {
+		t.Errorf("Expected %v freed bytes and %v freed pages but was %v and %v",
+			size-pageSize, numPages-1+2, freedBytes, freedPages)
+	}
+
+	// Growing the entry doesn't free anything
+	freedBytes, freedPages, err = entry.TruncateN(2 * pageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freedBytes != 0 || freedPages != 0 {
+		t.Errorf("Expected %v freed bytes and %v freed pages but was %v and %v",
+			0, 0, freedBytes, freedPages)
+	}
+}
+
+// TestTruncateZero tests if truncating an entry with a tree of multiple
+// levels to 0 frees all of its pages and leaves an empty entry that can be
+// recovered and written to
+func TestTruncateZero(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Write enough pages to create a tree with 2 levels
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	numPages := int64(numPageEntries + 10)
+	if _, err := entry.Write(fastrand.Bytes(int(numPages * pageSize))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncating to 0 frees the data pages and both leaf tables. The root is
+	// lowered and kept
+	freedBytes, freedPages, err := entry.TruncateN(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freedBytes != numPages*pageSize || freedPages != int(numPages+2) {
+		t.Errorf("Expected %v freed bytes and %v freed pages but was %v and %v",
+			numPages*pageSize, numPages+2, freedBytes, freedPages)
+	}
+	if entry.ep.root.height != 0 || len(entry.ep.pages) != 0 {
+		t.Errorf("Root should have height 0 and no pages but had height %v and %v pages",
+			entry.ep.root.height, len(entry.ep.pages))
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	orphans, err := pt.pm.FindOrphans([]Identifier{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("There should be no orphaned pages but there were %v", len(orphans))
+	}
+
+	// The empty entry is recovered and can be written to again
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pt.pm, err = New(pt.pm.file.(*os.File).Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	if size, err := entry.Size(); err != nil || size != 0 {
+		t.Fatalf("Size should be %v but was %v %v", 0, size, err)
+	}
+	data := fastrand.Bytes(3 * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// BenchmarkTruncateZero benchmarks truncating an entry of 100,000 pages to 0
+// using the single pass of truncateAll and the generic truncation that
+// removes the pages one by one
+func BenchmarkTruncateZero(b *testing.B) {
+	for _, generic := range []bool{false, true} {
+		b.Run(fmt.Sprintf("generic=%v", generic), func(b *testing.B) {
+			pt, err := newPagingTester(b.Name())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer pt.Close()
+			entry, _, err := pt.pm.Create()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer entry.Close()
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := entry.Truncate(100000 * pageSize); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if !generic {
+					if err := entry.Truncate(0); err != nil {
+						b.Fatal(err)
+					}
+					continue
+				}
+				entry.ep.mu.Lock()
+				pages, err := entry.ep.truncateTree(0, nil)
+				entry.ep.mu.Unlock()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := pt.pm.managedAddFreePages(pages); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestInPlaceWriteConcurrency tests if concurrent in-place writes to disjoint
+// regions of an entry work as expected
+func TestInPlaceWriteConcurrency(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and fill it with data
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	numThreads := 10
+	regionSize := 10*pageSize + 100
+	if _, err := entry.Write(fastrand.Bytes(numThreads * regionSize)); err != nil {
+		t.Fatal(err)
+	}
+	numPages := len(entry.ep.pages)
+
+	// Let every thread overwrite its own region a few times
+	data := fastrand.Bytes(numThreads * regionSize)
+	wg := new(sync.WaitGroup)
+	f := func(index int) {
+		defer wg.Done()
+		entry, err := pt.pm.Open(identifier)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer entry.Close()
+
+		offset := index * regionSize
+		for i := 0; i < 10; i++ {
+			if _, err := entry.WriteAt(data[offset:offset+regionSize], int64(offset)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+	for i := 0; i < numThreads; i++ {
+		wg.Add(1)
+		go f(i)
+	}
+	wg.Wait()
+
+	// The structure of the entry shouldn't have changed
+	if len(entry.ep.pages) != numPages {
+		t.Errorf("Entry should have %v pages but had %v", numPages, len(entry.ep.pages))
+	}
+	if entry.ep.usedSize != int64(len(data)) {
+		t.Errorf("usedSize should be %v but was %v", len(data), entry.ep.usedSize)
+	}
+
+	// Check the data
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// TestWriteAtBeyondEnd tests if writing beyond the end of an entry zero-fills
+// the gap
+func TestWriteAtBeyondEnd(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and write a partial page
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(100)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write beyond the end
+	offset := int64(2*pageSize + 10)
+	if _, err := entry.WriteAt(data, offset); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ep.usedSize != offset+int64(len(data)) {
+		t.Errorf("usedSize should be %v but was %v", offset+int64(len(data)), entry.ep.usedSize)
+	}
+
+	// Check the data
+	expected := append(data, make([]byte, offset-int64(len(data)))...)
+	expected = append(expected, data...)
+	readData := make([]byte, len(expected))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(expected, readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// TestSparseWriteAt tests if a write far beyond the end of an entry only
+// allocates the written page if SparseWrites is enabled
+func TestSparseWriteAt(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	pt.pm.opts.SparseWrites = true
+
+	// Create new entry and write beyond its end
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(100)
+	offset := int64(1000 * pageSize)
+	if _, err := entry.WriteAt(data, offset); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ep.usedSize != offset+int64(len(data)) {
+		t.Errorf("usedSize should be %v but was %v", offset+int64(len(data)), entry.ep.usedSize)
+	}
+
+	// Only the written page should have been allocated
+	pageMap, err := entry.PageMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocated := 0
+	for _, state := range pageMap {
+		if !state.Hole {
+			allocated++
+		}
+	}
+	if allocated != 1 {
+		t.Errorf("Only %v page should have been allocated but were %v", 1, allocated)
+	}
+
+	// Reopen the entry and check the data
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Check(); err != nil {
+		t.Fatal(err)
+	}
+	expected := append(make([]byte, offset), data...)
+	readData := make([]byte, len(expected))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, readData) {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// TestPageMap tests if PageMap returns the expected layout of an entry
+func TestPageMap(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and write 2.5 pages
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(2*pageSize + pageSize/2)
+	if _, err := entry.Write(fastrand.Bytes(int(size))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Compute the expected layout
+	expected := make([]PageState, 0)
+	for i, page := range entry.ep.pages {
+		usedSize := int64(pageSize)
+		if remaining := size - int64(i)*pageSize; remaining < pageSize {
+			usedSize = remaining
+		}
+		expected = append(expected, PageState{
+			Index:    int64(i),
+			FileOff:  page.fileOff,
+			UsedSize: usedSize,
+			Full:     usedSize == pageSize,
+		})
+	}
+	if len(expected) != 3 {
+		t.Fatalf("Entry should have %v pages but had %v", 3, len(expected))
+	}
+
+	// Compare it to the PageMap
+	pageMap, err := entry.PageMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pageMap) != len(expected) {
+		t.Fatalf("PageMap should contain %v pages but contained %v", len(expected), len(pageMap))
+	}
+	for i := range expected {
+		if pageMap[i] != expected[i] {
+			t.Errorf("PageState %v should be %v but was %v", i, expected[i], pageMap[i])
+		}
+	}
+	if pageMap[2].Full || !pageMap[1].Full {
+		t.Error("Only the last page should be partial")
+	}
+}
+
+// TestWriteNoSpace tests if a write that fails due to a full disk returns
+// ErrNoSpace and leaves the entry consistent
+func TestWriteNoSpace(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Inject faults into the file before any pages are allocated
+	ff := &faultyFile{backingFile: pt.pm.file}
+	pt.pm.file = ff
+
+	// Create new entry and write 2.5 pages
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(2*pageSize + pageSize/2)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	size := entry.ep.usedSize
+	numPages := len(entry.ep.pages)
+	lastPageSize := entry.ep.pages[numPages-1].usedSize
+
+	// Only allow for a single additional page and try to append 3 pages
+	stat, err := pt.pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ff.limit = stat.Size() + pageSize
+	if _, err := entry.Write(fastrand.Bytes(3 * pageSize)); err != ErrNoSpace {
+		t.Fatalf("Write should fail with %v but was %v", ErrNoSpace, err)
+	}
+
+	// The entry should be unchanged and the allocated page should be free
+	if entry.ep.usedSize != size {
+		t.Errorf("usedSize should be %v but was %v", size, entry.ep.usedSize)
+	}
+	if len(entry.ep.pages) != numPages {
+		t.Errorf("Entry should have %v pages but had %v", numPages, len(entry.ep.pages))
+	}
+	if entry.ep.pages[numPages-1].usedSize != lastPageSize {
+		t.Errorf("usedSize of last page should be %v but was %v",
+			lastPageSize, entry.ep.pages[numPages-1].usedSize)
+	}
+	if pt.pm.freePages.availablePages() != 1 {
+		t.Errorf("There should be %v free page but there were %v", 1, pt.pm.freePages.availablePages())
+	}
+
+	// The data should still be readable after reopening the entry
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+
+	// Writing should work again once there is space
+	ff.limit = 0
+	if _, err := entry.WriteAt(data, size); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ep.usedSize != 2*size {
+		t.Errorf("usedSize should be %v but was %v", 2*size, entry.ep.usedSize)
+	}
+}
+
+// TestCheck tests if Check detects a gap within the tree of an entry
+func TestCheck(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and write enough data for a tree of height 1
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(int(numPageEntries+2)*pageSize - 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry should be healthy
+	if err := entry.Check(); err != nil {
+		t.Fatalf("Healthy entry failed the check: %v", err)
+	}
+
+	// Introduce a gap
+	leaf := entry.ep.root.childTables[0]
+	page := leaf.childPages[10]
+	delete(leaf.childPages, 10)
+	if err := entry.Check(); err == nil {
+		t.Error("Check should detect the gap")
+	}
+
+	// Fix the gap again
+	leaf.childPages[10] = page
+	if err := entry.Check(); err != nil {
+		t.Errorf("Healthy entry failed the check: %v", err)
+	}
+}
+
+// TestProgress tests if the progress of large writes and truncations is
+// reported
+func TestProgress(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Record the reported progress
+	var done, total []int64
+	pt.pm.opts.ProgressFn = func(d, t int64) {
+		done = append(done, d)
+		total = append(total, t)
+	}
+	checkProgress := func(expectedTotal int64) {
+		if len(done) == 0 {
+			t.Fatal("Progress wasn't reported")
+		}
+		if len(done) > int(expectedTotal/progressInterval)+1 {
+			t.Errorf("Progress was reported too often: %v times", len(done))
+		}
+		for i := range done {
+			if total[i] != expectedTotal {
+				t.Errorf("Total should be %v but was %v", expectedTotal, total[i])
+			}
+			if i > 0 && done[i] <= done[i-1] {
+				t.Errorf("Progress should be increasing but %v <= %v", done[i], done[i-1])
+			}
+		}
+		if done[len(done)-1] != expectedTotal {
+			t.Errorf("Last progress should be %v but was %v", expectedTotal, done[len(done)-1])
+		}
+		done, total = nil, nil
+	}
+
+	// Write a large amount of data
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(300*pageSize + 10)
+	if _, err := entry.Write(fastrand.Bytes(int(size))); err != nil {
+		t.Fatal(err)
+	}
+	checkProgress(size)
+
+	// Truncate most of it again
+	if err := entry.Truncate(100); err != nil {
+		t.Fatal(err)
+	}
+	checkProgress(size - 100)
+}
+
+// TestReadHole tests if holes in an entry are read as zeros, survive
+// reopening the entry and are materialized when they are written to
+func TestReadHole(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and write 3.5 pages
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(3*pageSize + pageSize/2)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Turn the second page into a hole
+	leaf := entry.ep.root
+	freedPage := leaf.childPages[1]
+	leaf.childPages[1] = nil
+	entry.ep.pages[1] = nil
+	if err := leaf.writeToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.managedAddFreePages([]*physicalPage{freedPage}); err != nil {
+		t.Fatal(err)
+	}
+	copy(data[pageSize:2*pageSize], make([]byte, pageSize))
+
+	// Read across the hole
+	readData := make([]byte, 2*pageSize)
+	if _, err := entry.ReadAt(readData, pageSize/2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data[pageSize/2:pageSize/2+2*pageSize]) {
+		t.Error("Read data doesn't match the expected data")
+	}
+
+	// Reopen the entry and read the whole entry
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ep.pages[1] != nil {
+		t.Fatal("The hole should have been recovered")
+	}
+	readData = make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Error("Read data doesn't match the expected data")
+	}
+	if err := entry.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write to the middle of the hole
+	if _, err := entry.WriteAt([]byte{1, 2, 3}, pageSize+10); err != nil {
+		t.Fatal(err)
+	}
+	copy(data[pageSize+10:], []byte{1, 2, 3})
+	if entry.ep.pages[1] == nil {
+		t.Fatal("The hole should have been materialized")
+	}
+	readData = make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Error("Read data doesn't match the expected data")
+	}
+	if err := entry.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTrimToSize tests if TrimToSize keeps the pages of an entry allocated
+// and if subsequent writes reuse them
+func TestTrimToSize(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and write 5.5 pages
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(5*pageSize + pageSize/2)); err != nil {
+		t.Fatal(err)
+	}
+	numPages := len(entry.ep.pages)
+	stat, err := pt.pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSize := stat.Size()
+
+	// Trim the entry to 1.5 pages
+	size := int64(pageSize + pageSize/2)
+	if err := entry.TrimToSize(size); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ep.usedSize != size {
+		t.Errorf("usedSize should be %v but was %v", size, entry.ep.usedSize)
+	}
+	if len(entry.ep.pages) != numPages {
+		t.Errorf("Entry should still have %v pages but had %v", numPages, len(entry.ep.pages))
+	}
+	if err := entry.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The unused bytes of the last page should be zeroed
+	lastPage := entry.ep.pages[1]
+	tail := make([]byte, pageSize/2)
+	if _, err := lastPage.file.ReadAt(tail, lastPage.fileOff+pageSize/2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tail, make([]byte, len(tail))) {
+		t.Error("The end of the last page wasn't zeroed")
+	}
+
+	// Write 4 pages. No new pages should be allocated
+	data := fastrand.Bytes(4 * pageSize)
+	if _, err := entry.WriteAt(data, size); err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.ep.pages) != numPages {
+		t.Errorf("Entry should have %v pages but had %v", numPages, len(entry.ep.pages))
+	}
+	if stat, err = pt.pm.file.Stat(); err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != fileSize {
+		t.Errorf("File should have a size of %v but was %v", fileSize, stat.Size())
+	}
+	if err := entry.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Trim the entry again, reopen it and read the data
+	if err := entry.TrimToSize(size + pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.ep.pages) != numPages {
+		t.Errorf("Entry should have %v pages but had %v", numPages, len(entry.ep.pages))
+	}
+	readData := make([]byte, 2*pageSize)
+	n, err := entry.ReadAt(readData, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != pageSize || !bytes.Equal(readData[:n], data[:pageSize]) {
+		t.Error("Read data doesn't match written data")
+	}
+	if err := entry.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReadAtLeast tests if ReadAtLeast behaves like io.ReadAtLeast
+func TestReadAtLeast(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and write 2.5 pages
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(2*pageSize + pageSize/2)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		off      int64
+		bufSize  int
+		min      int
+		expected int
+		err      error
+	}{
+		// exactly min bytes are available
+		{pageSize, pageSize + pageSize/2, pageSize + pageSize/2, pageSize + pageSize/2, nil},
+		// more than min bytes are available
+		{0, 2 * pageSize, pageSize, 2 * pageSize, nil},
+		// fewer than min bytes are available
+		{2 * pageSize, pageSize, pageSize, pageSize / 2, io.ErrUnexpectedEOF},
+		// no bytes are available
+		{int64(len(data)), pageSize, 1, 0, io.EOF},
+		// the buffer is smaller than min
+		{0, 10, 11, 0, io.ErrShortBuffer},
+	}
+	for i, test := range tests {
+		if _, err := entry.Seek(test.off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, test.bufSize)
+		n, err := entry.ReadAtLeast(buf, test.min)
+		if err != test.err {
+			t.Errorf("%v: error should be %v but was %v", i, test.err, err)
+		}
+		if n != test.expected {
+			t.Errorf("%v: %v bytes should have been read but were %v", i, test.expected, n)
+		}
+		if !bytes.Equal(buf[:n], data[test.off:test.off+int64(n)]) {
+			t.Errorf("%v: read data doesn't match written data", i)
+		}
+	}
+}
+
+// TestPeek tests if Peek returns the next bytes without advancing the cursor
+func TestPeek(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and write 2.5 pages
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(2*pageSize + pageSize/2)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Peek across a page boundary and read the same bytes afterwards
+	off := int64(pageSize - 10)
+	if _, err := entry.Seek(off, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	peeked, err := entry.Peek(pageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, pageSize)
+	if _, err := entry.Read(readData); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(peeked, readData) || !bytes.Equal(peeked, data[off:off+pageSize]) {
+		t.Error("Peeked data doesn't match read data")
+	}
+
+	// Peek beyond the end of the entry
+	peeked, err = entry.Peek(pageSize)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("error should be %v but was %v", io.ErrUnexpectedEOF, err)
+	}
+	if !bytes.Equal(peeked, data[off+pageSize:]) {
+		t.Error("Peeked data doesn't match the remaining data")
+	}
+
+	// Peek at the end of the entry
+	if _, err := entry.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Peek(1); err != io.EOF {
+		t.Errorf("error should be %v but was %v", io.EOF, err)
+	}
+}
+
+// TestReadErrorPolicy tests if reads either fail or read unreadable pages as
+// zeros depending on the ReadErrorPolicy
+func TestReadErrorPolicy(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Inject faults into the file before any pages are allocated
+	uf := &unreadableFile{backingFile: pt.pm.file}
+	pt.pm.file = uf
+
+	// Create new entry and write 2.5 pages
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(2*pageSize + pageSize/2)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the second page unreadable
+	uf.badOff = entry.ep.pages[1].fileOff
+
+	// By default the read should fail
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err == nil {
+		t.Fatal("Reading the unreadable page should fail")
+	}
+
+	// The unreadable page should be read as zeros otherwise
+	pt.pm.opts.ReadErrorPolicy = ReadErrorSkipZero
+	n, err := entry.ReadAt(readData, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) {
+		t.Fatalf("%v bytes should have been read but were %v", len(data), n)
+	}
+	expected := append([]byte{}, data...)
+	copy(expected[pageSize:2*pageSize], make([]byte, pageSize))
+	if !bytes.Equal(readData, expected) {
+		t.Error("Read data doesn't match expected data")
+	}
+}
+
+// TestEntryFlush tests if the data of a flushed entry survives a crash
+func TestEntryFlush(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	path := pt.pm.file.(*os.File).Name()
+	cf := &crashFile{backingFile: pt.pm.file}
+	pt.pm.file = cf
+
+	// Write to two entries and flush the first one
+	entry1, id1, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry2, id2, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(3*pageSize + 10)
+	if _, err := entry1.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry1.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes to the second entry after the flush are lost
+	if _, err := entry2.Write(fastrand.Bytes(2 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash by recovering the contents of the file at the last
+	// sync
+	crashedPath := path + ".crashed"
+	if err := ioutil.WriteFile(crashedPath, cf.synced, 0600); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(crashedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	// The flushed entry should be intact
+	entry1, err = pm.Open(id1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry1.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Error("Read data doesn't match written data")
+	}
+
+	// The second entry shouldn't contain the unflushed writes
+	entry2, err = pm.Open(id2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := entry2.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 0 {
+		t.Errorf("Unflushed entry should be empty but had %v bytes", size)
+	}
+}
+
+// TestSyncClean tests if Sync only syncs the file if the entry was modified
+// since the last sync
+func TestSyncClean(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	cf := &countingFile{backingFile: pt.pm.file, writes: make(map[int64]int)}
+	pt.pm.file = cf
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sync := func(expected int) {
+		t.Helper()
+		if err := entry.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		if cf.syncs != expected {
+			t.Fatalf("There should be %v syncs but there were %v", expected, cf.syncs)
+		}
+	}
+
+	// The first sync after a write syncs the file and the second one doesn't
+	if _, err := entry.Write(fastrand.Bytes(100)); err != nil {
+		t.Fatal(err)
+	}
+	before := cf.syncs
+	sync(before + 1)
+	sync(before + 1)
+
+	// Writing makes the entry unsynced again
+	if _, err := entry.WriteAt(fastrand.Bytes(10), 5); err != nil {
+		t.Fatal(err)
+	}
+	sync(before + 2)
+
+	// Flush syncs the entry
+	if err := entry.Truncate(50); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	sync(cf.syncs)
+
+	// An in-place write that passed its checks before a concurrent Sync
+	// marks the entry again once it is done
+	if err := entry.checkWritable(); err != nil {
+		t.Fatal(err)
+	}
+	synced := cf.syncs + 1
+	sync(synced)
+	entry.ep.mu.RLock()
+	_, err = entry.writeAndTouch(fastrand.Bytes(10), 0)
+	entry.ep.mu.RUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sync(synced + 1)
+}
+
+// TestReadContext tests if ReadContext returns when the context's deadline is
+// exceeded even if the storage doesn't respond
+func TestReadContext(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Block reads before any pages are allocated
+	sf := &slowFile{backingFile: pt.pm.file, release: make(chan struct{})}
+	pt.pm.file = sf
+
+	// Create new entry and write 2.5 pages
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(2*pageSize + pageSize/2)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	// The read should time out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	readData := make([]byte, len(data))
+	start := time.Now()
+	if _, err := entry.ReadContext(ctx, readData); err != context.DeadlineExceeded {
+		t.Fatalf("error should be %v but was %v", context.DeadlineExceeded, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("ReadContext didn't return promptly")
+	}
+
+	// Once the storage responds, the read should start at the same position
+	close(sf.release)
+	n, err := entry.ReadContext(context.Background(), readData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) || !bytes.Equal(readData, data) {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// TestPhysicalOffset tests if PhysicalOffset maps logical offsets to the
+// offsets of the bytes within the file
+func TestPhysicalOffset(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	pt.pm.opts.SparseWrites = true
+
+	// Create new entry and write 2.5 pages after a hole of 1 page
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(2*pageSize + pageSize/2)
+	if _, err := entry.WriteAt(data, pageSize); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		off           int64
+		page          int
+		pageOff       int64
+		contiguousLen int64
+	}{
+		{pageSize, 1, 0, pageSize},
+		{pageSize + 100, 1, 100, pageSize - 100},
+		{2*pageSize - 1, 1, pageSize - 1, 1},
+		{2 * pageSize, 2, 0, pageSize},
+		{3*pageSize + 10, 3, 10, pageSize/2 - 10},
+	}
+	for i, test := range tests {
+		fileOff, contiguousLen, err := entry.PhysicalOffset(test.off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := entry.ep.pages[test.page].fileOff + test.pageOff; fileOff != expected {
+			t.Errorf("%v: file offset should be %v but was %v", i, expected, fileOff)
+		}
+		if contiguousLen != test.contiguousLen {
+			t.Errorf("%v: contiguous length should be %v but was %v", i, test.contiguousLen, contiguousLen)
+		}
+
+		// The byte should be stored at the returned offset
+		b := make([]byte, contiguousLen)
+		if _, err := pt.pm.file.ReadAt(b, fileOff); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data[test.off-pageSize:test.off-pageSize+contiguousLen]) {
+			t.Errorf("%v: data at the file offset doesn't match the entry's data", i)
+		}
+	}
+
+	// Holes and offsets beyond the end of the entry aren't stored on disk
+	if _, _, err := entry.PhysicalOffset(10); err != ErrHole {
+		t.Errorf("error should be %v but was %v", ErrHole, err)
+	}
+	if _, _, err := entry.PhysicalOffset(int64(pageSize + len(data))); err == nil {
+		t.Error("Offset beyond the end of the entry should be rejected")
+	}
+}
+
+// TestOverlappingWriteConcurrency tests if concurrent in-place writes to
+// overlapping ranges are serialized. Every range written by multiple threads
+// should end up with the data of a single thread
+func TestOverlappingWriteConcurrency(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry and fill it with data. Every thread writes two
+	// chunks and shares them with its neighbors
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	numThreads := 10
+	chunkSize := 3 * pageSize / 2
+	if _, err := entry.Write(make([]byte, (numThreads+1)*chunkSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Let every thread overwrite its range a few times
+	wg := new(sync.WaitGroup)
+	f := func(index int) {
+		defer wg.Done()
+		entry, err := pt.pm.Open(identifier)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer entry.Close()
+
+		data := bytes.Repeat([]byte{byte(index + 1)}, 2*chunkSize)
+		for i := 0; i < 20; i++ {
+			if _, err := entry.WriteAt(data, int64(index*chunkSize)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+	for i := 0; i < numThreads; i++ {
+		wg.Add(1)
+		go f(i)
+	}
+	wg.Wait()
+
+	// Every chunk should contain the data of a single thread
+	readData := make([]byte, (numThreads+1)*chunkSize)
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= numThreads; i++ {
+		chunk := readData[i*chunkSize : (i+1)*chunkSize]
+		if !bytes.Equal(chunk, bytes.Repeat(chunk[:1], chunkSize)) {
+			t.Errorf("Chunk %v contains the data of multiple threads", i)
+		}
+	}
+}
+
+// TestReadAtData tests if ReadAtData stops reading at the first hole
+func TestReadAtData(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	pt.pm.opts.SparseWrites = true
+
+	// Create new entry with a page of data, a hole and another page of data
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(3 * pageSize)
+	if _, err := entry.WriteAt(data[:pageSize], 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.WriteAt(data[2*pageSize:], 2*pageSize); err != nil {
+		t.Fatal(err)
+	}
+	copy(data[pageSize:2*pageSize], make([]byte, pageSize))
+
+	tests := []struct {
+		off    int64
+		length int
+		n      int
+		err    error
+	}{
+		{0, pageSize, pageSize, nil},
+		{100, 3*pageSize - 100, pageSize - 100, io.EOF},
+		{pageSize - 1, 2, 1, io.EOF},
+		{pageSize + 10, 10, 0, io.EOF},
+		{2*pageSize + 10, 100, 100, nil},
+		{3 * pageSize, 10, 0, io.EOF},
+	}
+	for i, test := range tests {
+		b := make([]byte, test.length)
+		n, err := entry.ReadAtData(b, test.off)
+		if n != test.n || err != test.err {
+			t.Errorf("%v: ReadAtData should return %v %v but returned %v %v",
+				i, test.n, test.err, n, err)
+		}
+		if !bytes.Equal(b[:n], data[test.off:test.off+int64(n)]) {
+			t.Errorf("%v: read data doesn't match written data", i)
+		}
+	}
+
+	// ReadAt still reads the hole as zeros
+	b := make([]byte, 3*pageSize)
+	if n, err := entry.ReadAt(b, 0); err != nil || n != len(b) {
+		t.Fatalf("ReadAt should read %v bytes but read %v %v", len(b), n, err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Error("ReadAt should read the hole as zeros")
+	}
+}
+
+// TestSizeNoReads tests if Size doesn't read from disk even if the tree of the
+// entry was loaded lazily
+func TestSizeNoReads(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	cf := &countingFile{backingFile: pt.pm.file, writes: make(map[int64]int)}
+	pt.pm.file = cf
+
+	// Create an entry and open it lazily
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(3*pageSize + 10)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pt.pm.opts.LazyOpen = true
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+
+	// Calling Size repeatedly doesn't read from disk
+	reads := cf.reads
+	for i := 0; i < 1000; i++ {
+		size, err := entry.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(data)) {
+			t.Fatalf("Size should be %v but was %v", len(data), size)
+		}
+	}
+	if cf.reads != reads {
+		t.Errorf("Size shouldn't read from disk but read %v times", cf.reads-reads)
+	}
+
+	// The size is updated in memory by writes
+	if _, err := entry.WriteAt([]byte{1}, int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	reads = cf.reads
+	if size, err := entry.Size(); err != nil || size != int64(len(data)+1) {
+		t.Errorf("Size should be %v but was %v %v", len(data)+1, size, err)
+	}
+	if cf.reads != reads {
+		t.Errorf("Size shouldn't read from disk but read %v times", cf.reads-reads)
+	}
+}
+
+// TestZeroLengthIO tests if reading and writing 0 bytes returns immediately
+// without modifying the entry or acquiring its lock
+func TestZeroLengthIO(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	data := fastrand.Bytes(pageSize + 10)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Seek(100, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		fn   func() (int, error)
+	}{
+		{"Read nil", func() (int, error) { return entry.Read(nil) }},
+		{"Read empty", func() (int, error) { return entry.Read([]byte{}) }},
+		{"ReadAt", func() (int, error) { return entry.ReadAt(nil, 10) }},
+		{"ReadAt end", func() (int, error) { return entry.ReadAt(nil, int64(len(data))) }},
+		{"Write nil", func() (int, error) { return entry.Write(nil) }},
+		{"Write empty", func() (int, error) { return entry.Write([]byte{}) }},
+		{"WriteAt", func() (int, error) { return entry.WriteAt(nil, 10) }},
+		{"WriteAt beyond end", func() (int, error) { return entry.WriteAt(nil, 10*pageSize) }},
+	}
+
+	// Hold the lock of the entry. The calls shouldn't block
+	entry.ep.mu.Lock()
+	for _, test := range tests {
+		done := make(chan struct{})
+		var n int
+		var err error
+		go func() {
+			n, err = test.fn()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: call blocked on the entry's lock", test.name)
+		}
+		if n != 0 || err != nil {
+			t.Errorf("%v: should return 0 <nil> but returned %v %v", test.name, n, err)
+		}
+	}
+	entry.ep.mu.Unlock()
+
+	// The entry and the cursor are unchanged
+	size, err := entry.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("Size should be %v but was %v", len(data), size)
+	}
+	if off, err := entry.Seek(0, io.SeekCurrent); err != nil || off != 100 {
+		t.Errorf("Cursor should be at %v but was at %v %v", 100, off, err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// TestReaderAt tests if the reader returned by ReaderAt can be used to create
+// an io.SectionReader and honors the io.ReaderAt contract
+func TestReaderAt(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	data := fastrand.Bytes(3*pageSize + 10)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	r, size := entry.ReaderAt()
+	if size != int64(len(data)) {
+		t.Fatalf("Size should be %v but was %v", len(data), size)
+	}
+
+	// Read a sub-range that spans multiple pages through a SectionReader
+	off, length := int64(pageSize-100), int64(2*pageSize)
+	sr := io.NewSectionReader(r, off, length)
+	readData, err := ioutil.ReadAll(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data[off:off+length]) {
+		t.Error("Read data doesn't match written data")
+	}
+
+	// Reads beyond the end of the reader return io.EOF
+	b := make([]byte, 100)
+	if n, err := r.ReadAt(b, size-10); n != 10 || err != io.EOF {
+		t.Errorf("ReadAt should return 10 %v but returned %v %v", io.EOF, n, err)
+	}
+	if !bytes.Equal(b[:10], data[len(data)-10:]) {
+		t.Error("Read data doesn't match written data")
+	}
+	if n, err := r.ReadAt(b, size); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt should return 0 %v but returned %v %v", io.EOF, n, err)
+	}
+
+	// The reader is bounded by the size at the time it was created
+	if _, err := entry.Write(fastrand.Bytes(pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.ReadAt(b, size); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt should return 0 %v but returned %v %v", io.EOF, n, err)
+	}
+}
+
+// TestDefragment tests if Defragment moves the pages of a fragmented entry to
+// contiguous pages without changing its data
+func TestDefragment(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Write to two entries alternately to interleave their pages
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	other, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	numPages := 5
+	var data []byte
+	for i := 0; i < numPages; i++ {
+		pageData := fastrand.Bytes(pageSize)
+		if i == numPages-1 {
+			pageData = pageData[:100]
+		}
+		if _, err := entry.Write(pageData); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Write(fastrand.Bytes(pageSize)); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, pageData...)
+	}
+	if entry.ep.pages[1].fileOff == entry.ep.pages[0].fileOff+pageSize {
+		t.Fatal("Pages of the entry should be fragmented")
+	}
+	freePages := pt.pm.freePages.availablePages()
+
+	// Defragment the entry
+	if err := entry.Defragment(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < numPages; i++ {
+		if entry.ep.pages[i].fileOff != entry.ep.pages[i-1].fileOff+pageSize {
+			t.Fatalf("Page %v at %v doesn't follow page at %v", i, entry.ep.pages[i].fileOff, entry.ep.pages[i-1].fileOff)
+		}
+	}
+	if pt.pm.freePages.availablePages() != freePages+numPages {
+		t.Errorf("There should be %v free pages but there were %v", freePages+numPages, pt.pm.freePages.availablePages())
+	}
+
+	// The data should be intact and the tree should point to the new pages
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Fatal("Data doesn't match after defragmenting")
+	}
+	pages := entry.ep.pages
+	if err := entry.ep.recoverTree(entry.ep.root.pp.fileOff, entry.ep.root.height); err != nil {
+		t.Fatal(err)
+	}
+	for i := range pages {
+		if entry.ep.pages[i].fileOff != pages[i].fileOff {
+			t.Errorf("Page %v should be at %v on disk but was at %v", i, pages[i].fileOff, entry.ep.pages[i].fileOff)
+		}
+	}
+
+	// Interleave the pages of another entry and trim it. The unused pages
+	// that TrimToSize keeps should be relocated as well
+	trimmed, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trimmed.Close()
+	for i := 0; i < 4; i++ {
+		if _, err := trimmed.Write(fastrand.Bytes(pageSize)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Write(fastrand.Bytes(pageSize)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := trimmed.TrimToSize(pageSize); err != nil {
+		t.Fatal(err)
+	}
+	data = make([]byte, pageSize)
+	if _, err := trimmed.ReadAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := trimmed.Defragment(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(trimmed.ep.pages); i++ {
+		if trimmed.ep.pages[i].fileOff != trimmed.ep.pages[i-1].fileOff+pageSize {
+			t.Fatalf("Page %v at %v doesn't follow page at %v", i, trimmed.ep.pages[i].fileOff, trimmed.ep.pages[i-1].fileOff)
+		}
+	}
+	if err := trimmed.Check(); err != nil {
+		t.Fatal(err)
+	}
+	readData = make([]byte, pageSize)
+	if _, err := trimmed.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Fatal("Data of trimmed entry doesn't match after defragmenting")
+	}
+}
+
+// TestDefragmentCopyFailure tests if the new pages are released by truncating
+// the file if Defragment fails to copy a page
+func TestDefragmentCopyFailure(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	uf := &unreadableFile{backingFile: pt.pm.file}
+	pt.pm.file = uf
+
+	// Write to two entries alternately to interleave their pages
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	other, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	for i := 0; i < 4; i++ {
+		if _, err := entry.Write(fastrand.Bytes(pageSize)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Write(fastrand.Bytes(pageSize)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stat, err := uf.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	freePages := pt.pm.freePages.availablePages()
+
+	// Make the last page of the entry unreadable. The file should shrink
+	// back to its previous size and no pages should become free
+	uf.badOff = entry.ep.pages[3].fileOff
+	if err := entry.Defragment(); err == nil {
+		t.Fatal("Defragment should fail")
+	}
+	stat2, err := uf.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat2.Size() != stat.Size() {
+		t.Errorf("File size should be %v but was %v", stat.Size(), stat2.Size())
+	}
+	if pt.pm.freePages.availablePages() != freePages {
+		t.Errorf("There should be %v free pages but there were %v", freePages, pt.pm.freePages.availablePages())
+	}
+}
+
+// TestWritePage tests if WritePage writes as much as fits into a single page
+// and signals the remaining bytes with io.ErrShortWrite
+func TestWritePage(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+
+	// Write data that fits into the page
+	data := fastrand.Bytes(pageSize + 100)
+	if n, err := entry.WritePage(0, data[:100], 0); err != nil || n != 100 {
+		t.Fatalf("WritePage should write %v bytes but wrote %v %v", 100, n, err)
+	}
+
+	// Write more than fits into the second page
+	off := int64(10)
+	n, err := entry.WritePage(1, data, off)
+	if err != io.ErrShortWrite {
+		t.Fatalf("WritePage should return %v but was %v", io.ErrShortWrite, err)
+	}
+	if int64(n) != pageSize-off {
+		t.Fatalf("WritePage should write %v bytes but wrote %v", pageSize-off, n)
+	}
+
+	// Only the bytes that fit should have been written
+	size, err := entry.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 2*pageSize {
+		t.Fatalf("Entry should have %v bytes but had %v", 2*pageSize, size)
+	}
+	readData := make([]byte, n)
+	if _, err := entry.ReadAt(readData, pageSize+off); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:n], readData) {
+		t.Error("Data doesn't match the written data")
+	}
+
+	// Offsets outside of the page are invalid
+	if _, err := entry.WritePage(0, data, pageSize); err == nil {
+		t.Error("Writing beyond the end of the page should fail")
+	}
+}
+
+// TestTreeShape tests if TreeShape reports the growth of an entry's tree
+func TestTreeShape(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+
+	// A single pageTable can hold numPageEntries pages
+	tests := []struct {
+		size   int64
+		height int64
+		pages  int64
+	}{
+		{0, 0, 0},
+		{10, 0, 1},
+		{numPageEntries * pageSize, 0, numPageEntries},
+		{numPageEntries*pageSize + 1, 1, numPageEntries + 1},
+	}
+	for _, test := range tests {
+		if err := entry.Truncate(test.size); err != nil {
+			t.Fatal(err)
+		}
+		height, fanout, pages := entry.TreeShape()
+		if height != test.height || fanout != numPageEntries || pages != test.pages {
+			t.Errorf("Entry of size %v should have shape (%v, %v, %v) but was (%v, %v, %v)",
+				test.size, test.height, numPageEntries, test.pages, height, fanout, pages)
+		}
+	}
+}
+
+// TestReplace tests if Replace swaps the contents of an entry and if a crash
+// at any sync leaves either the old or the new contents
+func TestReplace(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	path := pt.pm.file.(*os.File).Name()
+	cf := &crashFile{backingFile: pt.pm.file}
+	pt.pm.file = cf
+
+	// Create an entry and persist its data
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	oldData := fastrand.Bytes(3*pageSize + 10)
+	if _, err := entry.Write(oldData); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the data
+	synced := len(cf.history)
+	newData := fastrand.Bytes(5*pageSize + 20)
+	if err := entry.Replace(bytes.NewReader(newData)); err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(newData))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := entry.Size(); err != nil || size != int64(len(newData)) || !bytes.Equal(readData, newData) {
+		t.Fatal("Entry should contain the new data", size, err)
+	}
+
+	// Simulate a crash at every sync of the replacement
+	var numOld, numNew int
+	for i, contents := range cf.history[synced:] {
+		crashedPath := fmt.Sprintf("%v.crashed%v", path, i)
+		if err := ioutil.WriteFile(crashedPath, contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+		pm, err := New(crashedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crashed, err := pm.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(crashed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case bytes.Equal(data, oldData):
+			numOld++
+		case bytes.Equal(data, newData):
+			numNew++
+		default:
+			t.Errorf("Crash at sync %v mixed the old and new data", i)
+		}
+		crashed.Close()
+
+		// The scratch entry shouldn't be leaked and the log should be
+		// cleared
+		orphans, err := pm.FindOrphans([]Identifier{id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(orphans) > 0 {
+			t.Errorf("Crash at sync %v leaked pages %v", i, orphans)
+		}
+		if logged, _, _, err := readReplaceLog(pm.freePages.pp); err != nil || logged != 0 {
+			t.Errorf("Replace log should be cleared but was %v: %v", logged, err)
+		}
+		if err := pm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if numOld == 0 || numNew == 0 {
+		t.Errorf("Crashes should recover the old and the new data but recovered %v old and %v new", numOld, numNew)
+	}
+}
+
+// TestTruncateLazy tests if a lazily opened entry can be truncated without
+// loading its whole tree
+func TestTruncateLazy(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry with a tree of height 1 and 3 lowest pageTables
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	numPages := int(3 * numPageEntries)
+	data := fastrand.Bytes(numPages * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Open the entry lazily and truncate it into the second pageTable
+	pt.pm.opts.LazyOpen = true
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	freePages := pt.pm.freePages.availablePages()
+	size := int64(numPageEntries+10)*pageSize + 100
+	if err := entry.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first pageTable shouldn't have been loaded
+	if !entry.ep.partial {
+		t.Fatal("Tree shouldn't have been loaded completely")
+	}
+	if !entry.ep.root.childTables[0].unloaded {
+		t.Fatal("First pageTable shouldn't have been loaded")
+	}
+
+	// The truncated data pages and the last pageTable should be free
+	freed := numPages - int(numPageEntries+11) + 1
+	if pt.pm.freePages.availablePages() != freePages+freed {
+		t.Errorf("There should be %v free pages but there were %v", freePages+freed, pt.pm.freePages.availablePages())
+	}
+
+	// Check the data
+	readData := make([]byte, size)
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data[:size]) {
+		t.Fatal("Data doesn't match after truncating")
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The truncated tree should be consistent on disk
+	pt.pm.opts.LazyOpen = false
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	if err := entry.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := entry.Size(); err != nil || s != size {
+		t.Fatalf("Size should be %v but was %v %v", size, s, err)
+	}
+	readData, err = ioutil.ReadAll(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data[:size]) {
+		t.Fatal("Data doesn't match after reopening")
+	}
+}
+
+// TestDefragmentZeroPages tests if Defragment turns pages that only contain
+// zeros into holes instead of relocating them
+func TestDefragmentZeroPages(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Write to two entries alternately to interleave their pages. Every
+	// other page of the entry only contains zeros
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	other, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	numPages := 6
+	var data []byte
+	for i := 0; i < numPages; i++ {
+		pageData := make([]byte, pageSize)
+		if i%2 == 1 {
+			pageData = fastrand.Bytes(pageSize)
+		}
+		if _, err := entry.Write(pageData); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Write(fastrand.Bytes(pageSize)); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, pageData...)
+	}
+	fileSize := func() int64 {
+		stat, err := pt.pm.file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stat.Size()
+	}
+	size := fileSize()
+
+	// Only the non-zero pages should be relocated
+	if err := entry.Defragment(); err != nil {
+		t.Fatal(err)
+	}
+	if grown := fileSize() - size; grown != int64(numPages/2)*pageSize {
+		t.Errorf("File should grow by %v pages but grew by %v bytes", numPages/2, grown)
+	}
+	pageMap, err := entry.PageMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prev int64
+	for i, state := range pageMap {
+		if state.Hole != (i%2 == 0) {
+			t.Fatalf("Page %v should be a hole: %v", i, i%2 == 0)
+		}
+		if state.Hole {
+			continue
+		}
+		if prev != 0 && state.FileOff != prev+pageSize {
+			t.Errorf("Page %v at %v doesn't follow page at %v", i, state.FileOff, prev)
+		}
+		prev = state.FileOff
+	}
+
+	// The data should be intact
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Fatal("Data doesn't match after defragmenting")
+	}
+
+	// Trim the entry to its first non-zero page and interleave new pages.
+	// The unused pages that TrimToSize keeps contain no data. They are
+	// relocated instead of becoming holes like the zero pages
+	if err := entry.TrimToSize(2 * pageSize); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := other.Write(fastrand.Bytes(pageSize)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.WriteAt(fastrand.Bytes(pageSize), int64(numPages+i)*pageSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := entry.TrimToSize(2 * pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Defragment(); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Check(); err != nil {
+		t.Fatal(err)
+	}
+	pageMap, err = entry.PageMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pageMap[0].Hole || pageMap[1].Hole {
+		t.Fatal("The first page should be a hole and the second one shouldn't")
+	}
+	readData = make([]byte, 2*pageSize)
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:2*pageSize], readData) {
+		t.Fatal("Data doesn't match after defragmenting trimmed entry")
+	}
+}
+
+// TestSetMaxSize tests if writes and truncations can't grow an entry beyond
+// its maximum size and if the maximum size is persisted
+func TestSetMaxSize(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Limit the size of a new entry and write up to the limit
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxSize := int64(3*pageSize + 10)
+	if err := entry.SetMaxSize(maxSize); err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(int(maxSize))
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Growing the entry any further fails
+	if _, err := entry.Write([]byte{1}); err != ErrEntryMaxSize {
+		t.Errorf("Error should be %v but was %v", ErrEntryMaxSize, err)
+	}
+	if err := entry.Truncate(maxSize + 1); err != ErrEntryMaxSize {
+		t.Errorf("Error should be %v but was %v", ErrEntryMaxSize, err)
+	}
+	if _, err := entry.AppendFramed(nil); err != ErrEntryMaxSize {
+		t.Errorf("Error should be %v but was %v", ErrEntryMaxSize, err)
+	}
+	if err := entry.SetMaxSize(maxSize - 1); err == nil {
+		t.Error("Setting a maximum size below the size of the entry should fail")
+	}
+
+	// Writes within the limit still succeed
+	copy(data[100:], []byte{1, 2, 3})
+	if _, err := entry.WriteAt([]byte{1, 2, 3}, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The limit survives a restart and the data is preserved
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pt.pm, err = New(pt.pm.file.(*os.File).Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Error("Read data doesn't match written data")
+	}
+	if _, err := entry.WriteAt([]byte{1}, maxSize); err != ErrEntryMaxSize {
+		t.Errorf("Error should be %v but was %v", ErrEntryMaxSize, err)
+	}
+
+	// Removing the limit allows the entry to grow again
+	if err := entry.SetMaxSize(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.WriteAt([]byte{1}, maxSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReadRanges tests if reading multiple disjoint ranges at once returns the
+// same data as reading them individually
+func TestReadRanges(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	data := fastrand.Bytes(5 * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read a range within a page, one that spans pages and one at the end
+	ranges := []Range{
+		{Off: 10, Length: 100},
+		{Off: pageSize - 50, Length: 2*pageSize + 100},
+		{Off: int64(len(data)) - 30, Length: 30},
+	}
+	datas, err := entry.ReadRanges(ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datas) != len(ranges) {
+		t.Fatalf("There should be %v ranges but there were %v", len(ranges), len(datas))
+	}
+	for i, r := range ranges {
+		readData := make([]byte, r.Length)
+		if _, err := entry.ReadAt(readData, r.Off); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(datas[i], readData) {
+			t.Errorf("Range %v doesn't match the data read by ReadAt", i)
+		}
+	}
+
+	// Ranges beyond the end of the entry can't be read
+	if _, err := entry.ReadRanges([]Range{{Off: int64(len(data)) - 10, Length: 11}}); err == nil {
+		t.Error("Reading a range beyond the end of the entry should fail")
+	}
+}
+
+// TestModTime tests if the modification time of an entry is updated by writes
+// and truncations but not by reads and if it is persisted
+func TestModTime(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	fc := newFakeClock()
+	fc.now = time.Unix(1000, 0)
+	pt.pm.opts.TrackModTime = true
+	pt.pm.opts.Clock = fc
+
+	// checkModTime checks the modification time of an entry
+	checkModTime := func(entry *Entry, expected time.Time) {
+		t.Helper()
+		stat, err := entry.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stat.ModTime.Equal(expected) {
+			t.Errorf("ModTime should be %v but was %v", expected, stat.ModTime)
+		}
+	}
+
+	// A new entry was modified when it was created
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkModTime(entry, fc.now)
+
+	// Writes advance the modification time
+	fc.now = fc.now.Add(time.Minute)
+	if _, err := entry.Write(fastrand.Bytes(2 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	checkModTime(entry, fc.now)
+	fc.now = fc.now.Add(time.Minute)
+	if _, err := entry.WriteAt([]byte{1}, 10); err != nil {
+		t.Fatal(err)
+	}
+	checkModTime(entry, fc.now)
+
+	// Reads don't modify the entry
+	modified := fc.now
+	fc.now = fc.now.Add(time.Minute)
+	if _, err := entry.ReadAt(make([]byte, 10), 0); err != nil {
+		t.Fatal(err)
+	}
+	checkModTime(entry, modified)
+
+	// Truncations advance it again
+	if err := entry.Truncate(pageSize); err != nil {
+		t.Fatal(err)
+	}
+	checkModTime(entry, fc.now)
+	if stat, err := entry.Stat(); err != nil || stat.Size != pageSize {
+		t.Errorf("Size should be %v but was %v %v", pageSize, stat.Size, err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The modification time is persisted
+	pt.pm.mu.Lock()
+	delete(pt.pm.entryPages, id)
+	pt.pm.removeIdleEntry(id)
+	pt.pm.mu.Unlock()
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	checkModTime(entry, fc.now)
+
+	// Entries of a PageManager that doesn't track the modification time
+	// don't have one
+	pt.pm.opts.TrackModTime = false
+	untracked, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer untracked.Close()
+	checkModTime(untracked, time.Time{})
+}
@@ -0,0 +1,130 @@
+package pages
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+// exportMagic identifies the stream written by Entry.Export
+var exportMagic = [8]byte{'p', 'a', 'g', 'e', 's', 'e', 'x', 1}
+
+// Export writes the data and attributes of the entry to w. The stream starts
+// with a magic value followed by the length and data of the entry and the
+// length and marshalled attributes. Holes are exported as zeros. The stream
+// can be imported with PageManager.Import
+func (e *Entry) Export(w io.Writer) error {
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return err
+	}
+
+	// Write the magic and the length of the data
+	header := make([]byte, len(exportMagic)+8)
+	copy(header, exportMagic[:])
+	binary.LittleEndian.PutUint64(header[len(exportMagic):], uint64(e.ep.usedSize))
+	if _, err := w.Write(header); err != nil {
+		return build.ExtendErr("failed to write header", err)
+	}
+
+	// Write the data
+	cursorPage := int64(0)
+	cursorOff := int64(0)
+	buf := make([]byte, pageSize)
+	for remaining := e.ep.usedSize; remaining > 0; {
+		if remaining < int64(len(buf)) {
+			buf = buf[:remaining]
+		}
+		n, err := e.read(buf, &cursorPage, &cursorOff)
+		if err != nil {
+			return build.ExtendErr("failed to read data", err)
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return build.ExtendErr("failed to write data", err)
+		}
+		remaining -= int64(n)
+	}
+
+	// Write the attributes
+	attrs, err := readAttrs(e.ep.pp, e.ep.generation)
+	if err != nil {
+		return build.ExtendErr("failed to read attributes", err)
+	}
+	attrData, err := marshalAttrs(attrs)
+	if err != nil {
+		return err
+	}
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(attrData)))
+	if _, err := w.Write(append(length, attrData...)); err != nil {
+		return build.ExtendErr("failed to write attributes", err)
+	}
+	return nil
+}
+
+// Import creates a new entry from a stream written by Entry.Export and returns
+// its Identifier. If the import fails, the partially imported entry is deleted
+func (p *PageManager) Import(r io.Reader) (id Identifier, err error) {
+	// Read the magic and the length of the data
+	header := make([]byte, len(exportMagic)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, build.ExtendErr("failed to read header", err)
+	}
+	var magic [8]byte
+	copy(magic[:], header)
+	if magic != exportMagic {
+		return 0, errors.New("stream wasn't created by Export")
+	}
+	size := int64(binary.LittleEndian.Uint64(header[len(exportMagic):]))
+	if size < 0 {
+		return 0, fmt.Errorf("invalid entry size %v", size)
+	}
+
+	// Create the entry and delete it again if the import fails
+	entry, id, err := p.Create()
+	if err != nil {
+		return 0, build.ExtendErr("failed to create entry", err)
+	}
+	defer func() {
+		entry.Close()
+		if err != nil {
+			p.Delete(id)
+		}
+	}()
+
+	// Copy the data
+	if _, err := io.CopyN(entry, r, size); err != nil {
+		return 0, build.ExtendErr("failed to import data", err)
+	}
+
+	// Read the attributes
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return 0, build.ExtendErr("failed to read length of attributes", err)
+	}
+	attrLength := binary.LittleEndian.Uint32(length)
+	if attrLength > maxAttrsSize {
+		return 0, ErrAttrsTooLarge
+	}
+	attrData := make([]byte, attrLength)
+	if _, err := io.ReadFull(r, attrData); err != nil {
+		return 0, build.ExtendErr("failed to read attributes", err)
+	}
+	for len(attrData) > 0 {
+		var key, value []byte
+		if key, attrData, err = readAttrField(attrData); err != nil {
+			return 0, err
+		}
+		if value, attrData, err = readAttrField(attrData); err != nil {
+			return 0, err
+		}
+		if err = entry.SetAttr(string(key), value); err != nil {
+			return 0, build.ExtendErr("failed to set attribute", err)
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,97 @@
+package pages
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestExportImport tests if an entry that is exported from one PageManager
+// and imported into another one keeps its data and attributes
+func TestExportImport(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry with data and attributes
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(3*pageSize + 123)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	attrs := map[string][]byte{
+		"content-type": []byte("application/octet-stream"),
+		"checksum":     fastrand.Bytes(32),
+	}
+	for key, value := range attrs {
+		if err := entry.SetAttr(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Export it
+	var buf bytes.Buffer
+	if err := entry.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	exported := buf.Bytes()
+
+	// Import it into a different PageManager
+	testdir := build.TempDir("paging", t.Name(), "import")
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(filepath.Join(testdir, "data.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	id, err := pm.Import(bytes.NewReader(exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := imported.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) {
+		t.Fatalf("Imported entry should have %v bytes but had %v", len(data), size)
+	}
+	readData := make([]byte, len(data))
+	if _, err := imported.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Error("Imported data doesn't match exported data")
+	}
+	readAttrs, err := imported.Attrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readAttrs) != len(attrs) {
+		t.Fatalf("There should be %v attributes but there were %v", len(attrs), len(readAttrs))
+	}
+	for key, value := range attrs {
+		if !bytes.Equal(readAttrs[key], value) {
+			t.Errorf("Attribute %v should be %v but was %v", key, value, readAttrs[key])
+		}
+	}
+
+	// A truncated stream should be rejected
+	if _, err := pm.Import(bytes.NewReader(exported[:len(exported)-1])); err == nil {
+		t.Error("Importing a truncated stream should fail")
+	}
+}
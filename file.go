@@ -0,0 +1,398 @@
+package pages
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrWriteMismatch is returned if VerifyWrites is enabled and the data
+	// that is read back after a write doesn't match the written data
+	ErrWriteMismatch = errors.New("data read back after write doesn't match written data")
+)
+
+type (
+	// backingFile is the interface of the storage the PageManager writes its
+	// pages to
+	backingFile interface {
+		io.ReaderAt
+		io.WriterAt
+		io.Seeker
+		Close() error
+		Stat() (os.FileInfo, error)
+		Sync() error
+		Truncate(size int64) error
+	}
+
+	// shardedFile is a backingFile that spreads its data over multiple
+	// shards of a fixed maximum size. The first shard is stored at the path of
+	// the database and every following shard uses the same path with the
+	// index of the shard as a suffix
+	shardedFile struct {
+		// path is the path of the first shard
+		path string
+
+		// shardSize is the maximum size of a single shard
+		shardSize int64
+
+		// shards are the open files of the shards
+		shards []*os.File
+
+		// off is the offset used by Seek
+		off int64
+
+		// mu protects the fields of the shardedFile. It is only held to look
+		// up or change the shards but not while reading from, writing to or
+		// syncing an existing shard
+		mu *sync.RWMutex
+	}
+
+	// shardedFileInfo is the os.FileInfo of a shardedFile. It reports the
+	// combined size of all shards
+	shardedFileInfo struct {
+		os.FileInfo
+		size int64
+	}
+
+	// verifyingFile is a backingFile that reads back every write to verify
+	// that the data reached the underlying file
+	verifyingFile struct {
+		backingFile
+	}
+)
+
+// openBackingFile opens the backingFile at path using the specified flags. If
+// opts.ShardSize is set, the file is opened as a shardedFile
+func openBackingFile(path string, flag int, opts Options) (bf backingFile, err error) {
+	if opts.ShardSize == 0 {
+		bf, err = os.OpenFile(path, flag, 0600)
+	} else {
+		bf, err = openShardedFile(path, flag, opts.ShardSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify the writes if necessary
+	if opts.VerifyWrites {
+		bf = &verifyingFile{bf}
+	}
+	return bf, nil
+}
+
+// WriteAt writes b to the underlying file and reads the written bytes back.
+// ErrWriteMismatch is returned if they don't match b
+func (vf *verifyingFile) WriteAt(b []byte, off int64) (int, error) {
+	n, err := vf.backingFile.WriteAt(b, off)
+	if n == 0 {
+		return n, err
+	}
+	readBack := make([]byte, n)
+	if _, readErr := vf.backingFile.ReadAt(readBack, off); readErr != nil {
+		return 0, fmt.Errorf("failed to read back written data: %v", readErr)
+	}
+	if !bytes.Equal(readBack, b[:n]) {
+		return 0, ErrWriteMismatch
+	}
+	return n, err
+}
+
+// writeFull writes all of b to w starting at off. Short writes without an
+// error, which can happen if a write is interrupted by a signal, are retried
+// with the remaining bytes
+func writeFull(w io.WriterAt, b []byte, off int64) (n int, err error) {
+	for n < len(b) {
+		var written int
+		written, err = w.WriteAt(b[n:], off+int64(n))
+		n += written
+		if err != nil {
+			return n, err
+		}
+		if written == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// copyBackingFile copies the first size bytes of src to dst
+func copyBackingFile(dst, src backingFile, size int64) error {
+	buf := make([]byte, 64*pageSize)
+	for off := int64(0); off < size; off += int64(len(buf)) {
+		if remaining := size - off; remaining < int64(len(buf)) {
+			buf = buf[:remaining]
+		}
+		if _, err := src.ReadAt(buf, off); err != nil {
+			return err
+		}
+		if _, err := writeFull(dst, buf, off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openShardedFile opens the first shard of a shardedFile using the specified
+// flags and all the following shards that exist on disk
+func openShardedFile(path string, flag int, shardSize int64) (*shardedFile, error) {
+	if shardSize <= 0 || shardSize%pageSize != 0 {
+		return nil, fmt.Errorf("shard size %v is not a multiple of the page size", shardSize)
+	}
+	sf := &shardedFile{
+		path:      path,
+		shardSize: shardSize,
+		mu:        new(sync.RWMutex),
+	}
+
+	// Open the first shard
+	shard, err := os.OpenFile(path, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	sf.shards = append(sf.shards, shard)
+
+	// Open or remove the following shards. If the first shard was truncated
+	// the following shards are outdated
+	for i := 1; ; i++ {
+		shard, err := os.OpenFile(sf.shardPath(i), os.O_RDWR, 0600)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			sf.Close()
+			return nil, err
+		}
+		if flag&os.O_TRUNC != 0 {
+			shard.Close()
+			if err := os.Remove(sf.shardPath(i)); err != nil {
+				sf.Close()
+				return nil, err
+			}
+			continue
+		}
+		sf.shards = append(sf.shards, shard)
+	}
+	return sf, nil
+}
+
+// Size returns the combined size of the shards
+func (fi shardedFileInfo) Size() int64 {
+	return fi.size
+}
+
+// shardPath returns the path of the shard with the specified index
+func (sf *shardedFile) shardPath(index int) string {
+	if index == 0 {
+		return sf.path
+	}
+	return fmt.Sprintf("%v.%v", sf.path, index)
+}
+
+// size returns the combined size of the shards. The mu lock needs to be
+// acquired
+func (sf *shardedFile) size() (int64, error) {
+	stat, err := sf.shards[len(sf.shards)-1].Stat()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(sf.shards)-1)*sf.shardSize + stat.Size(), nil
+}
+
+// shard returns the shard with the specified index. If create is true, missing
+// shards are created and all preceding shards are extended to the full shard
+// size. The mu lock needs to be acquired
+func (sf *shardedFile) shard(index int, create bool) (*os.File, error) {
+	for index >= len(sf.shards) {
+		if !create {
+			return nil, io.EOF
+		}
+		if err := sf.shards[len(sf.shards)-1].Truncate(sf.shardSize); err != nil {
+			return nil, err
+		}
+		shard, err := os.OpenFile(sf.shardPath(len(sf.shards)), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return nil, err
+		}
+		sf.shards = append(sf.shards, shard)
+	}
+	return sf.shards[index], nil
+}
+
+// managedShard is the same as shard but only acquires the mu lock for writing
+// if a missing shard needs to be created
+func (sf *shardedFile) managedShard(index int, create bool) (*os.File, error) {
+	sf.mu.RLock()
+	if index < len(sf.shards) {
+		shard := sf.shards[index]
+		sf.mu.RUnlock()
+		return shard, nil
+	}
+	sf.mu.RUnlock()
+	if !create {
+		return nil, io.EOF
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.shard(index, true)
+}
+
+// Close closes all the shards
+func (sf *shardedFile) Close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var errs []error
+	for _, shard := range sf.shards {
+		if err := shard.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to close %v shards: %v", len(errs), errs[0])
+	}
+	return nil
+}
+
+// ReadAt reads len(b) bytes from the shards starting at off
+func (sf *shardedFile) ReadAt(b []byte, off int64) (n int, err error) {
+	for n < len(b) {
+		// Get the shard and the range within the shard
+		index := int((off + int64(n)) / sf.shardSize)
+		localOff := (off + int64(n)) % sf.shardSize
+		length := int64(len(b) - n)
+		if length > sf.shardSize-localOff {
+			length = sf.shardSize - localOff
+		}
+		shard, err := sf.managedShard(index, false)
+		if err != nil {
+			return n, err
+		}
+
+		// Read from the shard
+		read, err := shard.ReadAt(b[n:int64(n)+length], localOff)
+		n += read
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Seek sets the offset for the next Read or Write on the file
+func (sf *shardedFile) Seek(offset int64, whence int) (int64, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sf.off
+	case io.SeekEnd:
+		size, err := sf.size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	sf.off = offset
+	return sf.off, nil
+}
+
+// Stat returns the os.FileInfo of the first shard with the combined size of
+// all shards
+func (sf *shardedFile) Stat() (os.FileInfo, error) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	stat, err := sf.shards[0].Stat()
+	if err != nil {
+		return nil, err
+	}
+	size, err := sf.size()
+	if err != nil {
+		return nil, err
+	}
+	return shardedFileInfo{
+		FileInfo: stat,
+		size:     size,
+	}, nil
+}
+
+// Sync syncs all the shards
+func (sf *shardedFile) Sync() error {
+	sf.mu.RLock()
+	shards := append([]*os.File(nil), sf.shards...)
+	sf.mu.RUnlock()
+	for _, shard := range shards {
+		if err := shard.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Truncate changes the combined size of the shards. Shards that are no longer
+// needed are removed
+func (sf *shardedFile) Truncate(size int64) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if size < 0 {
+		return errors.New("negative size")
+	}
+
+	// Remove the shards that are no longer needed. The first shard is never
+	// removed
+	numShards := int((size + sf.shardSize - 1) / sf.shardSize)
+	if numShards == 0 {
+		numShards = 1
+	}
+	for len(sf.shards) > numShards {
+		last := len(sf.shards) - 1
+		if err := sf.shards[last].Close(); err != nil {
+			return err
+		}
+		if err := os.Remove(sf.shardPath(last)); err != nil {
+			return err
+		}
+		sf.shards = sf.shards[:last]
+	}
+
+	// Truncate the last remaining shard
+	shard, err := sf.shard(numShards-1, true)
+	if err != nil {
+		return err
+	}
+	return shard.Truncate(size - int64(numShards-1)*sf.shardSize)
+}
+
+// WriteAt writes len(b) bytes to the shards starting at off. Missing shards
+// are created
+func (sf *shardedFile) WriteAt(b []byte, off int64) (n int, err error) {
+	for n < len(b) {
+		// Get the shard and the range within the shard
+		index := int((off + int64(n)) / sf.shardSize)
+		localOff := (off + int64(n)) % sf.shardSize
+		length := int64(len(b) - n)
+		if length > sf.shardSize-localOff {
+			length = sf.shardSize - localOff
+		}
+		shard, err := sf.managedShard(index, true)
+		if err != nil {
+			return n, err
+		}
+
+		// Write to the shard
+		written, err := shard.WriteAt(b[n:int64(n)+length], localOff)
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,455 @@
+package pages
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// faultyFile is a backingFile that simulates a full disk by failing writes
+// beyond a certain size
+type faultyFile struct {
+	backingFile
+
+	// limit is the size the file can't grow beyond. A limit of 0 disables
+	// the fault injection
+	limit int64
+}
+
+// WriteAt fails with ENOSPC if the write would grow the file beyond the
+// limit
+func (f *faultyFile) WriteAt(b []byte, off int64) (int, error) {
+	if f.limit > 0 && off+int64(len(b)) > f.limit {
+		return 0, &os.PathError{Op: "write", Path: "faultyFile", Err: syscall.ENOSPC}
+	}
+	return f.backingFile.WriteAt(b, off)
+}
+
+// unreadableFile is a backingFile that simulates a broken sector by failing
+// reads of the page at a certain offset
+type unreadableFile struct {
+	backingFile
+
+	// badOff is the offset of the unreadable page. An offset of 0 disables
+	// the fault injection
+	badOff int64
+}
+
+// ReadAt fails with EIO if the read overlaps the unreadable page
+func (f *unreadableFile) ReadAt(b []byte, off int64) (int, error) {
+	if f.badOff > 0 && off < f.badOff+pageSize && off+int64(len(b)) > f.badOff {
+		return 0, &os.PathError{Op: "read", Path: "unreadableFile", Err: syscall.EIO}
+	}
+	return f.backingFile.ReadAt(b, off)
+}
+
+// shortFile is a backingFile that simulates interrupted writes by writing at
+// most maxWrite bytes per call without returning an error
+type shortFile struct {
+	backingFile
+	maxWrite int
+}
+
+// WriteAt writes at most maxWrite bytes of b
+func (f *shortFile) WriteAt(b []byte, off int64) (int, error) {
+	if len(b) > f.maxWrite {
+		b = b[:f.maxWrite]
+	}
+	return f.backingFile.WriteAt(b, off)
+}
+
+// slowFile is a backingFile that simulates slow storage by blocking reads
+// until release is closed
+type slowFile struct {
+	backingFile
+	release chan struct{}
+}
+
+// ReadAt waits for release to be closed before reading
+func (f *slowFile) ReadAt(b []byte, off int64) (int, error) {
+	<-f.release
+	return f.backingFile.ReadAt(b, off)
+}
+
+// blockingSyncFile is a backingFile that signals syncing when Sync is called
+// and waits for release to be closed before syncing
+type blockingSyncFile struct {
+	backingFile
+	syncing chan struct{}
+	release chan struct{}
+}
+
+// Sync signals the call and waits for release to be closed before syncing.
+// Once release is closed, Sync no longer blocks
+func (f *blockingSyncFile) Sync() error {
+	select {
+	case f.syncing <- struct{}{}:
+		<-f.release
+	case <-f.release:
+	}
+	return f.backingFile.Sync()
+}
+
+// gatedFile is a backingFile that blocks the first read at off after it was
+// armed. reached is closed once the read is blocked and the read continues
+// after release is closed
+type gatedFile struct {
+	backingFile
+	off     int64
+	armed   int32
+	reached chan struct{}
+	release chan struct{}
+}
+
+// ReadAt blocks the first read at f.off after the gatedFile was armed
+func (f *gatedFile) ReadAt(b []byte, off int64) (int, error) {
+	if off == f.off && atomic.CompareAndSwapInt32(&f.armed, 1, 0) {
+		close(f.reached)
+		<-f.release
+	}
+	return f.backingFile.ReadAt(b, off)
+}
+
+// delayFile is a backingFile that simulates storage with a high latency by
+// delaying every read
+type delayFile struct {
+	backingFile
+	delay time.Duration
+}
+
+// ReadAt sleeps for the delay before reading
+func (f *delayFile) ReadAt(b []byte, off int64) (int, error) {
+	time.Sleep(f.delay)
+	return f.backingFile.ReadAt(b, off)
+}
+
+// countingFile is a backingFile that counts the writes to each offset, the
+// number of reads and the number of syncs
+type countingFile struct {
+	backingFile
+	writes map[int64]int
+	reads  int
+	syncs  int
+}
+
+// Sync counts the sync and syncs the underlying file
+func (f *countingFile) Sync() error {
+	f.syncs++
+	return f.backingFile.Sync()
+}
+
+// ReadAt counts the read and reads from the underlying file
+func (f *countingFile) ReadAt(b []byte, off int64) (int, error) {
+	f.reads++
+	return f.backingFile.ReadAt(b, off)
+}
+
+// WriteAt counts the write and writes to the underlying file
+func (f *countingFile) WriteAt(b []byte, off int64) (int, error) {
+	f.writes[off]++
+	return f.backingFile.WriteAt(b, off)
+}
+
+// crashFile is a backingFile that remembers the contents of the file at the
+// last Sync to simulate a crash that loses all unsynced writes. history
+// contains the contents at every Sync
+type crashFile struct {
+	backingFile
+	synced  []byte
+	history [][]byte
+}
+
+// Sync remembers the current contents of the file and syncs it
+func (f *crashFile) Sync() error {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	f.synced = make([]byte, stat.Size())
+	if _, err := f.ReadAt(f.synced, 0); err != nil {
+		return err
+	}
+	f.history = append(f.history, f.synced)
+	return f.backingFile.Sync()
+}
+
+// corruptingFile is a backingFile that simulates silent write failures by
+// flipping the bits of the first byte of every write
+type corruptingFile struct {
+	backingFile
+}
+
+// WriteAt writes a corrupted copy of b and reports success
+func (f *corruptingFile) WriteAt(b []byte, off int64) (int, error) {
+	corrupted := append([]byte(nil), b...)
+	if len(corrupted) > 0 {
+		corrupted[0] ^= 0xff
+	}
+	return f.backingFile.WriteAt(corrupted, off)
+}
+
+// TestShardedFile tests if reading and writing across the boundaries of the
+// shards of a shardedFile works as expected
+func TestShardedFile(t *testing.T) {
+	// Create temp dir
+	testdir := build.TempDir("paging", t.Name())
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testdir, "data.dat")
+
+	// Create a sharded file with shards of 2 pages
+	shardSize := int64(2 * pageSize)
+	sf, err := openShardedFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, shardSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write data that spans 3 shards starting in the middle of the first one
+	offset := int64(100)
+	data := fastrand.Bytes(int(2*shardSize) + 1000)
+	if n, err := sf.WriteAt(data, offset); err != nil || n != len(data) {
+		t.Fatalf("Failed to write data: %v %v", n, err)
+	}
+	if len(sf.shards) != 3 {
+		t.Fatalf("There should be %v shards but there were %v", 3, len(sf.shards))
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := os.Stat(sf.shardPath(i)); err != nil {
+			t.Errorf("Shard %v doesn't exist: %v", i, err)
+		}
+	}
+
+	// Check the size
+	stat, err := sf.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != offset+int64(len(data)) {
+		t.Errorf("Size should be %v but was %v", offset+int64(len(data)), stat.Size())
+	}
+
+	// Read the data across the boundary of the shards
+	readData := make([]byte, 2*pageSize)
+	if _, err := sf.ReadAt(readData, shardSize-pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data[shardSize-pageSize-offset:][:len(readData)], readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+
+	// Reopen the file and read all of the data
+	if err := sf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sf, err = openShardedFile(path, os.O_RDWR, shardSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData = make([]byte, len(data))
+	if _, err := sf.ReadAt(readData, offset); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+
+	// Truncate the file to a single shard
+	if err := sf.Truncate(pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if len(sf.shards) != 1 {
+		t.Errorf("There should be %v shard but there were %v", 1, len(sf.shards))
+	}
+	if _, err := os.Stat(sf.shardPath(1)); !os.IsNotExist(err) {
+		t.Errorf("Shard 1 should have been removed: %v", err)
+	}
+	if err := sf.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestShardedFileConcurrent tests if a shardedFile can be written, read and
+// synced concurrently while new shards are created
+func TestShardedFileConcurrent(t *testing.T) {
+	// Create temp dir
+	testdir := build.TempDir("paging", t.Name())
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testdir, "data.dat")
+
+	// Create a sharded file with shards of 2 pages
+	shardSize := int64(2 * pageSize)
+	sf, err := openShardedFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, shardSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	// Every thread writes, syncs and reads back its own pages which are
+	// spread over 8 shards
+	numThreads := 8
+	pages := make([][]byte, 2*numThreads)
+	var wg sync.WaitGroup
+	errChan := make(chan error, numThreads)
+	for i := 0; i < numThreads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for _, index := range []int{i, i + numThreads} {
+				pages[index] = fastrand.Bytes(pageSize)
+				off := int64(index) * pageSize
+				if _, err := sf.WriteAt(pages[index], off); err != nil {
+					errChan <- err
+					return
+				}
+				if err := sf.Sync(); err != nil {
+					errChan <- err
+					return
+				}
+				readData := make([]byte, pageSize)
+				if _, err := sf.ReadAt(readData, off); err != nil {
+					errChan <- err
+					return
+				}
+				if !bytes.Equal(pages[index], readData) {
+					errChan <- fmt.Errorf("page %v doesn't match written data", index)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errChan)
+	for err := range errChan {
+		t.Fatal(err)
+	}
+
+	// All the pages can be read back at once
+	readData := make([]byte, len(pages)*pageSize)
+	if _, err := sf.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i, page := range pages {
+		if !bytes.Equal(page, readData[i*pageSize:][:pageSize]) {
+			t.Errorf("Page %v doesn't match written data", i)
+		}
+	}
+}
+
+// TestShardedPageManager tests if entries can be written to and recovered
+// from a PageManager that shards its pages
+func TestShardedPageManager(t *testing.T) {
+	// Create temp dir
+	testdir := build.TempDir("paging", t.Name())
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testdir, "data.dat")
+
+	// Create a PageManager with tiny shards
+	opts := DefaultOptions()
+	opts.ShardSize = 4 * pageSize
+	pm, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write data that spans multiple shards
+	entry, id, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(20*pageSize + 100)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(pm.file.(*shardedFile).shards) < 5 {
+		t.Errorf("Data should be spread over at least %v shards but was spread over %v",
+			5, len(pm.file.(*shardedFile).shards))
+	}
+
+	// Read across a shard boundary
+	readData := make([]byte, pageSize)
+	offset := int64(3*pageSize + pageSize/2)
+	if _, err := entry.ReadAt(readData, offset); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data[offset:offset+pageSize], readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+
+	// Recover the PageManager and read the data
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err = NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	entry, err = pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData = make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// TestVerifyWrites tests if VerifyWrites catches writes that were silently
+// corrupted
+func TestVerifyWrites(t *testing.T) {
+	// Create temp dir
+	testdir := build.TempDir("paging", t.Name())
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testdir, "data.dat")
+
+	// Create a PageManager that verifies its writes
+	opts := DefaultOptions()
+	opts.VerifyWrites = true
+	pm, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	vf, ok := pm.file.(*verifyingFile)
+	if !ok {
+		t.Fatal("file should be a verifyingFile")
+	}
+
+	// Writes to reliable storage succeed
+	entry, _, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	data := fastrand.Bytes(2 * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the following writes
+	vf.backingFile = &corruptingFile{vf.backingFile}
+	_, err = entry.WriteAt(fastrand.Bytes(10), 100)
+	if err == nil || !strings.Contains(err.Error(), ErrWriteMismatch.Error()) {
+		t.Errorf("error should be %v but was %v", ErrWriteMismatch, err)
+	}
+}
@@ -0,0 +1,79 @@
+package pages
+
+import (
+	"sort"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+const (
+	// compactionFreeRatio is the minimum ratio of free pages to the total
+	// number of pages at which compaction is recommended
+	compactionFreeRatio = 0.25
+
+	// compactionRunRatio is the ratio of the largest contiguous run of free
+	// pages to the number of free pages below which the free pages are
+	// considered to be fragmented
+	compactionRunRatio = 0.1
+)
+
+// FragReport describes the fragmentation of the free pages of a PageManager
+type FragReport struct {
+	// TotalPages is the number of pages of the file
+	TotalPages int64
+
+	// FreePages is the number of free pages
+	FreePages int64
+
+	// LargestFreeRun is the number of pages of the largest run of free pages
+	// that are contiguous within the file
+	LargestFreeRun int64
+
+	// CompactionRecommended is true if a large part of the file is free but
+	// the free pages are scattered over the file
+	CompactionRecommended bool
+}
+
+// FragmentationReport returns a FragReport about the free pages of the
+// PageManager
+func (p *PageManager) FragmentationReport() (FragReport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stat, err := p.file.Stat()
+	if err != nil {
+		return FragReport{}, build.ExtendErr("failed to get size of file", err)
+	}
+	report := FragReport{
+		TotalPages: (stat.Size() + pageSize - 1) / pageSize,
+	}
+
+	// Sort the offsets of the free pages to find contiguous runs
+	offsets := make([]int64, 0, p.freePages.availablePages())
+	for _, page := range p.freePages.pages {
+		offsets = append(offsets, page.fileOff)
+	}
+	for _, page := range p.freePages.pagesToFree {
+		offsets = append(offsets, page.fileOff)
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+	report.FreePages = int64(len(offsets))
+	run := int64(0)
+	for i, off := range offsets {
+		if i > 0 && off == offsets[i-1]+pageSize {
+			run++
+		} else {
+			run = 1
+		}
+		if run > report.LargestFreeRun {
+			report.LargestFreeRun = run
+		}
+	}
+
+	report.CompactionRecommended = report.FreePages > 0 &&
+		float64(report.FreePages) >= compactionFreeRatio*float64(report.TotalPages) &&
+		float64(report.LargestFreeRun) < compactionRunRatio*float64(report.FreePages)
+	return report, nil
+}
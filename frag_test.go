@@ -0,0 +1,74 @@
+package pages
+
+import "testing"
+
+// TestFragmentationReport tests if FragmentationReport recommends compaction
+// if the free pages are scattered over the file
+func TestFragmentationReport(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// A new file doesn't need to be compacted
+	report, err := pt.pm.FragmentationReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FreePages != 0 || report.LargestFreeRun != 0 || report.CompactionRecommended {
+		t.Errorf("New file shouldn't have free pages but report was %+v", report)
+	}
+
+	// Allocate pages and free every other one
+	var pages []*physicalPage
+	for i := 0; i < 100; i++ {
+		page, err := pt.pm.managedAllocatePage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, page)
+	}
+	var scattered, remaining []*physicalPage
+	for i, page := range pages {
+		if i%2 == 0 {
+			scattered = append(scattered, page)
+		} else {
+			remaining = append(remaining, page)
+		}
+	}
+	if err := pt.pm.managedAddFreePages(scattered); err != nil {
+		t.Fatal(err)
+	}
+	report, err = pt.pm.FragmentationReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FreePages != int64(len(scattered)) {
+		t.Errorf("Report should contain %v free pages but had %v", len(scattered), report.FreePages)
+	}
+	if report.LargestFreeRun != 1 {
+		t.Errorf("Largest free run should be 1 but was %v", report.LargestFreeRun)
+	}
+	if report.TotalPages <= int64(len(pages)) {
+		t.Errorf("File should have more than %v pages but had %v", len(pages), report.TotalPages)
+	}
+	if !report.CompactionRecommended {
+		t.Errorf("Compaction should be recommended for %+v", report)
+	}
+
+	// Freeing the remaining pages creates a large contiguous run
+	if err := pt.pm.managedAddFreePages(remaining); err != nil {
+		t.Fatal(err)
+	}
+	report, err = pt.pm.FragmentationReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.LargestFreeRun < int64(len(pages)) {
+		t.Errorf("Largest free run should be at least %v but was %v", len(pages), report.LargestFreeRun)
+	}
+	if report.CompactionRecommended {
+		t.Errorf("Compaction shouldn't be recommended for %+v", report)
+	}
+}
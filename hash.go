@@ -0,0 +1,144 @@
+package pages
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+// ContentHash returns the SHA-256 hash of the data of the entry. If
+// CacheContentHash is enabled, the hash is stored on the entryPage and reused
+// until the entry is modified
+func (e *Entry) ContentHash() (hash [32]byte, err error) {
+	// The write lock prevents in-place writes while the data is hashed
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return hash, err
+	}
+
+	// Use the cached hash if possible
+	if e.ep.hash != nil {
+		copy(hash[:], e.ep.hash)
+		return hash, nil
+	}
+
+	// Stream the data of the pages through the hash. Holes are hashed as
+	// zeros
+	h := sha256.New()
+	data := make([]byte, pageSize)
+	for i := int64(0); i < e.ep.numPages(); i++ {
+		page, err := e.ep.page(i)
+		if err != nil {
+			return hash, err
+		}
+		size := e.ep.holeSize(i)
+		if size == 0 {
+			// The remaining pages were kept by TrimToSize
+			break
+		}
+		if page == nil {
+			for j := range data[:size] {
+				data[j] = 0
+			}
+		} else if _, err := page.readAt(data[:size], 0); err != nil {
+			return hash, build.ExtendErr("failed to read page", err)
+		}
+		h.Write(data[:size])
+	}
+	copy(hash[:], h.Sum(nil))
+
+	// Cache the hash
+	if !e.pm.opts.CacheContentHash {
+		return hash, nil
+	}
+	if err := writeContentHash(e.ep.pp, e.ep.generation, hash[:]); err != nil {
+		return hash, build.ExtendErr("failed to cache content hash", err)
+	}
+	e.ep.hash = hash[:]
+	return hash, nil
+}
+
+// invalidateHash removes the cached content hash of the entry before it is
+// modified. The ep.mu read lock needs to be acquired
+func (e *Entry) invalidateHash() error {
+	e.ep.hashMu.Lock()
+	defer e.ep.hashMu.Unlock()
+	if e.ep.hash == nil {
+		return nil
+	}
+	if err := writeContentHash(e.ep.pp, e.ep.generation, nil); err != nil {
+		return build.ExtendErr("failed to invalidate content hash", err)
+	}
+	e.ep.hash = nil
+	return nil
+}
+
+// touch marks the entry as unsynced after it was modified and records the
+// current time as the time of the last modification of the entry if
+// TrackModTime is enabled. The ep.mu read lock needs to be acquired
+func (e *Entry) touch() error {
+	e.markUnsynced()
+	if !e.pm.opts.TrackModTime {
+		return nil
+	}
+	e.ep.hashMu.Lock()
+	defer e.ep.hashMu.Unlock()
+	modTime := e.pm.opts.Clock.Now().UnixNano()
+	if err := writeModTime(e.ep.pp, e.ep.generation, modTime); err != nil {
+		return build.ExtendErr("failed to write modification time", err)
+	}
+	e.ep.modTime = modTime
+	return nil
+}
+
+// Equal compares the data of the entries with the specified Identifiers. The
+// entries are read page by page and the comparison stops at the first
+// mismatch
+func (p *PageManager) Equal(a, b Identifier) (bool, error) {
+	entryA, err := p.Open(a)
+	if err != nil {
+		return false, build.ExtendErr(fmt.Sprintf("failed to open entry %v", a), err)
+	}
+	defer entryA.Close()
+	entryB, err := p.Open(b)
+	if err != nil {
+		return false, build.ExtendErr(fmt.Sprintf("failed to open entry %v", b), err)
+	}
+	defer entryB.Close()
+
+	// Entries of different length can't be equal
+	sizeA, err := entryA.Size()
+	if err != nil {
+		return false, err
+	}
+	sizeB, err := entryB.Size()
+	if err != nil {
+		return false, err
+	}
+	if sizeA != sizeB {
+		return false, nil
+	}
+
+	// Compare the data one page at a time
+	dataA := make([]byte, pageSize)
+	dataB := make([]byte, pageSize)
+	for off := int64(0); off < sizeA; off += pageSize {
+		n := int64(pageSize)
+		if sizeA-off < n {
+			n = sizeA - off
+		}
+		if _, err := entryA.ReadAt(dataA[:n], off); err != nil {
+			return false, build.ExtendErr(fmt.Sprintf("failed to read entry %v", a), err)
+		}
+		if _, err := entryB.ReadAt(dataB[:n], off); err != nil {
+			return false, build.ExtendErr(fmt.Sprintf("failed to read entry %v", b), err)
+		}
+		if !bytes.Equal(dataA[:n], dataB[:n]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
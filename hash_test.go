@@ -0,0 +1,129 @@
+package pages
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestContentHash tests if ContentHash returns the hash of the entry's data
+// and if the cached hash is invalidated by writes
+func TestContentHash(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	pt.pm.opts.CacheContentHash = true
+
+	// Create new entry and write 2.5 pages
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(2*pageSize + pageSize/2)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hash the entry
+	hash, err := entry.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != sha256.Sum256(data) {
+		t.Fatal("Hash doesn't match the hash of the data")
+	}
+
+	// Reopen the entry. The hash should be cached
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ep.hash == nil {
+		t.Fatal("Hash should have been cached")
+	}
+	if cachedHash, err := entry.ContentHash(); err != nil || cachedHash != hash {
+		t.Fatal("Cached hash doesn't match the hash of the data", err)
+	}
+
+	// Write more data. The hash should change
+	moreData := fastrand.Bytes(100)
+	if _, err := entry.WriteAt(moreData, int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, moreData...)
+	if entry.ep.hash != nil {
+		t.Fatal("Hash should have been invalidated")
+	}
+	newHash, err := entry.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newHash == hash {
+		t.Error("Hash should have changed")
+	}
+	if newHash != sha256.Sum256(data) {
+		t.Error("Hash doesn't match the hash of the data")
+	}
+
+	// Overwrite data in place. The hash should change again
+	if _, err := entry.WriteAt([]byte{^data[0]}, 0); err != nil {
+		t.Fatal(err)
+	}
+	data[0] = ^data[0]
+	if hash, err = entry.ContentHash(); err != nil || hash != sha256.Sum256(data) {
+		t.Error("Hash doesn't match the hash of the data", err)
+	}
+}
+
+// TestEqual tests if Equal compares the length and data of two entries
+func TestEqual(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create entries with the same data, different data of the same length
+	// and less data
+	data := fastrand.Bytes(3*pageSize + 10)
+	different := append([]byte(nil), data...)
+	different[len(different)-1] = ^different[len(different)-1]
+	var ids []Identifier
+	for _, d := range [][]byte{data, data, different, data[:len(data)-1]} {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write(d); err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	tests := []struct {
+		b     Identifier
+		equal bool
+	}{
+		{ids[1], true},
+		{ids[2], false},
+		{ids[3], false},
+	}
+	for i, test := range tests {
+		equal, err := pt.pm.Equal(ids[0], test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if equal != test.equal {
+			t.Errorf("%v: Equal should return %v but was %v", i, test.equal, equal)
+		}
+	}
+}
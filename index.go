@@ -0,0 +1,253 @@
+package pages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+var (
+	// ErrKeyNotFound is returned by Get if no data was stored for a key
+	ErrKeyNotFound = errors.New("key not found in index")
+)
+
+type (
+	// indexPair maps a key of the index to the Identifier of the entry that
+	// contains the data of the key
+	indexPair struct {
+		key []byte
+		id  Identifier
+	}
+)
+
+// Put stores data under key. Every key gets an entry of its own for its data
+// and the index entry maps the keys to the Identifiers of their entries. The
+// data and the index are updated within a single transaction. The index entry
+// is created by the first call to Put
+func (p *PageManager) Put(key []byte, data []byte) error {
+	p.indexMu.Lock()
+	defer p.indexMu.Unlock()
+	if err := p.loadIndex(true); err != nil {
+		return build.ExtendErr("failed to load index", err)
+	}
+
+	// Find the entry of the key or create one
+	i := p.searchIndex(key)
+	index := p.index
+	if i == len(p.index) || !bytes.Equal(p.index[i].key, key) {
+		entry, id, err := p.Create()
+		if err != nil {
+			return build.ExtendErr("failed to create entry for key", err)
+		}
+		if err := entry.Close(); err != nil {
+			return err
+		}
+		index = make([]indexPair, 0, len(p.index)+1)
+		index = append(index, p.index[:i]...)
+		index = append(index, indexPair{key: append([]byte(nil), key...), id: id})
+		index = append(index, p.index[i:]...)
+	}
+
+	// Replace the data and the index. If the commit fails, a created entry
+	// is left behind since the log of the transaction might reference it
+	txn := p.Begin()
+	if err := replaceTxnEntry(txn, index[i].id, data); err != nil {
+		txn.Rollback()
+		return build.ExtendErr("failed to write data", err)
+	}
+	if len(index) != len(p.index) {
+		if err := replaceTxnEntry(txn, p.indexID, marshalIndex(index)); err != nil {
+			txn.Rollback()
+			return build.ExtendErr("failed to write index", err)
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return build.ExtendErr("failed to commit data", err)
+	}
+	p.index = index
+	return nil
+}
+
+// Get returns the data that was stored under key by Put. ErrKeyNotFound is
+// returned if there is no data for the key
+func (p *PageManager) Get(key []byte) ([]byte, error) {
+	p.indexMu.Lock()
+	defer p.indexMu.Unlock()
+	if err := p.loadIndex(false); err != nil {
+		return nil, build.ExtendErr("failed to load index", err)
+	}
+	i := p.searchIndex(key)
+	if i == len(p.index) || !bytes.Equal(p.index[i].key, key) {
+		return nil, ErrKeyNotFound
+	}
+	data, err := p.readEntry(p.index[i].id)
+	if err != nil {
+		return nil, build.ExtendErr(fmt.Sprintf("failed to read entry %v", p.index[i].id), err)
+	}
+	return data, nil
+}
+
+// loadIndex loads the index from the index entry unless it was loaded before.
+// If there is no index entry yet and create is true, an empty index entry is
+// created. The p.indexMu lock needs to be acquired
+func (p *PageManager) loadIndex(create bool) error {
+	if p.index != nil {
+		return nil
+	}
+	id, err := readIndexID(p.freePages.pp)
+	if err != nil {
+		return build.ExtendErr("failed to read reference to index", err)
+	}
+	if id != 0 {
+		data, err := p.readEntry(id)
+		if err != nil {
+			return build.ExtendErr(fmt.Sprintf("failed to read index entry %v", id), err)
+		}
+		index, err := unmarshalIndex(data)
+		if err != nil {
+			return build.ExtendErr("failed to unmarshal index", err)
+		}
+		p.index, p.indexID = index, id
+		return nil
+	}
+	if !create {
+		return nil
+	}
+
+	// Create the index entry and persist the reference to it
+	entry, id, err := p.Create()
+	if err != nil {
+		return build.ExtendErr("failed to create index entry", err)
+	}
+	err = entry.Flush()
+	if closeErr := entry.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		p.Delete(id)
+		return build.ExtendErr("failed to flush index entry", err)
+	}
+	if err := writeIndexID(p.freePages.pp, id); err != nil {
+		return build.ExtendErr("failed to write reference to index", err)
+	}
+	if err := p.file.Sync(); err != nil {
+		return build.ExtendErr("failed to sync reference to index", err)
+	}
+	p.index, p.indexID = []indexPair{}, id
+	return nil
+}
+
+// withIndexIDs returns ids together with the Identifiers of the index entry and
+// of the entries it references that aren't part of ids yet. Those entries
+// can't be known by the caller but need to be considered by everything that
+// expects all the entries of the PageManager. The p.indexMu lock needs to be
+// acquired
+func (p *PageManager) withIndexIDs(ids []Identifier) ([]Identifier, error) {
+	if err := p.loadIndex(false); err != nil {
+		return ids, build.ExtendErr("failed to load index", err)
+	}
+	if p.indexID == 0 {
+		return ids, nil
+	}
+	known := make(map[Identifier]struct{}, len(ids))
+	for _, id := range ids {
+		known[id] = struct{}{}
+	}
+	all := append([]Identifier(nil), ids...)
+	add := func(id Identifier) {
+		if _, exists := known[id]; !exists {
+			known[id] = struct{}{}
+			all = append(all, id)
+		}
+	}
+	add(p.indexID)
+	for _, pair := range p.index {
+		add(pair.id)
+	}
+	return all, nil
+}
+
+// searchIndex returns the position of key within the loaded index or the
+// position it would be inserted at. The p.indexMu lock needs to be acquired
+func (p *PageManager) searchIndex(key []byte) int {
+	return sort.Search(len(p.index), func(i int) bool {
+		return bytes.Compare(p.index[i].key, key) >= 0
+	})
+}
+
+// readEntry reads the whole entry with the specified Identifier
+func (p *PageManager) readEntry(id Identifier) ([]byte, error) {
+	entry, err := p.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer entry.Close()
+	size, err := entry.Size()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := entry.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// replaceTxnEntry adds writes to the transaction that replace the contents of
+// the entry with the specified Identifier with data
+func replaceTxnEntry(txn *Txn, id Identifier, data []byte) error {
+	te, err := txn.Entry(id)
+	if err != nil {
+		return err
+	}
+	if _, err := te.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return te.Truncate(int64(len(data)))
+}
+
+// marshalIndex marshals the pairs of the index. Every pair is stored as the
+// length of the key followed by the key and the Identifier
+func marshalIndex(index []indexPair) []byte {
+	var data []byte
+	buf := make([]byte, 8)
+	for _, pair := range index {
+		binary.LittleEndian.PutUint64(buf, uint64(len(pair.key)))
+		data = append(data, buf...)
+		data = append(data, pair.key...)
+		binary.LittleEndian.PutUint64(buf, uint64(pair.id))
+		data = append(data, buf...)
+	}
+	return data
+}
+
+// unmarshalIndex unmarshals the pairs of the index and makes sure that they
+// are sorted by key
+func unmarshalIndex(data []byte) ([]indexPair, error) {
+	index := []indexPair{}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("index is too short for the length of a key")
+		}
+		length := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		if uint64(len(data)) < 8 || length > uint64(len(data))-8 {
+			return nil, fmt.Errorf("index is too short for a key of %v bytes", length)
+		}
+		pair := indexPair{
+			key: data[:length],
+			id:  Identifier(binary.LittleEndian.Uint64(data[length:])),
+		}
+		data = data[length+8:]
+		if n := len(index); n > 0 && bytes.Compare(index[n-1].key, pair.key) >= 0 {
+			return nil, fmt.Errorf("key %x isn't sorted", pair.key)
+		}
+		index = append(index, pair)
+	}
+	return index, nil
+}
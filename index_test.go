@@ -0,0 +1,252 @@
+package pages
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestPutGet tests if data that was stored with Put can be retrieved with Get
+// and overwritten
+func TestPutGet(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Without an index no key is found
+	if _, err := pt.pm.Get([]byte("foo")); err != ErrKeyNotFound {
+		t.Fatalf("Get should return %v but returned %v", ErrKeyNotFound, err)
+	}
+
+	// Put some keys in random order
+	values := make(map[string][]byte)
+	for _, i := range fastrand.Perm(20) {
+		key := fmt.Sprintf("key%02d", i)
+		values[key] = fastrand.Bytes(fastrand.Intn(2 * pageSize))
+		if err := pt.pm.Put([]byte(key), values[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, value := range values {
+		data, err := pt.pm.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, value) {
+			t.Fatalf("Data of %v doesn't match", key)
+		}
+	}
+	if _, err := pt.pm.Get([]byte("key20")); err != ErrKeyNotFound {
+		t.Fatalf("Get should return %v but returned %v", ErrKeyNotFound, err)
+	}
+
+	// Overwrite a key with shorter data. The index shouldn't grow
+	indexSize := len(marshalIndex(pt.pm.index))
+	values["key05"] = []byte("short")
+	if err := pt.pm.Put([]byte("key05"), values["key05"]); err != nil {
+		t.Fatal(err)
+	}
+	data, err := pt.pm.Get([]byte("key05"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, values["key05"]) {
+		t.Fatalf("Data should be %q but was %q", values["key05"], data)
+	}
+	if size := len(marshalIndex(pt.pm.index)); size != indexSize {
+		t.Fatalf("Index should have %v bytes but had %v", indexSize, size)
+	}
+
+	// Empty keys and values are valid
+	if err := pt.pm.Put(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err = pt.pm.Get([]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("Data should be empty but was %v", data)
+	}
+}
+
+// TestIndexRecovery tests if the index is recovered after the PageManager is
+// reopened
+func TestIndexRecovery(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	values := make(map[string][]byte)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%v", i)
+		values[key] = fastrand.Bytes(100 + i)
+		if err := pt.pm.Put([]byte(key), values[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values["key3"] = fastrand.Bytes(3 * pageSize)
+	if err := pt.pm.Put([]byte("key3"), values["key3"]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the PageManager
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pt.pm, err = New(pt.pm.file.(*os.File).Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// All the keys should still be found
+	for key, value := range values {
+		data, err := pt.pm.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, value) {
+			t.Fatalf("Data of %v doesn't match", key)
+		}
+	}
+
+	// New keys are added to the recovered index
+	if err := pt.pm.Put([]byte("new"), []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if len(pt.pm.index) != len(values)+1 {
+		t.Fatalf("Index should have %v keys but had %v", len(values)+1, len(pt.pm.index))
+	}
+}
+
+// TestIndexReopen tests if Reopen picks up the keys that were put by a
+// different PageManager
+func TestIndexReopen(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	path := pt.pm.file.(*os.File).Name()
+
+	// Load the index of the first PageManager
+	if err := pt.pm.Put([]byte("first"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pt.pm.Get([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Put a key with a second PageManager that uses the same file
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Put([]byte("second"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After reopening, the key should be found and kept by the next Put
+	if err := pt.pm.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.Put([]byte("third"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"first": "1", "second": "2", "third": "3"} {
+		data, err := pt.pm.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("Failed to get %v: %v", key, err)
+		}
+		if string(data) != value {
+			t.Fatalf("Data of %v should be %q but was %q", key, value, data)
+		}
+	}
+}
+
+// TestIndexRebuildFreeList tests if the entries of the index aren't treated
+// as orphaned pages
+func TestIndexRebuildFreeList(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	values := make(map[string][]byte)
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key%v", i)
+		values[key] = fastrand.Bytes(pageSize + i)
+		if err := pt.pm.Put([]byte(key), values[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The entries of the index are neither orphaned nor corrupt
+	orphans, err := pt.pm.FindOrphans(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) > 0 {
+		t.Fatalf("Index entries shouldn't be orphaned: %v", orphans)
+	}
+	if problems := pt.pm.Verify(nil); len(problems) > 0 {
+		t.Fatal(problems)
+	}
+
+	// Rebuilding the free pages and writing new keys shouldn't overwrite the
+	// existing data
+	if err := pt.pm.RebuildFreeList(nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 5; i < 10; i++ {
+		key := fmt.Sprintf("key%v", i)
+		values[key] = fastrand.Bytes(pageSize + i)
+		if err := pt.pm.Put([]byte(key), values[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, value := range values {
+		data, err := pt.pm.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, value) {
+			t.Fatalf("Data of %v doesn't match", key)
+		}
+	}
+}
+
+// TestUnmarshalIndex tests if corrupt indices are rejected
+func TestUnmarshalIndex(t *testing.T) {
+	index := []indexPair{
+		{key: []byte("a"), id: 1},
+		{key: []byte("b"), id: 2},
+	}
+	data := marshalIndex(index)
+	unmarshaled, err := unmarshalIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unmarshaled) != 2 || !bytes.Equal(unmarshaled[1].key, []byte("b")) || unmarshaled[1].id != 2 {
+		t.Fatalf("Unmarshaled index doesn't match: %v", unmarshaled)
+	}
+
+	// Truncated and unsorted indices are corrupt
+	if _, err := unmarshalIndex(data[:len(data)-1]); err == nil {
+		t.Error("Truncated index should be rejected")
+	}
+	if _, err := unmarshalIndex(marshalIndex([]indexPair{index[1], index[0]})); err == nil {
+		t.Error("Unsorted index should be rejected")
+	}
+}
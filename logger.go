@@ -0,0 +1,37 @@
+package pages
+
+type (
+	// Logger receives the events of the PageManager like recoveries, repairs
+	// and background defrags. It can be set using the Options to give
+	// operators visibility into the PageManager. The arguments are handled
+	// in the manner of fmt.Printf
+	Logger interface {
+		// Debug logs detailed events that are only useful for debugging
+		Debug(format string, v ...interface{})
+
+		// Info logs events of the normal operation
+		Info(format string, v ...interface{})
+
+		// Warn logs unexpected events that were handled
+		Warn(format string, v ...interface{})
+
+		// Error logs failures that couldn't be reported to a caller
+		Error(format string, v ...interface{})
+	}
+
+	// noopLogger is the Logger that is used if no other Logger is specified.
+	// It discards all events
+	noopLogger struct{}
+)
+
+// Debug discards the event
+func (noopLogger) Debug(format string, v ...interface{}) {}
+
+// Info discards the event
+func (noopLogger) Info(format string, v ...interface{}) {}
+
+// Warn discards the event
+func (noopLogger) Warn(format string, v ...interface{}) {}
+
+// Error discards the event
+func (noopLogger) Error(format string, v ...interface{}) {}
@@ -0,0 +1,164 @@
+package pages
+
+import "time"
+
+// Options are the settings that can be used to customize the behavior of a
+// PageManager
+type Options struct {
+	// MaxRecoveryNodes is the maximum number of pageTables that are read
+	// while recovering the tree of a single entry. Recovering a tree that
+	// exceeds the limit fails instead of reading the whole tree. 0 means that
+	// there is no limit
+	MaxRecoveryNodes int
+
+	// LazyOpen defers the recovery of an entry's pageTable tree in Open.
+	// Reads only load the pageTables they need from disk while operations
+	// that modify the entry load the whole tree first
+	LazyOpen bool
+
+	// MaxLoadedTables limits the number of pageTables of an entry that was
+	// opened lazily that are kept in memory. The pages of such an entry
+	// aren't materialized in a list and reads walk the tree instead. Once
+	// the limit is exceeded, the least recently used pageTables are unloaded
+	// again. Operations that modify the entry still load the whole tree. 0
+	// means that there is no limit
+	MaxLoadedTables int
+
+	// ShardSize is the maximum size of a single file on disk. If it is set,
+	// the pages are spread over multiple files of ShardSize bytes. It needs
+	// to be a multiple of the page size and the same value needs to be used
+	// when the PageManager is recovered. 0 means that a single file is used
+	ShardSize int64
+
+	// CoalesceTableWrites buffers the pageTables that are modified while
+	// pages are added to an entry and writes each of them only once before
+	// the entry's metadata is updated
+	CoalesceTableWrites bool
+
+	// DefragInterval is the interval in which a background thread truncates
+	// free pages at the end of the file to release disk space. 0 disables
+	// the background thread
+	DefragInterval time.Duration
+
+	// Clock is the source of time of the PageManager. If it is nil the
+	// system's clock is used
+	Clock Clock
+
+	// Logger receives the recovery, repair and defrag events of the
+	// PageManager. If it is nil the events are discarded
+	Logger Logger
+
+	// StrictMode causes failed sanity checks to panic. Otherwise sanity
+	// checks that can be caused by a corrupted file return an error instead
+	StrictMode bool
+
+	// ProgressFn is called periodically during writes and truncations with
+	// the number of bytes that were processed and the total number of bytes
+	// of the operation
+	ProgressFn func(done, total int64)
+
+	// DisableRecycling causes new pages to always be allocated at the end
+	// of the file. Freed pages are still added to the free pages but they
+	// are not reused
+	DisableRecycling bool
+
+	// SparseWrites causes writes beyond the end of an entry to leave the
+	// pages in between unallocated. These pages are tracked as holes and
+	// read as zeros until they are written to
+	SparseWrites bool
+
+	// RecoveryWorkers is the maximum number of goroutines that recover the
+	// subtrees of an entry's pageTable tree concurrently. 0 and 1 recover the
+	// tree sequentially
+	RecoveryWorkers int
+
+	// VerifyWorkers is the maximum number of goroutines that load and check
+	// entries concurrently during Verify and OpenVerified. 0 and 1 verify
+	// the entries sequentially
+	VerifyWorkers int
+
+	// CacheContentHash stores the hash computed by Entry.ContentHash on the
+	// entryPage until the entry is modified
+	CacheContentHash bool
+
+	// CheckpointBytes is the number of bytes that can be written to the
+	// entries before the file is synced automatically. 0 disables the
+	// automatic syncs
+	CheckpointBytes int64
+
+	// AllocAlignment aligns the data pages and extents to a multiple of
+	// AllocAlignment bytes. Unaligned pages like the gaps in front of
+	// aligned pages are only used for metadata. It needs to be a multiple of
+	// the page size. 0 disables the alignment
+	AllocAlignment int64
+
+	// LazyFreeList keeps freed pages in memory and only persists them on
+	// Flush and Close. If the PageManager isn't closed properly, the lost
+	// free pages can be recovered with RebuildFreeList
+	LazyFreeList bool
+
+	// InitialSize is the size a new file is preallocated to. The
+	// preallocated pages are added to the free pages. It needs to be a
+	// multiple of the page size. 0 disables the preallocation
+	InitialSize int64
+
+	// SeparateMetadata allocates pageTables and entryPages from extents of
+	// contiguous pages at the end of the file instead of the free pages to
+	// keep the data pages of entries contiguous. The unused pages of the
+	// last extent are freed on Close and lost otherwise until the free pages
+	// are rebuilt
+	SeparateMetadata bool
+
+	// MaxCachedEntries is the maximum number of entryPages that are kept in
+	// memory. Entries without open handles stay cached until the limit is
+	// exceeded and the least recently used ones are evicted. Open entries are
+	// never evicted. 0 evicts entries as soon as their last handle is closed.
+	// The cache only exists in memory which is why a PageManager can be
+	// recovered with a different limit. ResizeCache changes the limit of an
+	// open PageManager
+	MaxCachedEntries int
+
+	// ReadErrorPolicy decides how reads handle data pages that can't be
+	// read from disk. The default is ReadErrorFail
+	ReadErrorPolicy ReadErrorPolicy
+
+	// VerifyWrites reads back every write to the file and returns
+	// ErrWriteMismatch if the data doesn't match. This catches silent write
+	// failures of unreliable storage at the cost of a read per write
+	VerifyWrites bool
+
+	// ZeroPoolPages is the number of pre-zeroed pages a background thread
+	// keeps at the end of the file. Pages that are allocated at the end of
+	// the file are taken from the pool instead of being zeroed synchronously.
+	// The pages are zeroed and synced before they are added to the pool.
+	// Unused pages of the pool are freed on Close and lost otherwise until
+	// the free pages are rebuilt. It can't be combined with AllocAlignment. 0
+	// disables the pool
+	ZeroPoolPages int
+
+	// TrackModTime records the time of the last modification of an entry on
+	// its entryPage. It is updated by every write and truncation using the
+	// Clock at the cost of an additional write to the entryPage
+	TrackModTime bool
+}
+
+// ReadErrorPolicy is the behavior of reads that encounter an unreadable data
+// page
+type ReadErrorPolicy int
+
+const (
+	// ReadErrorFail aborts the read and returns the error
+	ReadErrorFail ReadErrorPolicy = iota
+
+	// ReadErrorSkipZero reads an unreadable page as zeros to salvage the
+	// rest of the entry
+	ReadErrorSkipZero
+)
+
+// DefaultOptions returns the Options that are used by New
+func DefaultOptions() Options {
+	return Options{
+		CoalesceTableWrites: true,
+		StrictMode:          true,
+	}
+}
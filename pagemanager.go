@@ -1,15 +1,34 @@
 package pages
 
 import (
+	"container/list"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/NebulousLabs/Sia/build"
 )
 
+var (
+	// ErrNoSpace is returned if a page couldn't be allocated because there is
+	// no space left on the device
+	ErrNoSpace = errors.New("no space left on device")
+
+	// ErrNotFound is returned if an Identifier doesn't belong to an entry
+	ErrNotFound = errors.New("entry not found")
+
+	// ErrTruncatedFile is returned if the metadata of an entry references
+	// pages beyond the end of the file. This happens if the file was
+	// truncated by someone else
+	ErrTruncatedFile = errors.New("file is shorter than the metadata of the entry implies")
+)
+
 // Identifier is a helper type that can be used to reopen a previously created
 // entry
 type Identifier int64
@@ -17,7 +36,7 @@ type Identifier int64
 // PageManager blabla
 type PageManager struct {
 	// file is the underlying file to which data is written
-	file *os.File
+	file backingFile
 
 	// freePages contains the pages that can be reused for new data
 	freePages *recyclingPage
@@ -33,6 +52,79 @@ type PageManager struct {
 
 	// entryPages keeps track of all the entryPages
 	entryPages map[Identifier]*entryPage
+
+	// idleEntries contains the Identifiers of the cached entryPages without
+	// open handles ordered from the most to the least recently used.
+	// idleElems maps the Identifiers to their elements
+	idleEntries *list.List
+	idleElems   map[Identifier]*list.Element
+
+	// generation is the last generation that was assigned to a created
+	// entry
+	generation uint64
+
+	// opts are the Options the PageManager was created with
+	opts Options
+
+	// defragRuns is the number of times the background thread released
+	// free pages
+	defragRuns int
+
+	// stopChan is closed to stop the background thread and wg waits for it
+	// to return
+	stopChan chan struct{}
+	wg       *sync.WaitGroup
+
+	// unsyncedBytes is the number of bytes that were written since the last
+	// checkpoint
+	unsyncedBytes int64
+
+	// freeListDirty indicates that free pages were only kept in memory and
+	// that the free pages on disk are incomplete
+	freeListDirty bool
+
+	// recoveredDirty indicates that the free pages had to be repaired when
+	// they were loaded or rebuilt afterwards
+	recoveredDirty bool
+
+	// metaPages are the unused pages of the last extent that was allocated
+	// for metadata if SeparateMetadata is enabled
+	metaPages []*physicalPage
+
+	// directory maps the Identifiers of the live entries to the offsets of
+	// their slots in the dirPages. freeSlots are the offsets of the unused
+	// slots. Entries are registered and unregistered while p.mu is held
+	directory map[Identifier]int64
+	dirPages  []*physicalPage
+	freeSlots []int64
+
+	// gapPages are the unaligned pages that are skipped by allocatePage if
+	// AllocAlignment is enabled. They are handed out by allocateMetaPage
+	// instead since metadata doesn't need to be aligned
+	gapPages []*physicalPage
+
+	// zeroPages are the pre-zeroed pages at the end of the file that are
+	// handed out by allocatePage if ZeroPoolPages is enabled. reservedZeroPages
+	// are the pages that were reserved for the pool but are still being
+	// zeroed. zeroPoolChan signals the background thread to refill them
+	zeroPages         []*physicalPage
+	reservedZeroPages []*physicalPage
+	zeroPoolChan      chan struct{}
+
+	// txnMu serializes the commits of transactions and replacements of
+	// entries
+	txnMu *sync.Mutex
+
+	// indexMu serializes Put and Get. index contains the pairs of the index
+	// entry sorted by key. It is nil until the index is loaded
+	indexMu *sync.Mutex
+	index   []indexPair
+	indexID Identifier
+
+	// cacheHits and cacheMisses count the number of times an entry was
+	// opened from the cached entryPages or had to be loaded from disk
+	cacheHits   uint64
+	cacheMisses uint64
 }
 
 // allocatePage either returns a free page or allocates a page and adds
@@ -40,20 +132,31 @@ type PageManager struct {
 func (p *PageManager) allocatePage() (*physicalPage, error) {
 	// If there are free pages available return one of those
 	var newPage *physicalPage
-	if p.recyclePages && p.freePages != nil && p.freePages.availablePages() > 0 {
+	align := p.opts.AllocAlignment
+	for p.recyclePages && !p.opts.DisableRecycling && p.freePages != nil && p.freePages.availablePages() > 0 {
 		removedPage, err := p.freePages.freePage()
 		if err != nil {
 			return nil, build.ExtendErr("Failed to reuse free page", err)
 		}
+		// Set unaligned pages aside for metadata
+		if align > 0 && removedPage.fileOff%align != 0 {
+			p.gapPages = append(p.gapPages, removedPage)
+			continue
+		}
 		return removedPage, nil
+	}
 
+	// Hand out a page that was already zeroed by the background thread
+	if page := p.takeZeroPage(); page != nil {
+		return page, nil
 	}
 
 	// Get the fileOff for the page
-	fileOff, err := p.file.Seek(0, io.SeekEnd)
+	fileEnd, err := p.file.Seek(0, io.SeekEnd)
 	if err != nil {
 		return nil, err
 	}
+	fileOff := fileEnd
 
 	// The last page might not have pageSize yet so we might have to adjust the
 	// offset a bit
@@ -66,74 +169,332 @@ func (p *PageManager) allocatePage() (*physicalPage, error) {
 		fileOff = dataOff
 	}
 
+	// Align the page if necessary. Pages that are allocated while pages are
+	// added to the recycling page aren't aligned since they are metadata
+	gapOff := fileOff
+	if align > 0 && p.recyclePages && p.freePages != nil && fileOff%align != 0 {
+		fileOff += align - fileOff%align
+	}
+
 	// Create the new page and write it to disk
 	newPage = &physicalPage{
 		file:    p.file,
 		fileOff: fileOff,
+		strict:  p.opts.StrictMode,
 	}
 
 	// TODO maybe remove this but if we do we have to fix the way we calculate
 	// the fileOff for new pages
-	n, err := newPage.file.WriteAt(make([]byte, pageSize, pageSize), newPage.fileOff)
+	n, err := writeFull(newPage.file, make([]byte, pageSize, pageSize), newPage.fileOff)
+	if isNoSpace(err) {
+		// Remove the partially written page to not waste space once the
+		// disk has room again
+		p.file.Truncate(fileEnd)
+		return nil, ErrNoSpace
+	}
 	if n != pageSize || err != nil {
 		return nil, fmt.Errorf("couldn't write new page wrote %v bytes %v", n, err)
 	}
 
+	// Set the gap in front of an aligned page aside for metadata
+	p.addGapPages(gapOff, fileOff)
 	return newPage, nil
 }
 
-// Close closes open handles and frees ressources
-func (p PageManager) Close() error {
-	return p.file.Close()
+// addGapPages adds the pages between start and end to the gapPages
+func (p *PageManager) addGapPages(start, end int64) {
+	for off := start; off < end; off += pageSize {
+		p.gapPages = append(p.gapPages, &physicalPage{
+			file:    p.file,
+			fileOff: off,
+			strict:  p.opts.StrictMode,
+		})
+	}
 }
 
-// Create creates a new Entry and returns an identifier for it
-func (p *PageManager) Create() (*Entry, Identifier, error) {
+// allocateMetaPage allocates a page for metadata like pageTables and
+// entryPages. If SeparateMetadata is enabled, the page is taken from an extent
+// of contiguous pages at the end of the file instead of the free pages
+func (p *PageManager) allocateMetaPage() (*physicalPage, error) {
+	// Fill the gaps in front of aligned pages first
+	if len(p.gapPages) > 0 {
+		page := p.gapPages[len(p.gapPages)-1]
+		p.gapPages = p.gapPages[:len(p.gapPages)-1]
+		return page, nil
+	}
+	if !p.opts.SeparateMetadata {
+		return p.allocatePage()
+	}
+	if len(p.metaPages) == 0 {
+		// Pages that are added to the recycling page can't wait for a new
+		// extent since they might be the remaining pages of the last one
+		if !p.recyclePages {
+			return p.allocatePage()
+		}
+		extent, err := p.allocateMetaExtent()
+		if err != nil {
+			return nil, err
+		}
+		p.metaPages = extent
+	}
+	page := p.metaPages[0]
+	p.metaPages = p.metaPages[1:]
+	return page, nil
+}
+
+// allocateMetaExtent allocates metaExtentPages contiguous pages at the end of
+// the file
+func (p *PageManager) allocateMetaExtent() ([]*physicalPage, error) {
+	return p.allocateExtent(metaExtentPages)
+}
+
+// managedAllocateExtent allocates n contiguous pages at the end of the file
+func (p *PageManager) managedAllocateExtent(n int64) ([]*physicalPage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.allocateExtent(n)
+}
+
+// managedReturnExtent gives back the unused pages at the end of an extent. If
+// no page was allocated after the extent, the file is truncated. Otherwise
+// the pages are freed
+func (p *PageManager) managedReturnExtent(pages []*physicalPage) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.returnExtent(pages)
+}
 
-	// Allocate a page for the table
-	pp, err := p.allocatePage()
+// returnExtent is the same as managedReturnExtent but requires the p.mu lock
+// to be acquired
+func (p *PageManager) returnExtent(pages []*physicalPage) error {
+	if len(pages) == 0 {
+		return nil
+	}
+	stat, err := p.file.Stat()
 	if err != nil {
-		return nil, 0, build.ExtendErr("failed to allocate page for new entryPage", err)
+		return build.ExtendErr("failed to get size of file", err)
 	}
+	if stat.Size() == pages[len(pages)-1].fileOff+pageSize {
+		return p.file.Truncate(pages[0].fileOff)
+	}
+	return p.addFreePages(pages)
+}
 
-	// Create the first pageTable
-	root, err := newPageTable(0, nil, p)
+// allocateExtent allocates n contiguous pages at the end of the file. The
+// extent starts at a multiple of AllocAlignment
+func (p *PageManager) allocateExtent(n int64) ([]*physicalPage, error) {
+	fileEnd, err := p.file.Seek(0, io.SeekEnd)
 	if err != nil {
-		return nil, 0, build.ExtendErr("Couldn't create new pageTable", err)
+		return nil, err
+	}
+	fileOff := fileEnd
+	if fileOff%pageSize != 0 {
+		fileOff += (pageSize - fileOff%pageSize)
+	}
+	if fileOff < dataOff {
+		fileOff = dataOff
+	}
+	gapOff := fileOff
+	if align := p.opts.AllocAlignment; align > 0 && fileOff%align != 0 {
+		fileOff += align - fileOff%align
 	}
 
-	// Create the entryPage
-	ep := &entryPage{
-		&tieredPage{
-			pp:   pp,
-			pm:   p,
-			root: root,
-			mu:   new(sync.RWMutex),
-		},
-		0,
+	// Write the extent to disk
+	_, err = writeFull(p.file, make([]byte, n*pageSize), fileOff)
+	if isNoSpace(err) {
+		p.file.Truncate(fileEnd)
+		return nil, ErrNoSpace
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't write extent %v", err)
 	}
+	p.addGapPages(gapOff, fileOff)
 
-	// Initialize entryPage
-	if err := writeTieredPageEntry(pp, 0, 0, ep.pp.fileOff); err != nil {
+	extent := make([]*physicalPage, 0, n)
+	for i := int64(0); i < n; i++ {
+		extent = append(extent, &physicalPage{
+			file:    p.file,
+			fileOff: fileOff + i*pageSize,
+			strict:  p.opts.StrictMode,
+		})
+	}
+	return extent, nil
+}
+
+// isNoSpace returns true if err was caused by a full disk
+func isNoSpace(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	return err == syscall.ENOSPC
+}
+
+// sanityCheckFailed is called if a sanity check fails. It panics in strict
+// mode and returns the message as an error otherwise
+func sanityCheckFailed(strict bool, msg string) error {
+	if strict {
+		panic(msg)
+	}
+	return errors.New(msg)
+}
+
+// extendErr works like build.ExtendErr but returns ErrNoSpace unchanged to
+// allow callers to react to a full disk
+func extendErr(s string, err error) error {
+	if err == ErrNoSpace {
+		return err
+	}
+	return build.ExtendErr(s, err)
+}
+
+// Close closes open handles and frees ressources
+func (p *PageManager) Close() error {
+	// Stop the background thread
+	if p.stopChan != nil {
+		close(p.stopChan)
+		p.wg.Wait()
+	}
+
+	// Free the unused metadata pages and pre-zeroed pages and persist the
+	// free pages that are only buffered in memory
+	p.mu.Lock()
+	p.freePages.pagesToFree = append(p.freePages.pagesToFree, p.metaPages...)
+	p.freePages.pagesToFree = append(p.freePages.pagesToFree, p.gapPages...)
+	p.freePages.pagesToFree = append(p.freePages.pagesToFree, p.zeroPages...)
+	p.metaPages = nil
+	p.gapPages = nil
+	p.zeroPages = nil
+	err := p.flushFreePages()
+	p.mu.Unlock()
+	if err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
+
+// Create creates a new Entry and returns an identifier for it
+func (p *PageManager) Create() (*Entry, Identifier, error) {
+	pp, root, generation, err := p.managedAllocateEntry()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Create the entryPage
+	ep := newEntryPage(&tieredPage{
+		pp:   pp,
+		pm:   p,
+		root: root,
+		mu:   new(sync.RWMutex),
+	}, generation)
+
+	// Initialize entryPage. Nobody else knows the Identifier of the entry
+	// yet which is why the lock isn't needed
+	if err := writeTieredPageEntry(pp, 0, 0, root.pp.fileOff); err != nil {
+		return nil, 0, err
+	}
+	if err := writeGeneration(pp, ep.generation); err != nil {
+		return nil, 0, err
+	}
+	if err := writeContentHash(pp, ep.generation, nil); err != nil {
+		return nil, 0, err
+	}
+	if err := writeEntryMarker(pp, true); err != nil {
 		return nil, 0, err
 	}
 
 	// Create a new entry
 	newEntry := &Entry{
-		pm: p,
-		ep: ep,
+		pm:         p,
+		ep:         ep,
+		generation: ep.generation,
+	}
+	if err := newEntry.touch(); err != nil {
+		return nil, 0, err
 	}
 
-	// Increment the entryPage's counter and add it to the map
+	// Register the entry, increment the entryPage's counter and add it to
+	// the map
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	id := Identifier(ep.pp.fileOff)
+	if err := p.registerEntry(id); err != nil {
+		return nil, 0, err
+	}
 	p.entryPages[id] = ep
 	ep.instanceCounter++
+	p.evictIdleEntries()
 
 	return newEntry, id, nil
 }
 
+// managedAllocateEntry allocates the entryPage and the root of a new entry and
+// assigns the next generation to it
+func (p *PageManager) managedAllocateEntry() (pp *physicalPage, root *pageTable, generation uint64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Allocate a page for the table
+	pp, err = p.allocateMetaPage()
+	if err != nil {
+		return nil, nil, 0, build.ExtendErr("failed to allocate page for new entryPage", err)
+	}
+
+	// Create the first pageTable
+	root, err = newPageTable(0, nil, p)
+	if err != nil {
+		return nil, nil, 0, build.ExtendErr("Couldn't create new pageTable", err)
+	}
+
+	// Assign the next generation to the entry
+	p.generation++
+	if err := writeGeneration(p.freePages.pp, p.generation); err != nil {
+		return nil, nil, 0, build.ExtendErr("failed to persist generation", err)
+	}
+	return pp, root, p.generation, nil
+}
+
+// Reserve reserves an Identifier for a new entry. Only the entryPage is
+// allocated. Opening the Identifier returns an empty entry
+func (p *PageManager) Reserve() (Identifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Allocate a page for the entryPage
+	pp, err := p.allocateMetaPage()
+	if err != nil {
+		return 0, build.ExtendErr("failed to allocate page for new entryPage", err)
+	}
+
+	// Assign the next generation to the entry
+	p.generation++
+	if err := writeGeneration(p.freePages.pp, p.generation); err != nil {
+		return 0, build.ExtendErr("failed to persist generation", err)
+	}
+
+	// Initialize the entryPage without a root. The root is created when the
+	// entry is loaded for the first time
+	if err := writeTieredPageEntry(pp, 0, 0, 0); err != nil {
+		return 0, err
+	}
+	if err := writeGeneration(pp, p.generation); err != nil {
+		return 0, err
+	}
+	if err := writeContentHash(pp, p.generation, nil); err != nil {
+		return 0, err
+	}
+	if err := writeEntryMarker(pp, true); err != nil {
+		return 0, err
+	}
+	if err := p.registerEntry(Identifier(pp.fileOff)); err != nil {
+		return 0, err
+	}
+	return Identifier(pp.fileOff), nil
+}
+
 // loadFreePagesFromDisk loads the offsets of free pages from the first page of
 // the file.
 func (p *PageManager) loadFreePagesFromDisk() error {
@@ -143,6 +504,7 @@ func (p *PageManager) loadFreePagesFromDisk() error {
 		file:     p.file,
 		fileOff:  freeOff,
 		usedSize: pageSize,
+		strict:   p.opts.StrictMode,
 	}
 
 	// Read all the entries from the entryPage and remember the root and usedSize
@@ -150,7 +512,7 @@ func (p *PageManager) loadFreePagesFromDisk() error {
 	usedSize := int64(0)
 	height := int64(0)
 	var err error
-	for i := 0; i < pageSize/tieredPageEntrySize; i++ {
+	for i := 0; i < numTreeSlots; i++ {
 		usedSize, rootOff, err = readEntryPageEntry(pp, int64(i))
 		if err != nil {
 			return build.ExtendErr("Failed to read entry", err)
@@ -176,148 +538,1225 @@ func (p *PageManager) loadFreePagesFromDisk() error {
 		nil,
 	}
 
-	// Recover the tree to get the pages of the entry
+	// Recover the tree to get the pages of the entry. The entries don't
+	// depend on the free pages which is why a corrupt tree is replaced by an
+	// empty one. The lost free pages can be reclaimed by RebuildFreeList
 	if err := ep.recoverTree(rootOff, height); err != nil {
-		return build.ExtendErr("Failed to recover tree", err)
+		p.opts.Logger.Error("failed to recover free pages. starting without free pages: %v", err)
+		if err := p.resetFreePages(ep); err != nil {
+			return build.ExtendErr("Failed to reset free pages", err)
+		}
+	} else {
+		// Repair the free pages if they were torn
+		p.recoveredDirty, err = ep.repair()
+		if err != nil {
+			return build.ExtendErr("Failed to repair free pages", err)
+		}
+		if p.recoveredDirty {
+			p.opts.Logger.Warn("repaired torn free pages. %v free pages remain", len(ep.pages))
+		}
+	}
+
+	// Load the last assigned generation
+	p.generation, err = readGeneration(pp)
+	if err != nil {
+		return build.ExtendErr("Failed to read generation", err)
+	}
+
+	// Check if free pages were lost
+	p.freeListDirty, err = readFreeListDirty(pp)
+	if err != nil {
+		return build.ExtendErr("Failed to read free list flag", err)
+	}
+	if p.freeListDirty {
+		p.opts.Logger.Warn("free pages weren't persisted before the file was closed")
 	}
 
 	p.freePages = ep
+	p.opts.Logger.Info("recovered %v free pages and generation %v", len(ep.pages), p.generation)
 	return nil
 
 }
 
-// managedAllocatePage either returns a free page or allocates a page and adds
-// it to the pages map.
-func (p *PageManager) managedAllocatePage() (*physicalPage, error) {
+// resetFreePages replaces the tree of a recycling page that couldn't be
+// recovered with an empty one. The root is allocated at the end of the file
+// to not reuse a page the corrupt tree might still point to. The free pages
+// are marked as dirty until they are rebuilt
+func (p *PageManager) resetFreePages(rp *recyclingPage) error {
+	extent, err := p.allocateExtent(1)
+	if err != nil {
+		return build.ExtendErr("failed to allocate root", err)
+	}
+	rp.root = &pageTable{
+		height:      0,
+		pp:          extent[0],
+		childPages:  make(map[uint64]*physicalPage),
+		childTables: make(map[uint64]*pageTable),
+	}
+	rp.usedSize = 0
+	rp.pages = nil
+	if err := writeTieredPageEntry(rp.pp, 0, 0, rp.root.pp.fileOff); err != nil {
+		return build.ExtendErr("failed to write root", err)
+	}
+	if err := writeFreeListDirty(rp.pp, true); err != nil {
+		return build.ExtendErr("failed to mark free pages as dirty", err)
+	}
+	p.recoveredDirty = true
+	return nil
+}
+
+// Reopen discards the free pages, the directory and the generation that are
+// kept in memory and reads them from disk again without closing the file. This is necessary
+// if the file was modified by a different PageManager. Entries can't be open
+// while the PageManager is reopened
+func (p *PageManager) Reopen() error {
+	p.indexMu.Lock()
+	defer p.indexMu.Unlock()
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.allocatePage()
-}
+	if n := p.openEntries(); n > 0 {
+		return fmt.Errorf("can't reopen PageManager while %v entries are open", n)
+	}
 
-// New creates a PageManager or recovers an existing one
-func New(filePath string) (*PageManager, error) {
-	// Create the page manager object
-	pm := &PageManager{
-		mu:           new(sync.Mutex),
-		entryPages:   make(map[Identifier]*entryPage),
-		recyclePages: true,
+	// The cached entries and the cached index might have been modified by a
+	// different PageManager
+	p.entryPages = make(map[Identifier]*entryPage)
+	p.idleEntries.Init()
+	p.idleElems = make(map[Identifier]*list.Element)
+	p.index, p.indexID = nil, 0
+	if err := p.loadFreePagesFromDisk(); err != nil {
+		return build.ExtendErr("failed to read free pages", err)
+	}
+	if err := p.loadDirectory(); err != nil {
+		return build.ExtendErr("failed to read directory", err)
 	}
+	return nil
+}
 
-	// Try to open the database file
-	file, err := os.OpenFile(filePath, os.O_RDWR, 0600)
-	if err == nil {
-		// There is a file that can be recovered
-		pm.file = file
+// Fork flushes the PageManager, copies its file to path and returns a
+// PageManager for the copy. Modifying the fork doesn't affect the original.
+// Writes to open entries that happen while the file is copied might only be
+// partially visible in the fork
+func (p *PageManager) Fork(path string) (*PageManager, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-		// Load the freePages
-		if err := pm.loadFreePagesFromDisk(); err != nil {
-			return nil, build.ExtendErr("failed to read free pages", err)
-		}
-		return pm, nil
-	} else if !os.IsNotExist(err) {
-		// The file exists but cannot be opened
-		return nil, build.ExtendErr("Failed to open existing database file", err)
+	// Persist the free pages that are only buffered in memory
+	if err := p.flushFreePages(); err != nil {
+		return nil, err
+	}
+	if err := p.file.Sync(); err != nil {
+		return nil, build.ExtendErr("failed to sync file", err)
 	}
 
-	// If the file doesn't exist create a new one
-	file, err = os.Create(filePath)
+	// Copy the file
+	stat, err := p.file.Stat()
 	if err != nil {
-		return nil, build.ExtendErr("Failed to create the database file: %v", err)
+		return nil, build.ExtendErr("failed to get size of file", err)
 	}
-	pm.file = file
-
-	// Create the pageEntry for the free pages.
-	root, err := newPageTable(0, nil, pm)
+	file, err := openBackingFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, p.opts)
 	if err != nil {
-		return nil, build.ExtendErr("Failed to create pageTable for recycling page", err)
+		return nil, build.ExtendErr("failed to create fork", err)
 	}
-	rp := &recyclingPage{
-		&tieredPage{
-			pm:   pm,
-			root: root,
-			mu:   new(sync.RWMutex),
-			pp: &physicalPage{
-				file:     pm.file,
-				fileOff:  freeOff,
-				usedSize: pageSize,
-			},
-		},
-		nil,
+	if err := copyBackingFile(file, p.file, stat.Size()); err != nil {
+		file.Close()
+		return nil, build.ExtendErr("failed to copy file", err)
 	}
-	pm.freePages = rp
-
-	return pm, nil
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, build.ExtendErr("failed to sync fork", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, build.ExtendErr("failed to close fork", err)
+	}
+	return NewWithOptions(path, p.opts)
 }
 
-// Open loads a previously created entry
-func (p *PageManager) Open(id Identifier) (*Entry, error) {
+// managedAddFreePages adds pages to the freePages to be reused by future
+// allocations
+func (p *PageManager) managedAddFreePages(pages []*physicalPage) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.addFreePages(pages)
+}
 
-	// Check if the identifier was opened before
-	if ep, exists := p.entryPages[id]; exists {
-		// Increase the instance counter of the entryPage
-		ep.instanceCounter++
-		return &Entry{
-			pm: p,
-			ep: ep,
-		}, nil
-	}
-
-	// Create the physicalPage object using the identifier. We don't know
-	// usedSize yet but for the entryPage we can just set it to pageSize
-	pp := &physicalPage{
-		file:     p.file,
-		fileOff:  int64(id),
-		usedSize: pageSize,
+// addFreePages is the same as managedAddFreePages but requires the p.mu lock
+// to be acquired
+func (p *PageManager) addFreePages(pages []*physicalPage) error {
+	if !p.opts.LazyFreeList {
+		return p.freePages.addPages(pages)
 	}
 
-	// Read all the entries from the entryPage and remember the root and usedSize
-	rootOff := int64(0)
-	usedSize := int64(0)
-	height := int64(0)
-	var err error
-	for i := 0; i < pageSize/tieredPageEntrySize; i++ {
-		usedSize, rootOff, err = readEntryPageEntry(pp, int64(i))
-		if err != nil {
-			return nil, build.ExtendErr("Failed to read entry", err)
-		}
-
-		// Remember the reached height
-		height = int64(i)
-
-		// Stop if we find a root that isn't full yet
-		numPages := int64(math.Pow(float64(numPageEntries), float64(i+1)))
-		if usedSize < numPages*pageSize {
-			break
+	// Keep the pages in memory and mark the free pages on disk as incomplete
+	if !p.freeListDirty {
+		if err := writeFreeListDirty(p.freePages.pp, true); err != nil {
+			return build.ExtendErr("failed to mark free pages as dirty", err)
 		}
+		p.freeListDirty = true
+	}
+	for _, page := range pages {
+		page.usedSize = pageSize
 	}
+	p.freePages.pagesToFree = append(p.freePages.pagesToFree, pages...)
+	return nil
+}
 
-	// Create the entryPage object and recover the tree.
-	ep := &entryPage{
-		&tieredPage{
-			pp:       pp,
-			usedSize: usedSize,
-			pm:       p,
-			mu:       new(sync.RWMutex),
-		},
-		0,
+// Flush persists the free pages that are only kept in memory and syncs the
+// file
+func (p *PageManager) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.flushFreePages(); err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+// flushFreePages persists the free pages that are only kept in memory. The
+// p.mu lock needs to be acquired
+func (p *PageManager) flushFreePages() error {
+	if err := p.freePages.flushBuffer(); err != nil {
+		return build.ExtendErr("failed to flush free pages", err)
+	}
+	if !p.freeListDirty {
+		return nil
+	}
+	if err := writeFreeListDirty(p.freePages.pp, false); err != nil {
+		return build.ExtendErr("failed to mark free pages as clean", err)
+	}
+	p.freeListDirty = false
+	return nil
+}
+
+// FreeListDirty returns true if the PageManager was recovered from a file
+// whose free pages weren't persisted completely. RebuildFreeList can be used
+// to find the lost free pages
+func (p *PageManager) FreeListDirty() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.freeListDirty
+}
+
+// WasRepaired returns true if the free pages had to be repaired when the
+// PageManager was opened or if they were rebuilt with RebuildFreeList
+func (p *PageManager) WasRepaired() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.recoveredDirty
+}
+
+// RebuildFreeList scans the entries with the specified Identifiers and the
+// recycling page for the pages they use and adds all the other pages of the
+// file to the free pages. ids needs to contain all the entries of the
+// PageManager. Otherwise pages of the missing entries will be reused. The
+// entries of the index used by Put and Get are added automatically. Entries
+// can't be open while the free pages are rebuilt
+func (p *PageManager) RebuildFreeList(ids []Identifier) error {
+	p.indexMu.Lock()
+	defer p.indexMu.Unlock()
+	ids, err := p.withIndexIDs(ids)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := p.openEntries(); n > 0 {
+		return fmt.Errorf("can't rebuild free pages while %v entries are open", n)
+	}
+
+	// Every orphaned page is free
+	orphans, err := p.orphans(ids)
+	if err != nil {
+		return err
+	}
+	lost := make([]*physicalPage, 0, len(orphans))
+	for _, off := range orphans {
+		lost = append(lost, &physicalPage{
+			file:    p.file,
+			fileOff: off,
+			strict:  p.opts.StrictMode,
+		})
+	}
+	p.freePages.pagesToFree = append(p.freePages.pagesToFree, lost...)
+	if err := p.flushFreePages(); err != nil {
+		return err
+	}
+	p.recoveredDirty = true
+	p.opts.Logger.Info("rebuilt free pages. reclaimed %v orphaned pages", len(lost))
+	return nil
+}
+
+// FindOrphans returns the offsets of the pages that are neither used by the
+// entries with the specified Identifiers nor free. These pages were leaked and
+// can be reclaimed with RebuildFreeList. ids needs to contain all the entries
+// of the PageManager except for the entries of the index used by Put and Get
+// which are added automatically. Entries can't be open while the pages are
+// scanned
+func (p *PageManager) FindOrphans(ids []Identifier) ([]int64, error) {
+	p.indexMu.Lock()
+	defer p.indexMu.Unlock()
+	ids, err := p.withIndexIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := p.openEntries(); n > 0 {
+		return nil, fmt.Errorf("can't find orphaned pages while %v entries are open", n)
+	}
+	return p.orphans(ids)
+}
+
+// orphans returns the offsets of the pages that are neither used by the
+// entries with the specified Identifiers nor by the recycling page. The p.mu
+// lock needs to be acquired and no entries may be open
+func (p *PageManager) orphans(ids []Identifier) ([]int64, error) {
+	// Collect the pages that are in use or already free
+	used := make(map[int64]struct{})
+	used[p.freePages.pp.fileOff] = struct{}{}
+	for _, page := range p.freePages.pages {
+		used[page.fileOff] = struct{}{}
+	}
+	for _, page := range p.freePages.pagesToFree {
+		used[page.fileOff] = struct{}{}
+	}
+	for _, page := range p.metaPages {
+		used[page.fileOff] = struct{}{}
+	}
+	for _, page := range p.gapPages {
+		used[page.fileOff] = struct{}{}
+	}
+	for _, page := range p.dirPages {
+		used[page.fileOff] = struct{}{}
+	}
+	for _, page := range p.zeroPages {
+		used[page.fileOff] = struct{}{}
+	}
+	for _, page := range p.reservedZeroPages {
+		used[page.fileOff] = struct{}{}
+	}
+	markTables(p.freePages.root, used)
+	for _, id := range ids {
+		ep, err := p.peekEntryPage(id, false)
+		if err != nil {
+			return nil, build.ExtendErr(fmt.Sprintf("failed to load entry %v", id), err)
+		}
+		used[ep.pp.fileOff] = struct{}{}
+		markTables(ep.root, used)
+		for _, page := range ep.pages {
+			if page != nil {
+				used[page.fileOff] = struct{}{}
+			}
+		}
+	}
+
+	// Every other page of the file is orphaned
+	stat, err := p.file.Stat()
+	if err != nil {
+		return nil, build.ExtendErr("failed to get size of file", err)
+	}
+	var orphans []int64
+	for off := int64(dataOff); off+pageSize <= stat.Size(); off += pageSize {
+		if _, isUsed := used[off]; !isUsed {
+			orphans = append(orphans, off)
+		}
+	}
+	return orphans, nil
+}
+
+// RepairSizes compares the size that is recorded for the entries with the
+// specified Identifiers with the pages of their trees. The size of an entry
+// that claims more bytes than its pages can hold is reduced to the capacity of
+// the pages. Pages beyond the size of an entry are not an inconsistency since
+// TrimToSize keeps them. Entries can't be open while their sizes are repaired.
+// It returns the number of repaired entries
+func (p *PageManager) RepairSizes(ids []Identifier) (repaired int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := p.openEntries(); n > 0 {
+		return 0, fmt.Errorf("can't repair sizes while %v entries are open", n)
+	}
+	for _, id := range ids {
+		// Drop the cached entryPage to recover the tree from disk
+		p.removeIdleEntry(id)
+		delete(p.entryPages, id)
+
+		ep, err := p.loadEntryPage(id, false)
+		if err != nil {
+			return repaired, build.ExtendErr(fmt.Sprintf("failed to load entry %v", id), err)
+		}
+		capacity := int64(len(ep.pages)) * pageSize
+		if ep.usedSize <= capacity {
+			continue
+		}
+		if err := writeTieredPageEntry(ep.pp, ep.root.height, capacity, ep.root.pp.fileOff); err != nil {
+			return repaired, build.ExtendErr(fmt.Sprintf("failed to repair size of entry %v", id), err)
+		}
+		p.opts.Logger.Warn("repaired size of entry %v from %v to %v bytes", id, ep.usedSize, capacity)
+		repaired++
+	}
+	if repaired == 0 {
+		return 0, nil
+	}
+	if err := p.file.Sync(); err != nil {
+		return repaired, build.ExtendErr("failed to sync repaired sizes", err)
+	}
+	return repaired, nil
+}
+
+// markTables adds the offsets of pt and its child tables to used
+func markTables(pt *pageTable, used map[int64]struct{}) {
+	if pt == nil {
+		return
+	}
+	used[pt.pp.fileOff] = struct{}{}
+	for _, child := range pt.childTables {
+		markTables(child, used)
+	}
+}
+
+// ReleaseSpace truncates the free pages at the end of the file right away
+// instead of waiting for the background thread and returns the number of
+// bytes that were released
+func (p *PageManager) ReleaseSpace() (reclaimed int64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.releaseSpace()
+}
+
+// releaseSpace truncates the free pages at the end of the file and returns
+// the number of bytes that were released
+func (p *PageManager) releaseSpace() (int64, error) {
+	stat, err := p.file.Stat()
+	if err != nil {
+		return 0, build.ExtendErr("failed to get size of file", err)
+	}
+	fileSize := stat.Size()
+	end := fileSize
+	if end%pageSize != 0 {
+		end += pageSize - end%pageSize
+	}
+
+	// Nothing to do if the last page isn't free
+	free := make(map[int64]struct{})
+	for _, page := range p.freePages.pages {
+		free[page.fileOff] = struct{}{}
+	}
+	for _, page := range p.freePages.pagesToFree {
+		free[page.fileOff] = struct{}{}
+	}
+	if _, isFree := free[end-pageSize]; !isFree {
+		return 0, nil
+	}
+
+	// Take all the pages out of the recycling page. This also frees the
+	// pageTables of the recycling page
+	var pages []*physicalPage
+	for p.freePages.availablePages() > 0 {
+		page, err := p.freePages.freePage()
+		if err != nil {
+			return 0, build.ExtendErr("failed to take page from recycling page", err)
+		}
+		pages = append(pages, page)
+		free[page.fileOff] = struct{}{}
+	}
+
+	// Find the start of the free pages at the end of the file
+	for end > dataOff {
+		if _, isFree := free[end-pageSize]; !isFree {
+			break
+		}
+		end -= pageSize
+	}
+
+	// Truncate the file and put the remaining pages back into the recycling
+	// page
+	var remainingPages []*physicalPage
+	for _, page := range pages {
+		if page.fileOff < end {
+			remainingPages = append(remainingPages, page)
+		}
+	}
+	if err := p.file.Truncate(end); err != nil {
+		return 0, build.ExtendErr("failed to truncate file", err)
+	}
+	if err := p.freePages.addPages(remainingPages); err != nil {
+		return 0, build.ExtendErr("failed to add remaining pages to recycling page", err)
+	}
+
+	// The recycling page might have allocated new pageTables at the end of
+	// the file
+	stat, err = p.file.Stat()
+	if err != nil {
+		return 0, build.ExtendErr("failed to get size of file", err)
+	}
+	return fileSize - stat.Size(), nil
+}
+
+// CompactFreeList sorts the free pages to hand out the free pages with the
+// lowest offsets first. This causes pages that are reused by sequential writes
+// to be contiguous. The pageTables that are needed to store the sorted free
+// pages are allocated at the end of the file
+func (p *PageManager) CompactFreeList() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Take all the pages out of the recycling page. This also frees the
+	// pageTables of the recycling page
+	var pages []*physicalPage
+	for p.freePages.availablePages() > 0 {
+		page, err := p.freePages.freePage()
+		if err != nil {
+			return build.ExtendErr("failed to take page from recycling page", err)
+		}
+		pages = append(pages, page)
+	}
+
+	// Free pages are handed out starting at the end which is why they are
+	// added in descending order
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].fileOff > pages[j].fileOff
+	})
+	if err := p.freePages.addPages(pages); err != nil {
+		return build.ExtendErr("failed to add sorted pages to recycling page", err)
+	}
+	return nil
+}
+
+// threadedDefrag periodically releases the free pages at the end of the file
+// until the PageManager is closed
+func (p *PageManager) threadedDefrag(ticker Ticker) {
+	defer p.wg.Done()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C():
+		}
+
+		// There is no caller to report errors to. The next run will try
+		// again
+		p.mu.Lock()
+		released, err := p.releaseSpace()
+		p.defragRuns++
+		p.mu.Unlock()
+		switch {
+		case err != nil:
+			p.opts.Logger.Error("defrag failed: %v", err)
+		case released > 0:
+			p.opts.Logger.Info("defrag released %v bytes", released)
+		default:
+			p.opts.Logger.Debug("defrag found no free pages at the end of the file")
+		}
+	}
+}
+
+// managedCheckpoint adds n to the number of bytes that were written since the
+// last checkpoint. If they exceed CheckpointBytes, the file is synced
+func (p *PageManager) managedCheckpoint(n int64) error {
+	if p.opts.CheckpointBytes <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unsyncedBytes += n
+	if p.unsyncedBytes < p.opts.CheckpointBytes {
+		return nil
+	}
+	p.unsyncedBytes = 0
+	if err := p.file.Sync(); err != nil {
+		return build.ExtendErr("failed to sync checkpoint", err)
+	}
+	return nil
+}
+
+// managedAllocatePage either returns a free page or allocates a page and adds
+// it to the pages map.
+func (p *PageManager) managedAllocatePage() (*physicalPage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.allocatePage()
+}
+
+// New creates a PageManager or recovers an existing one using the
+// DefaultOptions
+func New(filePath string) (*PageManager, error) {
+	return NewWithOptions(filePath, DefaultOptions())
+}
+
+// NewWithOptions creates a PageManager or recovers an existing one using
+// custom Options
+func NewWithOptions(filePath string, opts Options) (*PageManager, error) {
+	// Create the page manager object
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
+	if opts.Logger == nil {
+		opts.Logger = noopLogger{}
+	}
+	if opts.AllocAlignment < 0 || opts.AllocAlignment%pageSize != 0 {
+		return nil, fmt.Errorf("allocation alignment %v is not a multiple of the page size", opts.AllocAlignment)
+	}
+	if opts.InitialSize < 0 || opts.InitialSize%pageSize != 0 {
+		return nil, fmt.Errorf("initial size %v is not a multiple of the page size", opts.InitialSize)
+	}
+	if opts.ZeroPoolPages < 0 {
+		return nil, fmt.Errorf("zero pool size %v is negative", opts.ZeroPoolPages)
+	}
+	if opts.ZeroPoolPages > 0 && opts.AllocAlignment > 0 {
+		return nil, errors.New("zero pool can't be used with allocation alignment")
+	}
+	pm := &PageManager{
+		mu:           new(sync.Mutex),
+		entryPages:   make(map[Identifier]*entryPage),
+		idleEntries:  list.New(),
+		idleElems:    make(map[Identifier]*list.Element),
+		recyclePages: true,
+		opts:         opts,
+		txnMu:        new(sync.Mutex),
+		indexMu:      new(sync.Mutex),
+	}
+
+	// Try to open the database file
+	file, err := openBackingFile(filePath, os.O_RDWR, opts)
+	if err == nil {
+		// There is a file that can be recovered
+		pm.file = file
+
+		// Load the freePages
+		if err := pm.loadFreePagesFromDisk(); err != nil {
+			file.Close()
+			return nil, build.ExtendErr("failed to read free pages", err)
+		}
+
+		// Load the directory of the entries
+		if err := pm.loadDirectory(); err != nil {
+			file.Close()
+			return nil, build.ExtendErr("failed to read directory", err)
+		}
+
+		// Finish a transaction that was committed but maybe not applied
+		if err := pm.replayTxnLog(); err != nil {
+			file.Close()
+			return nil, build.ExtendErr("failed to replay transaction", err)
+		}
+
+		// Finish a replacement that was interrupted
+		if err := pm.replayReplace(); err != nil {
+			file.Close()
+			return nil, build.ExtendErr("failed to replay replacement", err)
+		}
+		pm.startDefrag()
+		pm.startZeroPool()
+		return pm, nil
+	} else if !os.IsNotExist(err) {
+		// The file exists but cannot be opened
+		return nil, build.ExtendErr("Failed to open existing database file", err)
+	}
+
+	// If the file doesn't exist create a new one
+	file, err = openBackingFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, opts)
+	if err != nil {
+		return nil, build.ExtendErr("Failed to create the database file: %v", err)
+	}
+	pm.file = file
+
+	// Create the pageEntry for the free pages.
+	root, err := newPageTable(0, nil, pm)
+	if err != nil {
+		file.Close()
+		return nil, build.ExtendErr("Failed to create pageTable for recycling page", err)
+	}
+	rp := &recyclingPage{
+		&tieredPage{
+			pm:   pm,
+			root: root,
+			mu:   new(sync.RWMutex),
+			pp: &physicalPage{
+				file:     pm.file,
+				fileOff:  freeOff,
+				usedSize: pageSize,
+				strict:   pm.opts.StrictMode,
+			},
+		},
+		nil,
+	}
+
+	// Persist the root right away. Otherwise it is lost if the free pages
+	// are only kept in memory until the PageManager is closed
+	if err := writeTieredPageEntry(rp.pp, 0, 0, root.pp.fileOff); err != nil {
+		file.Close()
+		return nil, build.ExtendErr("Failed to write recycling page", err)
+	}
+	pm.freePages = rp
+
+	// Create the first page of the directory
+	pm.directory = make(map[Identifier]int64)
+	if err := pm.extendDirectory(); err != nil {
+		file.Close()
+		return nil, build.ExtendErr("Failed to create directory", err)
+	}
+
+	// Preallocate the file
+	if err := pm.preallocate(opts.InitialSize); err != nil {
+		file.Close()
+		return nil, build.ExtendErr("Failed to preallocate file", err)
+	}
+	pm.startDefrag()
+	pm.startZeroPool()
+
+	return pm, nil
+}
+
+// preallocate grows the file to size bytes and adds the added pages to the
+// free pages. The pageTables that are needed to track the free pages are
+// allocated at the end of the file which is why the number of added pages is
+// chosen to leave room for them
+func (p *PageManager) preallocate(size int64) error {
+	for {
+		end, err := p.file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if end >= size {
+			return nil
+		}
+
+		// Find the number of pages that fit into the remaining space together
+		// with their pageTables
+		remaining := uint64(size-end) / pageSize
+		numPages := remaining
+		used := p.freePages.nextIndex()
+		for numPages > 1 && numPages+numTables(used+numPages)-numTables(used) > remaining {
+			numPages--
+		}
+
+		// Grow the file and add the pages
+		if err := p.file.Truncate(end + int64(numPages)*pageSize); err != nil {
+			return build.ExtendErr("failed to grow file", err)
+		}
+		pages := make([]*physicalPage, 0, numPages)
+		for off := end; off < end+int64(numPages)*pageSize; off += pageSize {
+			pages = append(pages, &physicalPage{
+				file:    p.file,
+				fileOff: off,
+				strict:  p.opts.StrictMode,
+			})
+		}
+		if err := p.freePages.addPages(pages); err != nil {
+			return build.ExtendErr("failed to add preallocated pages", err)
+		}
+	}
+}
+
+// numTables returns the number of pageTables a tree needs to contain numPages
+// pages
+func numTables(numPages uint64) uint64 {
+	tables := (numPages + numPageEntries - 1) / numPageEntries
+	if tables == 0 {
+		tables = 1
+	}
+	total := tables
+	for tables > 1 {
+		tables = (tables + numPageEntries - 1) / numPageEntries
+		total += tables
+	}
+	return total
+}
+
+// startDefrag starts the background thread that releases free pages if a
+// DefragInterval was specified
+func (p *PageManager) startDefrag() {
+	if p.opts.DefragInterval <= 0 {
+		return
+	}
+	p.stopChan = make(chan struct{})
+	p.wg = new(sync.WaitGroup)
+	p.wg.Add(1)
+	go p.threadedDefrag(p.opts.Clock.NewTicker(p.opts.DefragInterval))
+}
+
+// Delete removes an entry and frees all of its pages. Open handles of the
+// deleted entry become stale and return ErrStaleHandle
+func (p *PageManager) Delete(id Identifier) error {
+	// Get the entryPage, unregister the entry and remove it from the map to
+	// prevent new handles from being opened
+	p.mu.Lock()
+	ep, exists := p.entryPages[id]
+	if !exists {
+		var err error
+		ep, err = p.loadEntryPage(id, false)
+		if err != nil {
+			p.mu.Unlock()
+			return build.ExtendErr("Failed to load entryPage", err)
+		}
+	}
+	if err := p.unregisterEntry(id); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	delete(p.entryPages, id)
+	p.removeIdleEntry(id)
+	p.mu.Unlock()
+
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	// Invalidate the open handles of the entry and clear the marker to not
+	// mistake the page for an entryPage once it is reused
+	ep.generation = 0
+	if err := writeEntryMarker(ep.pp, false); err != nil {
+		return build.ExtendErr("Failed to clear entry marker", err)
+	}
+
+	// Make sure the whole tree is loaded to free all of its pages
+	if err := ep.loadTree(); err != nil {
+		return build.ExtendErr("Failed to load tree", err)
+	}
+
+	// Free the data pages and pageTables of the entry
+	_, pagesToFree1, err := ep.recursiveTruncate(ep.root, 0, nil)
+	if err != nil {
+		return err
+	}
+	pagesToFree2, err := ep.defrag()
+	if err != nil {
+		return err
+	}
+
+	// Free the remaining root and the entryPage itself
+	pagesToFree := append(pagesToFree1, pagesToFree2...)
+	pagesToFree = append(pagesToFree, ep.root.pp, ep.pp)
+	return p.managedAddFreePages(pagesToFree)
+}
+
+// Exists returns true if id is the Identifier of an entry. It only checks the
+// directory of the live entries without reading anything from disk
+func (p *PageManager) Exists(id Identifier) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exists(id)
+}
+
+// MaxEntrySize returns the maximum size of an entry in bytes. It is the number
+// of bytes the tallest tree whose root fits into the slots of an entryPage can
+// address, limited by the int64 sizes and offsets of the entries
+func (p *PageManager) MaxEntrySize() int64 {
+	size := math.Pow(numPageEntries, numTreeSlots) * pageSize
+	if size >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(size)
+}
+
+// exists is a helper function for Exists. The p.mu lock needs to be acquired
+func (p *PageManager) exists(id Identifier) bool {
+	_, exists := p.directory[id]
+	return exists
+}
+
+// loadEntryPage loads the entryPage with the specified identifier from disk
+// and recovers its tree. If lazy is true, only the root of the tree is
+// recovered and the remaining pageTables are loaded on demand. The p.mu lock
+// needs to be acquired
+func (p *PageManager) loadEntryPage(id Identifier, lazy bool) (*entryPage, error) {
+	// Don't decode pages that can't be entryPages
+	if !p.exists(id) {
+		return nil, ErrNotFound
+	}
+	ep, err := p.readEntryPage(id, lazy)
+	if err != nil {
+		return nil, err
+	}
+	if ep.root == nil {
+		if err := p.createReservedRoot(ep); err != nil {
+			return nil, err
+		}
+	}
+	return ep, nil
+}
+
+// peekEntryPage is the same as loadEntryPage but doesn't create the root of a
+// reserved entry. It is used by scans that must not allocate pages. The p.mu
+// lock needs to be acquired
+func (p *PageManager) peekEntryPage(id Identifier, lazy bool) (*entryPage, error) {
+	if !p.exists(id) {
+		return nil, ErrNotFound
+	}
+	return p.readEntryPage(id, lazy)
+}
+
+// createReservedRoot creates the root of a reserved entry that is loaded for
+// the first time. The p.mu lock needs to be acquired
+func (p *PageManager) createReservedRoot(ep *entryPage) (err error) {
+	ep.root, err = newPageTable(0, nil, p)
+	if err != nil {
+		return build.ExtendErr("Couldn't create new pageTable", err)
+	}
+	return writeTieredPageEntry(ep.pp, 0, 0, ep.root.pp.fileOff)
+}
+
+// readEntryPage reads the entryPage with the specified identifier from disk
+// and recovers its tree like loadEntryPage. It doesn't allocate any pages
+// which is why it can be called without holding the p.mu lock. The root of a
+// reserved entry is nil and needs to be created with createReservedRoot
+func (p *PageManager) readEntryPage(id Identifier, lazy bool) (*entryPage, error) {
+	// Create the physicalPage object using the identifier. We don't know
+	// usedSize yet but for the entryPage we can just set it to pageSize
+	pp := &physicalPage{
+		file:     p.file,
+		fileOff:  int64(id),
+		usedSize: pageSize,
+		strict:   p.opts.StrictMode,
+	}
+
+	// Read the generation of the entry first. Create writes it after the
+	// tree which is why a page that is reused by a new entry while it is
+	// read either results in a generation that is outdated afterwards or in
+	// the tree of the new entry
+	generation, err := readGeneration(pp)
+	if err != nil {
+		return nil, build.ExtendErr("Failed to read generation", err)
+	}
+
+	// Read all the entries from the entryPage and remember the root and usedSize
+	rootOff := int64(0)
+	usedSize := int64(0)
+	height := int64(0)
+	for i := 0; i < numTreeSlots; i++ {
+		usedSize, rootOff, err = readEntryPageEntry(pp, int64(i))
+		if err != nil {
+			return nil, build.ExtendErr("Failed to read entry", err)
+		}
+
+		// Remember the reached height
+		height = int64(i)
+
+		// Stop if we find a root that isn't full yet
+		numPages := int64(math.Pow(float64(numPageEntries), float64(i+1)))
+		if usedSize < numPages*pageSize {
+			break
+		}
+	}
+
+	// Create the entryPage object and recover the tree.
+	ep := newEntryPage(&tieredPage{
+		pp:       pp,
+		usedSize: usedSize,
+		pm:       p,
+		mu:       new(sync.RWMutex),
+	}, generation)
+
+	// Load the maximum size and the modification time
+	ep.maxSize, err = readMaxSize(pp, generation)
+	if err != nil {
+		return nil, build.ExtendErr("Failed to read maximum size", err)
+	}
+	ep.modTime, err = readModTime(pp, generation)
+	if err != nil {
+		return nil, build.ExtendErr("Failed to read modification time", err)
+	}
+
+	// Load the cached content hash
+	if p.opts.CacheContentHash {
+		ep.hash, err = readContentHash(pp, generation)
+		if err != nil {
+			return nil, build.ExtendErr("Failed to read content hash", err)
+		}
+	}
+
+	// Reserved entries don't have a root yet
+	if rootOff == 0 {
+		return ep, nil
+	}
+
+	// If the tree is loaded lazily we only create the unloaded root and
+	// remember the number of pages
+	if lazy {
+		stat, err := p.file.Stat()
+		if err != nil {
+			return nil, build.ExtendErr("failed to get size of file", err)
+		}
+		if rootOff+pageSize > stat.Size() {
+			return nil, ErrTruncatedFile
+		}
+		ep.root = &pageTable{
+			pp: &physicalPage{
+				file:     p.file,
+				fileOff:  rootOff,
+				usedSize: pageSize,
+				strict:   p.opts.StrictMode,
+			},
+			height:      height,
+			childTables: make(map[uint64]*pageTable),
+			childPages:  make(map[uint64]*physicalPage),
+			unloaded:    true,
+		}
+		ep.partial = true
+		ep.lazyMu = new(sync.Mutex)
+		if p.opts.MaxLoadedTables > 0 {
+			// Walk the tree on demand instead of materializing the pages
+			ep.loadedTables = list.New()
+			ep.loadedElems = make(map[*pageTable]*list.Element)
+			return ep, nil
+		}
+		ep.pages = make([]*physicalPage, ep.nextIndex())
+		return ep, nil
 	}
 
 	// Recover the tree to get the pages of the entry
-	if err := ep.recoverTree(rootOff, height); err != nil {
+	if err := ep.recoverTree(rootOff, height); err == ErrTruncatedFile {
+		return nil, err
+	} else if err != nil {
 		return nil, build.ExtendErr("Failed to recover tree", err)
 	}
+	p.opts.Logger.Debug("recovered entry %v with %v pages", id, len(ep.pages))
+	return ep, nil
+}
+
+// Open loads a previously created entry. ErrNotFound is returned if id
+// doesn't belong to an entry
+func (p *PageManager) Open(id Identifier) (*Entry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.open(id)
+}
+
+// OpenExclusive loads a previously created entry like Open. The returned
+// handle is the only one that can modify the entry until it is closed while
+// other handles can still read from it. ErrLocked is returned if another
+// exclusive handle is open
+func (p *PageManager) OpenExclusive(id Identifier) (*Entry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, err := p.open(id)
+	if err != nil {
+		return nil, err
+	}
+	if !atomic.CompareAndSwapUint32(&e.ep.atomicExclusive, 0, 1) {
+		e.close()
+		return nil, ErrLocked
+	}
+	e.exclusive = true
+	return e, nil
+}
+
+// open is a helper function for Open and OpenExclusive. The p.mu lock needs
+// to be acquired. It is released while an entryPage that isn't cached is read
+// from disk to not block other operations during the recovery of its tree
+func (p *PageManager) open(id Identifier) (*Entry, error) {
+	// Check if the identifier was opened before
+	if ep, exists := p.entryPages[id]; exists {
+		// Increase the instance counter of the entryPage
+		p.cacheHits++
+		p.removeIdleEntry(id)
+		ep.instanceCounter++
+		return &Entry{
+			pm:         p,
+			ep:         ep,
+			generation: ep.generation,
+		}, nil
+	}
+
+	// Read the entryPage from disk without holding the lock. The lock is
+	// reacquired even if a failed sanity check panics
+	if !p.exists(id) {
+		return nil, ErrNotFound
+	}
+	p.cacheMisses++
+	ep, err := func() (*entryPage, error) {
+		p.mu.Unlock()
+		defer p.mu.Lock()
+		return p.readEntryPage(id, p.opts.LazyOpen)
+	}()
+	if err != nil && !p.exists(id) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Another thread might have loaded the entryPage in the meantime or
+	// the entry might have been deleted
+	if cached, exists := p.entryPages[id]; exists {
+		p.removeIdleEntry(id)
+		cached.instanceCounter++
+		return &Entry{
+			pm:         p,
+			ep:         cached,
+			generation: cached.generation,
+		}, nil
+	}
+	if !p.exists(id) {
+		return nil, ErrNotFound
+	}
+
+	// The entry might have been deleted and its entryPage reused by a new
+	// entry while it was read. Then the read entryPage might be torn and the
+	// generation on disk differs from the one that was read
+	generation, err := readGeneration(ep.pp)
+	if err != nil {
+		return nil, err
+	}
+	live, err := readEntryMarker(ep.pp)
+	if err != nil {
+		return nil, err
+	}
+	if generation != ep.generation || !live {
+		return nil, ErrNotFound
+	}
+	if ep.root == nil {
+		if err := p.createReservedRoot(ep); err != nil {
+			return nil, err
+		}
+	}
 
 	// Create the entry
 	newEntry := &Entry{
-		pm: p,
-		ep: ep,
+		pm:         p,
+		ep:         ep,
+		generation: ep.generation,
 	}
 
 	// Increment the entryPage's counter and add it to the map
 	p.entryPages[id] = ep
 	ep.instanceCounter++
+	p.evictIdleEntries()
 
 	return newEntry, nil
 }
+
+// openEntries returns the number of entries with open handles. The p.mu lock
+// needs to be acquired
+func (p *PageManager) openEntries() int {
+	n := 0
+	for _, ep := range p.entryPages {
+		if ep.instanceCounter > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// addIdleEntry marks a cached entryPage as idle after its last handle was
+// closed and evicts the least recently used idle entryPages if there are too
+// many cached entryPages. The p.mu lock needs to be acquired
+func (p *PageManager) addIdleEntry(id Identifier) {
+	p.idleElems[id] = p.idleEntries.PushFront(id)
+	p.evictIdleEntries()
+}
+
+// removeIdleEntry removes an entryPage from the idle entryPages. The p.mu lock
+// needs to be acquired
+func (p *PageManager) removeIdleEntry(id Identifier) {
+	if elem, idle := p.idleElems[id]; idle {
+		p.idleEntries.Remove(elem)
+		delete(p.idleElems, id)
+	}
+}
+
+// ResizeCache changes the maximum number of cached entryPages to n while the
+// PageManager is in use. Idle entries that exceed the new limit are evicted
+// right away
+func (p *PageManager) ResizeCache(n int) error {
+	if n < 0 {
+		return fmt.Errorf("cache size %v is negative", n)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.opts.MaxCachedEntries = n
+	p.evictIdleEntries()
+	return nil
+}
+
+// evictIdleEntries evicts the least recently used idle entryPages until there
+// are at most MaxCachedEntries cached entryPages or no idle ones are left. The
+// p.mu lock needs to be acquired
+func (p *PageManager) evictIdleEntries() {
+	for len(p.entryPages) > p.opts.MaxCachedEntries && p.idleEntries.Len() > 0 {
+		id := p.idleEntries.Remove(p.idleEntries.Back()).(Identifier)
+		delete(p.idleElems, id)
+		delete(p.entryPages, id)
+	}
+}
+
+// ForEachEntry opens the entries with the specified Identifiers one after
+// another and calls fn with each of them. Every entry is closed after fn
+// returns. The iteration stops at the first error which is returned
+func (p *PageManager) ForEachEntry(ids []Identifier, fn func(id Identifier, e *Entry) error) error {
+	for _, id := range ids {
+		entry, err := p.Open(id)
+		if err != nil {
+			return build.ExtendErr(fmt.Sprintf("failed to open entry %v", id), err)
+		}
+		err = fn(id, entry)
+		entry.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EntriesSorted returns the Identifiers of the open entries in ascending
+// order. Entries that aren't open are not included
+func (p *PageManager) EntriesSorted() []Identifier {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]Identifier, 0, len(p.entryPages))
+	for id, ep := range p.entryPages {
+		if ep.instanceCounter > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// LevelInfo describes a slot of an entryPage. Every slot belongs to a certain
+// height of the entry's tree
+type LevelInfo struct {
+	// Height is the height of the tree the slot belongs to
+	Height int64
+
+	// UsedBytes is the number of bytes stored in the tree of that height
+	UsedBytes int64
+
+	// PageOff is the offset of the root pageTable of the tree of that height
+	PageOff int64
+}
+
+// EntryLevels returns the contents of all the slots of an entry's entryPage
+func (p *PageManager) EntryLevels(id Identifier) ([]LevelInfo, error) {
+	// Prevent the entry from being modified while the slots are read if it
+	// is open
+	p.mu.Lock()
+	ep, exists := p.entryPages[id]
+	p.mu.Unlock()
+	if exists {
+		ep.mu.RLock()
+		defer ep.mu.RUnlock()
+	}
+
+	pp := &physicalPage{
+		file:     p.file,
+		fileOff:  int64(id),
+		usedSize: pageSize,
+		strict:   p.opts.StrictMode,
+	}
+	levels := make([]LevelInfo, 0, numTreeSlots)
+	for i := int64(0); i < numTreeSlots; i++ {
+		usedBytes, pageOff, err := readEntryPageEntry(pp, i)
+		if err != nil {
+			return nil, build.ExtendErr("Failed to read entry", err)
+		}
+		levels = append(levels, LevelInfo{
+			Height:    i,
+			UsedBytes: usedBytes,
+			PageOff:   pageOff,
+		})
+	}
+	return levels, nil
+}
@@ -2,10 +2,18 @@ package pages
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/fastrand"
@@ -21,6 +29,89 @@ func (pt pagingTester) Close() error {
 	return pt.pm.Close()
 }
 
+// fakeClock is a Clock that only advances when Advance is called
+type fakeClock struct {
+	now    time.Time
+	ticker *fakeTicker
+}
+
+// fakeTicker is the Ticker of a fakeClock
+type fakeTicker struct {
+	c chan time.Time
+}
+
+// NewTicker returns the fakeTicker of the fakeClock. d is ignored
+func (fc *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fc.ticker
+}
+
+// Now returns the current time of the fakeClock
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+// Advance moves the fakeClock forward by d and delivers a tick. It blocks
+// until the tick is received
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.now = fc.now.Add(d)
+	fc.ticker.c <- fc.now
+}
+
+// C returns the channel of the fakeTicker
+func (ft *fakeTicker) C() <-chan time.Time {
+	return ft.c
+}
+
+// Stop is a no-op
+func (ft *fakeTicker) Stop() {}
+
+// newFakeClock creates a new fakeClock
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:    time.Unix(0, 0),
+		ticker: &fakeTicker{c: make(chan time.Time)},
+	}
+}
+
+// logEvent is an event that was logged by a recordingLogger
+type logEvent struct {
+	level string
+	msg   string
+}
+
+// recordingLogger is a Logger that records the logged events
+type recordingLogger struct {
+	events []logEvent
+	mu     sync.Mutex
+}
+
+// record adds an event with a certain level to the recorded events
+func (rl *recordingLogger) record(level, format string, v ...interface{}) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.events = append(rl.events, logEvent{level, fmt.Sprintf(format, v...)})
+}
+
+// Debug records a debug event
+func (rl *recordingLogger) Debug(format string, v ...interface{}) {
+	rl.record("debug", format, v...)
+}
+
+// Info records an info event
+func (rl *recordingLogger) Info(format string, v ...interface{}) {
+	rl.record("info", format, v...)
+}
+
+// Warn records a warning
+func (rl *recordingLogger) Warn(format string, v ...interface{}) {
+	rl.record("warn", format, v...)
+}
+
+// Error records an error
+func (rl *recordingLogger) Error(format string, v ...interface{}) {
+	rl.record("error", format, v...)
+}
+
 // totalPages is a helper function that returns the number of pages in a tree of
 // pageTables
 func totalPages(pt *pageTable) uint64 {
@@ -79,16 +170,18 @@ func TestAllocatePage(t *testing.T) {
 		t.Fatalf("Failed to get file stats: %v", err)
 	}
 
-	// Check filesize afterwards
-	if stats.Size() != int64(numPages*pageSize+dataOff+pageSize) {
-		t.Errorf("Filesize should be %v, but was %v", numPages*pageSize+dataOff, stats.Size())
+	// Check filesize afterwards. The root of the free pages and the first
+	// page of the directory precede the allocated pages
+	metaSize := 2 * pageSize
+	if stats.Size() != int64(numPages*pageSize+dataOff+metaSize) {
+		t.Errorf("Filesize should be %v, but was %v", numPages*pageSize+dataOff+metaSize, stats.Size())
 	}
 
 	// Check if fields were set correctly
 	for i := 0; i < numPages; i++ {
-		if pages[i].fileOff != int64(i*pageSize+dataOff+pageSize) {
+		if pages[i].fileOff != int64(i*pageSize+dataOff+metaSize) {
 			t.Fatalf("Page %v has wrong offset. Was %v, but should be %v",
-				i, pages[i].fileOff, i*pageSize+dataOff+pageSize)
+				i, pages[i].fileOff, i*pageSize+dataOff+metaSize)
 		}
 	}
 }
@@ -256,3 +349,2616 @@ func TestInstanceCounter(t *testing.T) {
 		t.Errorf("length of entryPages should be 0 but was %v", pt.pm.entryPages)
 	}
 }
+
+// TestStaleHandle tests if a handle to a deleted entry returns ErrStaleHandle
+// even if the entry's offset is reused by a new entry
+func TestStaleHandle(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry and write some data to it
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatalf("Failed to write data: %v", err)
+	}
+
+	// Delete the entry
+	if err := pt.pm.Delete(id); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+
+	// Create a new entry. It should reuse the freed entryPage
+	entry2, id2, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id2 != id {
+		t.Fatalf("New entry should reuse offset %v but was %v", id, id2)
+	}
+	if entry2.generation == entry.generation {
+		t.Errorf("New entry should have a different generation than %v", entry.generation)
+	}
+	data := fastrand.Bytes(pageSize)
+	if _, err := entry2.Write(data); err != nil {
+		t.Fatalf("Failed to write data: %v", err)
+	}
+
+	// The old handle should be stale
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != ErrStaleHandle {
+		t.Errorf("ReadAt should fail with %v but was %v", ErrStaleHandle, err)
+	}
+	if _, err := entry.WriteAt(readData, 0); err != ErrStaleHandle {
+		t.Errorf("WriteAt should fail with %v but was %v", ErrStaleHandle, err)
+	}
+	if err := entry.Truncate(0); err != ErrStaleHandle {
+		t.Errorf("Truncate should fail with %v but was %v", ErrStaleHandle, err)
+	}
+
+	// Closing the stale handle shouldn't affect the new entry
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := pt.pm.entryPages[id]; !exists {
+		t.Error("Closing the stale handle removed the new entry from the map")
+	}
+
+	// The new handle should still work
+	if _, err := entry2.ReadAt(readData, 0); err != nil {
+		t.Fatalf("Failed to read data: %v", err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+
+	// Reopening the new entry should recover its generation
+	if err := entry2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry3, err := pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry3.generation != entry2.generation {
+		t.Errorf("Recovered generation should be %v but was %v", entry2.generation, entry3.generation)
+	}
+}
+
+// TestLazyOpen tests if opening an entry lazily defers loading the tree until
+// it is needed
+func TestLazyOpen(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry with a tree of height 1 and 3 leaf tables
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	numPages := int(2*numPageEntries + 5)
+	data := fastrand.Bytes(numPages*pageSize - 10)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Open the entry lazily and get its size
+	pt.pm.opts.LazyOpen = true
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := entry.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("Size should be %v but was %v", len(data), size)
+	}
+
+	// The tree shouldn't be materialized
+	if !entry.ep.root.unloaded || len(entry.ep.root.childTables) != 0 {
+		t.Error("The root of the tree shouldn't be loaded")
+	}
+	if len(entry.ep.pages) != numPages {
+		t.Fatalf("Entry should have %v pages but had %v", numPages, len(entry.ep.pages))
+	}
+	for i, page := range entry.ep.pages {
+		if page != nil {
+			t.Fatalf("Page %v shouldn't be loaded", i)
+		}
+	}
+
+	// Read from the middle of the last leaf. Only that leaf's pages should be
+	// loaded
+	offset := int64(2*numPageEntries*pageSize + 100)
+	readData := make([]byte, 2*pageSize)
+	if _, err := entry.ReadAt(readData, offset); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data[offset:offset+int64(len(readData))], readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+	loaded := 0
+	for _, page := range entry.ep.pages {
+		if page != nil {
+			loaded++
+		}
+	}
+	if loaded != 5 {
+		t.Errorf("%v pages should be loaded but were %v", 5, loaded)
+	}
+	if len(entry.ep.root.childTables) != 3 || !entry.ep.root.childTables[0].unloaded {
+		t.Error("Only the last leaf table should be loaded")
+	}
+
+	// The last page needs to have the right usedSize
+	if entry.ep.pages[numPages-1].usedSize != pageSize-10 {
+		t.Errorf("usedSize of last page should be %v but was %v",
+			pageSize-10, entry.ep.pages[numPages-1].usedSize)
+	}
+
+	// Writing loads the whole tree
+	if _, err := entry.WriteAt(readData, offset); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ep.partial {
+		t.Error("Tree should be fully loaded after writing")
+	}
+	readData = make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// TestMaxLoadedTables tests if a huge entry that was opened lazily can be
+// read randomly while only a limited number of pageTables is loaded
+func TestMaxLoadedTables(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	pt.pm.opts.SparseWrites = true
+
+	// Create a huge sparse entry and write some of its pages
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	numPages := int64(1000000)
+	written := make(map[int64][]byte)
+	for i := 0; i < 20; i++ {
+		index := int64(fastrand.Intn(int(numPages)))
+		written[index] = fastrand.Bytes(pageSize)
+		if _, err := entry.WriteAt(written[index], index*pageSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := entry.Truncate(numPages * pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Open the entry lazily with a limited number of loaded pageTables
+	maxTables := 8
+	pt.pm.opts.LazyOpen = true
+	pt.pm.opts.MaxLoadedTables = maxTables
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+
+	// Count the pageTables that are loaded
+	var loaded func(pt *pageTable) int
+	loaded = func(pt *pageTable) int {
+		if pt.unloaded {
+			return 0
+		}
+		n := 1
+		for _, child := range pt.childTables {
+			n += loaded(child)
+		}
+		return n
+	}
+
+	// Read random pages and the written ones
+	var indices []int64
+	for index := range written {
+		indices = append(indices, index)
+	}
+	for i := 0; i < 200; i++ {
+		indices = append(indices, int64(fastrand.Intn(int(numPages))))
+	}
+	readData := make([]byte, pageSize)
+	for _, index := range indices {
+		if _, err := entry.ReadAt(readData, index*pageSize); err != nil {
+			t.Fatal(err)
+		}
+		expected, exists := written[index]
+		if !exists {
+			expected = make([]byte, pageSize)
+		}
+		if !bytes.Equal(readData, expected) {
+			t.Fatalf("Read data of page %v doesn't match written data", index)
+		}
+		if len(entry.ep.pages) != 0 {
+			t.Fatalf("Pages shouldn't be materialized but there were %v", len(entry.ep.pages))
+		}
+		if n := entry.ep.loadedTables.Len(); n > maxTables {
+			t.Fatalf("At most %v pageTables should be loaded but there were %v", maxTables, n)
+		}
+		if n := loaded(entry.ep.root); n > maxTables {
+			t.Fatalf("At most %v pageTables should be in the tree but there were %v", maxTables, n)
+		}
+	}
+
+	// Seeking to the end works without materializing the pages
+	if off, err := entry.Seek(0, io.SeekEnd); err != nil || off != numPages*pageSize {
+		t.Fatalf("Seek should return %v but returned %v %v", numPages*pageSize, off, err)
+	}
+}
+
+// TestNewClosesFileOnError tests if New closes the file it opened if the
+// recovery fails
+func TestNewClosesFileOnError(t *testing.T) {
+	// Counting the open descriptors requires procfs
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("procfs not available")
+	}
+
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the file by referencing a transaction log that doesn't exist.
+	// A corrupt tree of free pages isn't fatal
+	if err := writeTxnLog(pt.pm.freePages.pp, Identifier(1000*pageSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	path := pt.pm.file.(*os.File).Name()
+	if err := pt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Count the open file descriptors before and after the failed recovery
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(path); err == nil {
+		t.Fatal("Recovering the corrupted file should fail")
+	}
+	fdsAfter, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fdsAfter) != len(fds) {
+		t.Errorf("%v file descriptors were leaked", len(fdsAfter)-len(fds))
+	}
+}
+
+// TestDefragThread tests if the background thread releases the free pages at the
+// end of the file once per tick
+func TestDefragThread(t *testing.T) {
+	// Create temp dir
+	testdir := build.TempDir("paging", t.Name())
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testdir, "data.dat")
+
+	// Create a PageManager with a fake clock
+	fc := newFakeClock()
+	opts := DefaultOptions()
+	opts.DefragInterval = time.Minute
+	opts.Clock = fc
+	pm, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write 10 pages and free them again
+	entry, id, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Truncate(pageSize); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizeBefore := stat.Size()
+
+	// Advance the clock twice and close the PageManager to wait for the
+	// thread to finish
+	fc.Advance(time.Minute)
+	fc.Advance(time.Minute)
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if pm.defragRuns != 2 {
+		t.Errorf("Defrag should have run %v times but ran %v times", 2, pm.defragRuns)
+	}
+
+	// The 9 freed pages should be released
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != sizeBefore-9*pageSize {
+		t.Errorf("File size should be %v but was %v", sizeBefore-9*pageSize, fi.Size())
+	}
+
+	// The PageManager should still be recoverable
+	pm, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	entry, err = pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Check(); err != nil {
+		t.Error(err)
+	}
+	if pm.freePages.availablePages() != 0 {
+		t.Errorf("There should be no free pages but there were %v", pm.freePages.availablePages())
+	}
+}
+
+// TestReleaseSpace tests if ReleaseSpace shrinks the file by the released
+// bytes after entries at the end of the file are deleted
+func TestReleaseSpace(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create some entries with 5 pages each
+	var ids []Identifier
+	for i := 0; i < 3; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write(fastrand.Bytes(5 * pageSize)); err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// Nothing is released while the last page is in use
+	reclaimed, err := pt.pm.ReleaseSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed != 0 {
+		t.Fatalf("No bytes should be released but %v were", reclaimed)
+	}
+
+	// Delete the last two entries. Their pages should be released
+	stat, err := pt.pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[1:] {
+		if err := pt.pm.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reclaimed, err = pt.pm.ReleaseSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat2, err := pt.pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed != stat.Size()-stat2.Size() {
+		t.Fatalf("%v bytes should be released but ReleaseSpace returned %v", stat.Size()-stat2.Size(), reclaimed)
+	}
+	if reclaimed < 2*5*pageSize {
+		t.Fatalf("At least the data pages of the deleted entries should be released but only %v bytes were", reclaimed)
+	}
+
+	// The remaining entry should be intact and the file recoverable
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pt.pm, err = New(pt.pm.file.(*os.File).Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := pt.pm.Open(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Check(); err != nil {
+		t.Error(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReserve tests if a reserved Identifier can be opened and written to
+func TestReserve(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Reserve an Identifier. Only the entryPage should be allocated
+	stat, err := pt.pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := pt.pm.Reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat2, err := pt.pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat2.Size()-stat.Size() != pageSize {
+		t.Errorf("Reserve should allocate %v bytes but allocated %v", pageSize, stat2.Size()-stat.Size())
+	}
+
+	// Open the reserved entry. It should be empty
+	entry, err := pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := entry.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 0 {
+		t.Errorf("Size should be %v but was %v", 0, size)
+	}
+
+	// Write to the entry and reopen it
+	data := fastrand.Bytes(pageSize + 100)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// TestStrictMode tests if a corrupted pageTable causes a panic in strict mode
+// and an error otherwise
+func TestStrictMode(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the number of entries of the root pageTable
+	numEntries := make([]byte, 8)
+	binary.LittleEndian.PutUint64(numEntries, numPageEntries+1)
+	if _, err := pt.pm.file.WriteAt(numEntries, entry.ep.root.pp.fileOff); err != nil {
+		t.Fatal(err)
+	}
+
+	// In strict mode opening the entry should panic
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("Opening the corrupted entry should panic in strict mode")
+			}
+		}()
+		pt.pm.opts.StrictMode = true
+		pt.pm.Open(id)
+	}()
+
+	// Otherwise it should return an error
+	pt.pm.opts.StrictMode = false
+	if _, err := pt.pm.Open(id); err == nil {
+		t.Error("Opening the corrupted entry should fail")
+	}
+}
+
+// TestEntryLevels tests if EntryLevels reports the slots of a multi-level
+// entry
+func TestEntryLevels(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry with a tree of height 1
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64((numPageEntries+1)*pageSize + 10)
+	if _, err := entry.Write(fastrand.Bytes(int(size))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Compare the levels to the tree
+	levels, err := pt.pm.EntryLevels(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != numTreeSlots {
+		t.Fatalf("There should be %v levels but there were %v", numTreeSlots, len(levels))
+	}
+	expected := []LevelInfo{
+		{0, int64(maxPages(0) * pageSize), entry.ep.root.childTables[0].pp.fileOff},
+		{1, size, entry.ep.root.pp.fileOff},
+	}
+	for i, level := range levels {
+		if i < len(expected) && level != expected[i] {
+			t.Errorf("Level %v should be %v but was %v", i, expected[i], level)
+		}
+		if i >= len(expected) && (level.UsedBytes != 0 || level.PageOff != 0) {
+			t.Errorf("Level %v should be empty but was %v", i, level)
+		}
+		if level.Height != int64(i) {
+			t.Errorf("Height of level %v should be %v but was %v", i, i, level.Height)
+		}
+	}
+}
+
+// TestDisableRecycling tests if freed pages are not reused if recycling is
+// disabled
+func TestDisableRecycling(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	pt.pm.opts.DisableRecycling = true
+
+	// Write some pages and free them again
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	var maxOff int64
+	for _, page := range entry.ep.pages {
+		if page.fileOff > maxOff {
+			maxOff = page.fileOff
+		}
+	}
+	if err := entry.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The freed pages should still be recorded
+	if pt.pm.freePages.availablePages() != 10 {
+		t.Errorf("There should be %v free pages but there were %v", 10, pt.pm.freePages.availablePages())
+	}
+
+	// Writing again should allocate new pages with increasing offsets
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range entry.ep.pages {
+		if page.fileOff <= maxOff {
+			t.Fatalf("Page at %v should have been allocated after %v", page.fileOff, maxOff)
+		}
+		maxOff = page.fileOff
+	}
+	if pt.pm.freePages.availablePages() != 10 {
+		t.Errorf("There should be %v free pages but there were %v", 10, pt.pm.freePages.availablePages())
+	}
+}
+
+// TestDeleteConcurrent tests if an entry that is being deleted can neither be
+// opened nor deleted a second time which would free its pages twice
+func TestDeleteConcurrent(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// noDuplicates makes sure that no page was freed twice
+	noDuplicates := func() {
+		pt.pm.mu.Lock()
+		defer pt.pm.mu.Unlock()
+		seen := make(map[int64]struct{})
+		pages := append(append([]*physicalPage(nil), pt.pm.freePages.pages...), pt.pm.freePages.pagesToFree...)
+		for _, page := range pages {
+			if _, exists := seen[page.fileOff]; exists {
+				t.Fatalf("Page at %v was freed twice", page.fileOff)
+			}
+			seen[page.fileOff] = struct{}{}
+		}
+	}
+
+	// Create an entry and block its deletion before its pages are freed by
+	// holding the lock of the entryPage. The entry stays open to keep the
+	// entryPage cached
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(3 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	ep := entry.ep
+	ep.mu.RLock()
+	errChan := make(chan error)
+	go func() {
+		errChan <- pt.pm.Delete(id)
+	}()
+	for start := time.Now(); pt.pm.Exists(id); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Entry wasn't unregistered")
+		}
+	}
+
+	// The entry can't be opened or deleted while its deletion is pending
+	if _, err := pt.pm.Open(id); err != ErrNotFound {
+		t.Errorf("Open should fail with %v but was %v", ErrNotFound, err)
+	}
+	if err := pt.pm.Delete(id); err == nil || !strings.Contains(err.Error(), ErrNotFound.Error()) {
+		t.Errorf("Delete should fail with %v but was %v", ErrNotFound, err)
+	}
+	ep.mu.RUnlock()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	noDuplicates()
+
+	// Delete an entry from multiple threads at once. Only one of them
+	// succeeds
+	entry, id, err = pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(3 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	numThreads := 8
+	errs := make(chan error, numThreads)
+	var wg sync.WaitGroup
+	for i := 0; i < numThreads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- pt.pm.Delete(id)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else if !strings.Contains(err.Error(), ErrNotFound.Error()) {
+			t.Errorf("Delete should fail with %v but was %v", ErrNotFound, err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("Exactly one Delete should succeed but %v did", succeeded)
+	}
+	noDuplicates()
+}
+
+// TestOpenNotFound tests if opening invalid identifiers returns ErrNotFound
+func TestOpenNotFound(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry and a deleted entry
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, deletedID, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.Delete(deletedID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening invalid identifiers should fail
+	invalidIDs := []Identifier{freeOff, -pageSize, id + 1, 1000 * pageSize, deletedID}
+	for _, invalidID := range invalidIDs {
+		if pt.pm.Exists(invalidID) {
+			t.Errorf("Identifier %v shouldn't exist", invalidID)
+		}
+		if _, err := pt.pm.Open(invalidID); err != ErrNotFound {
+			t.Errorf("Opening %v should return %v but was %v", invalidID, ErrNotFound, err)
+		}
+	}
+
+	// Opening the valid identifier should work
+	if !pt.pm.Exists(id) {
+		t.Error("Identifier should exist")
+	}
+	if _, err := pt.pm.Open(id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOpenWhileReused tests if an entry that is deleted while Open reads its
+// entryPage isn't opened when the entryPage is reused by a new entry in the
+// meantime
+func TestOpenWhileReused(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry that isn't cached after it was closed
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(2 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Block Open after it read the generation of the entry
+	gf := &gatedFile{
+		backingFile: pt.pm.file,
+		off:         int64(id),
+		armed:       1,
+		reached:     make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+	pt.pm.file = gf
+	type result struct {
+		entry *Entry
+		err   error
+	}
+	results := make(chan result)
+	go func() {
+		entry, err := pt.pm.Open(id)
+		results <- result{entry, err}
+	}()
+	<-gf.reached
+
+	// Delete the entry and create a new one that reuses the entryPage
+	if err := pt.pm.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	entry, newID, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newID != id {
+		t.Fatalf("New entry should reuse offset %v but was %v", id, newID)
+	}
+	generation := entry.generation
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The deleted entry can't be opened anymore and the new entry is opened
+	// with its own generation
+	close(gf.release)
+	if r := <-results; r.err != ErrNotFound {
+		if r.entry != nil {
+			r.entry.Close()
+		}
+		t.Fatalf("Open should fail with %v but was %v", ErrNotFound, r.err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	if entry.generation != generation {
+		t.Errorf("Entry should have generation %v but had %v", generation, entry.generation)
+	}
+}
+
+// TestOpenNotEntryPage tests if the Identifiers of the data pages and
+// pageTables of an entry don't pass as entries. Decoding them would fail a
+// sanity check. Data that looks like an entryPage doesn't pass either
+func TestOpenNotEntryPage(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry with enough pages for a pageTable below the root
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(int(numPageEntries+2) * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Forge the marker of an entryPage on a data page
+	forged := entry.ep.pages[2].fileOff
+	marker := make([]byte, 8)
+	binary.LittleEndian.PutUint64(marker, entryMarker^uint64(forged))
+	if _, err := entry.WriteAt(marker, 2*pageSize+entryMarkerOff); err != nil {
+		t.Fatal(err)
+	}
+	invalidIDs := []Identifier{
+		Identifier(entry.ep.pages[1].fileOff),
+		Identifier(forged),
+		Identifier(entry.ep.root.pp.fileOff),
+		Identifier(entry.ep.root.childTables[0].pp.fileOff),
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pages shouldn't be decoded as entryPages
+	for _, invalidID := range invalidIDs {
+		if pt.pm.Exists(invalidID) {
+			t.Errorf("Identifier %v shouldn't exist", invalidID)
+		}
+		if _, err := pt.pm.Open(invalidID); err != ErrNotFound {
+			t.Errorf("Opening %v should return %v but was %v", invalidID, ErrNotFound, err)
+		}
+	}
+}
+
+// TestDirectory tests if the directory of the live entries spans multiple
+// pages and survives reopening the PageManager
+func TestDirectory(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := pt.pm.file.(*os.File).Name()
+
+	// Create more entries than fit on a single page of the directory and
+	// delete every third one
+	var ids, deleted []Identifier
+	for i := 0; i < dirSlotsPerPage+10; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 != 0 {
+			ids = append(ids, id)
+		} else {
+			deleted = append(deleted, id)
+		}
+	}
+	for _, id := range deleted {
+		if err := pt.pm.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pt.pm.dirPages) != 2 {
+		t.Fatalf("The directory should have %v pages but had %v", 2, len(pt.pm.dirPages))
+	}
+
+	// Reopen the PageManager. The directory should still contain the live
+	// entries
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if len(pm.directory) != len(ids) {
+		t.Errorf("The directory should contain %v entries but contained %v", len(ids), len(pm.directory))
+	}
+	for _, id := range ids {
+		if !pm.Exists(id) {
+			t.Errorf("Entry %v should exist", id)
+		}
+	}
+	for _, id := range deleted {
+		if pm.Exists(id) {
+			t.Errorf("Deleted entry %v shouldn't exist", id)
+		}
+	}
+
+	// The pages of the directory are neither orphaned nor free
+	if problems := pm.Verify(ids); len(problems) != 0 {
+		t.Errorf("Expected no problems but got %v", problems)
+	}
+	orphans, err := pm.FindOrphans(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("Expected no orphans but got %v", orphans)
+	}
+
+	// New entries reuse the slots of deleted ones
+	for range deleted {
+		if _, err := pm.Reserve(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pm.dirPages) != 2 {
+		t.Errorf("The directory should have %v pages but had %v", 2, len(pm.dirPages))
+	}
+}
+
+// TestOpenExclusive tests if only a single exclusive handle can be opened and
+// if shared handles can still read from the entry
+func TestOpenExclusive(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Open it exclusively and write some data
+	exclusive, err := pt.pm.OpenExclusive(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(100)
+	if _, err := exclusive.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second exclusive open should fail
+	if _, err := pt.pm.OpenExclusive(id); err != ErrLocked {
+		t.Fatalf("OpenExclusive should fail with %v but was %v", ErrLocked, err)
+	}
+
+	// A shared handle can read but not write
+	shared, err := pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := shared.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Error("Read data doesn't match written data")
+	}
+	if _, err := shared.WriteAt(data, 0); err != ErrLocked {
+		t.Errorf("Write should fail with %v but was %v", ErrLocked, err)
+	}
+
+	// After closing the exclusive handle the entry can be written and opened
+	// exclusively again
+	if err := exclusive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shared.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pt.pm.OpenExclusive(id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCheckpoint tests if the file is synced after CheckpointBytes were
+// written and if the data of the last checkpoint survives a crash
+func TestCheckpoint(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	path := pt.pm.file.(*os.File).Name()
+	cf := &crashFile{backingFile: pt.pm.file}
+	pt.pm.file = cf
+	pt.pm.opts.CheckpointBytes = 4 * pageSize
+
+	// Write 10 pages one at a time. The file should be synced after 4 and
+	// 8 pages
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(10 * pageSize)
+	for i := 0; i < 10; i++ {
+		if _, err := entry.Write(data[i*pageSize : (i+1)*pageSize]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cf.synced == nil {
+		t.Fatal("File should have been synced")
+	}
+
+	// Simulate a crash by recovering the contents of the file at the last
+	// sync
+	crashedPath := path + ".crashed"
+	if err := ioutil.WriteFile(crashedPath, cf.synced, 0600); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(crashedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	entry, err = pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The data of the first 8 pages should have survived
+	size, err := entry.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 8*pageSize {
+		t.Fatalf("Entry should have a size of %v but was %v", 8*pageSize, size)
+	}
+	readData := make([]byte, size)
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data[:size]) {
+		t.Error("Read data doesn't match written data")
+	}
+	if err := entry.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestForEachEntry tests if ForEachEntry visits every entry, closes them and
+// stops at the first error
+func TestForEachEntry(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create entries of different sizes
+	var ids []Identifier
+	var total int64
+	for i := 0; i < 5; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := i*pageSize + i
+		if _, err := entry.Write(fastrand.Bytes(size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		total += int64(size)
+	}
+
+	// Sum up the sizes of the entries
+	var sum int64
+	err = pt.pm.ForEachEntry(ids, func(id Identifier, e *Entry) error {
+		size, err := e.Size()
+		sum += size
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != total {
+		t.Errorf("Sum of the sizes should be %v but was %v", total, sum)
+	}
+
+	// The iteration stops at the first error and all entries are closed
+	errStop := errors.New("stop")
+	visited := 0
+	err = pt.pm.ForEachEntry(ids, func(id Identifier, e *Entry) error {
+		visited++
+		if visited == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("Error should be %v but was %v", errStop, err)
+	}
+	if visited != 2 {
+		t.Errorf("%v entries should have been visited but %v were", 2, visited)
+	}
+	if n := pt.pm.openEntries(); n != 0 {
+		t.Errorf("All entries should be closed but %v were open", n)
+	}
+}
+
+// TestEntriesSorted tests if EntriesSorted returns the open entries in
+// ascending order
+func TestEntriesSorted(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create some entries and close one of them
+	var ids []Identifier
+	for i := 0; i < 10; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 5 {
+			if err := entry.Close(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	// The output should be sorted and stable
+	for i := 0; i < 10; i++ {
+		sorted := pt.pm.EntriesSorted()
+		if len(sorted) != len(ids) {
+			t.Fatalf("There should be %v entries but there were %v", len(ids), len(sorted))
+		}
+		for j := range sorted {
+			if sorted[j] != ids[j] {
+				t.Fatalf("Entry %v should be %v but was %v", j, ids[j], sorted[j])
+			}
+		}
+	}
+}
+
+// TestAllocAlignment tests if pages allocated at the end of the file are
+// aligned and if the gaps are added to the free pages
+func TestAllocAlignment(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	align := int64(4 * pageSize)
+	pt.pm.opts.AllocAlignment = align
+
+	// Allocate some pages. All of them should be aligned
+	var pages []*physicalPage
+	for i := 0; i < 5; i++ {
+		pp, err := pt.pm.managedAllocatePage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pp.fileOff%align != 0 {
+			t.Errorf("Page at %v isn't aligned to %v", pp.fileOff, align)
+		}
+		pages = append(pages, pp)
+	}
+
+	// The gaps should have been set aside for metadata
+	if len(pt.pm.gapPages) == 0 {
+		t.Error("Gaps should have been set aside")
+	}
+	for _, page := range pt.pm.gapPages {
+		if page.fileOff%align == 0 {
+			t.Errorf("Aligned page at %v shouldn't be a gap", page.fileOff)
+		}
+	}
+
+	// Create an entry. Its metadata fills the gaps while its data pages are
+	// aligned
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ep.pp.fileOff%align == 0 {
+		t.Errorf("entryPage at %v should have been taken from the gaps", entry.ep.pp.fileOff)
+	}
+	if _, err := entry.Write(fastrand.Bytes(3 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range entry.ep.pages {
+		if page.fileOff%align != 0 {
+			t.Errorf("Data page at %v isn't aligned to %v", page.fileOff, align)
+		}
+	}
+
+	// Free the aligned pages and an unaligned page. The recycled pages
+	// should still be aligned
+	pt.pm.mu.Lock()
+	if len(pt.pm.gapPages) == 0 {
+		t.Fatal("There should be gaps left")
+	}
+	unaligned := pt.pm.gapPages[0]
+	pt.pm.gapPages = pt.pm.gapPages[1:]
+	pt.pm.mu.Unlock()
+	if err := pt.pm.managedAddFreePages(append(pages, unaligned)); err != nil {
+		t.Fatal(err)
+	}
+	pages = pages[:0]
+	for i := 0; i < 6; i++ {
+		pp, err := pt.pm.managedAllocatePage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pp.fileOff%align != 0 {
+			t.Errorf("Recycled page at %v isn't aligned to %v", pp.fileOff, align)
+		}
+		pages = append(pages, pp)
+	}
+
+	// Extents are aligned too
+	extent, err := pt.pm.managedAllocateExtent(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extent[0].fileOff%align != 0 {
+		t.Errorf("Extent at %v isn't aligned to %v", extent[0].fileOff, align)
+	}
+
+	// None of the pages are leaked
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.managedAddFreePages(append(pages, extent...)); err != nil {
+		t.Fatal(err)
+	}
+	orphans, err := pt.pm.FindOrphans([]Identifier{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("Expected no orphans but got %v", orphans)
+	}
+
+	// An invalid alignment should be rejected
+	opts := DefaultOptions()
+	opts.AllocAlignment = pageSize + 1
+	if _, err := NewWithOptions(pt.pm.file.(*os.File).Name(), opts); err == nil {
+		t.Error("Creating a PageManager with an invalid alignment should fail")
+	}
+}
+
+// TestReopen tests if Reopen reads the changes of a different PageManager
+// from disk
+func TestReopen(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	path := pt.pm.file.(*os.File).Name()
+
+	// Create an entry with the first PageManager
+	entry, firstID, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create an entry with a second PageManager that uses the same file
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, id, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(2 * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening with open entries should fail
+	entry, err = pt.pm.Open(firstID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.Reopen(); err == nil {
+		t.Fatal("Reopen should fail while entries are open")
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the first PageManager and read the entry
+	if err := pt.pm.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if pt.pm.generation != pm.generation {
+		t.Errorf("generation should be %v but was %v", pm.generation, pt.pm.generation)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// TestLazyFreeList tests if free pages are only persisted on Close if
+// LazyFreeList is enabled and if lost free pages can be rebuilt after a crash
+func TestLazyFreeList(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := pt.pm.file.(*os.File).Name()
+	pt.pm.opts.LazyFreeList = true
+
+	// Write some data to an entry and truncate it
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(10 * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(20 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Truncate(int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	freePages := pt.pm.freePages.availablePages()
+	if freePages == 0 || len(pt.pm.freePages.pages) != 0 {
+		t.Fatal("Free pages should only be kept in memory")
+	}
+	if !pt.pm.FreeListDirty() {
+		t.Fatal("Free pages should be dirty")
+	}
+
+	// Simulate a crash by copying the file
+	crashedPath := path + ".crashed"
+	fileData, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(crashedPath, fileData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the PageManager should persist the free pages
+	if err := pt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pm.FreeListDirty() {
+		t.Error("Free pages shouldn't be dirty after Close")
+	}
+	if pm.freePages.availablePages() != freePages {
+		t.Errorf("There should be %v free pages but there were %v", freePages, pm.freePages.availablePages())
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The free pages of the crashed file should be rebuilt
+	pm, err = New(crashedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if !pm.FreeListDirty() {
+		t.Fatal("Free pages should be dirty after a crash")
+	}
+	if pm.freePages.availablePages() != 0 {
+		t.Fatalf("There should be %v free pages but there were %v", 0, pm.freePages.availablePages())
+	}
+	if err := pm.RebuildFreeList([]Identifier{id}); err != nil {
+		t.Fatal(err)
+	}
+	if pm.FreeListDirty() {
+		t.Error("Free pages shouldn't be dirty after rebuilding them")
+	}
+	if !pm.WasRepaired() {
+		t.Error("Rebuilding the free pages should be reported as a repair")
+	}
+	if pm.freePages.availablePages() != freePages {
+		t.Errorf("There should be %v free pages but there were %v", freePages, pm.freePages.availablePages())
+	}
+
+	// Reusing the free pages shouldn't corrupt the entry
+	entry2, _, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry2.Write(fastrand.Bytes(30 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// TestCorruptFreePages tests if a PageManager whose free pages can't be
+// recovered is still opened with readable entries and if the free pages can
+// be rebuilt afterwards
+func TestCorruptFreePages(t *testing.T) {
+	// Create temp dir
+	testdir := build.TempDir("paging", t.Name())
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testdir, "data.dat")
+	opts := DefaultOptions()
+	opts.StrictMode = false
+	pm, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create an entry and free the pages of another one
+	entry, id, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(5 * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, otherID, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Delete(otherID); err != nil {
+		t.Fatal(err)
+	}
+	freePages := pm.freePages.availablePages()
+	rootOff := pm.freePages.root.pp.fileOff
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the root of the free pages
+	file, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(bytes.Repeat([]byte{0xff}, pageSize), rootOff); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The PageManager should start without free pages
+	pm, err = NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if pm.freePages.availablePages() != 0 {
+		t.Errorf("There should be %v free pages but there were %v", 0, pm.freePages.availablePages())
+	}
+	if !pm.FreeListDirty() || !pm.WasRepaired() {
+		t.Error("Free pages should be dirty and repaired")
+	}
+
+	// The entry should still be readable
+	entry, err = pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Error("Read data doesn't match written data")
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rebuilding the free pages reclaims the free pages and the pageTables
+	// of the corrupt tree
+	if err := pm.RebuildFreeList([]Identifier{id}); err != nil {
+		t.Fatal(err)
+	}
+	if pm.FreeListDirty() {
+		t.Error("Free pages shouldn't be dirty after rebuilding them")
+	}
+	if pm.freePages.availablePages() <= freePages {
+		t.Errorf("There should be more than %v free pages but there were %v", freePages, pm.freePages.availablePages())
+	}
+	orphans, err := pm.FindOrphans([]Identifier{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("There should be no orphaned pages but there were %v", len(orphans))
+	}
+}
+
+// TestWasRepaired tests if WasRepaired reports that the free pages were
+// repaired when they are loaded from a torn file
+func TestWasRepaired(t *testing.T) {
+	tests := []struct {
+		name     string
+		delta    int64
+		repaired bool
+	}{
+		{"clean", 0, false},
+		{"tornPages", -2 * pageSize, true},
+		{"clampedSize", 2 * pageSize, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pt, err := newPagingTester(t.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := pt.pm.file.(*os.File).Name()
+
+			// Free some pages
+			entry, id, err := pt.pm.Create()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := fastrand.Bytes(pageSize)
+			if _, err := entry.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+				t.Fatal(err)
+			}
+			if err := entry.Truncate(int64(len(data))); err != nil {
+				t.Fatal(err)
+			}
+			freePages := pt.pm.freePages.availablePages()
+			if err := pt.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Change the usedSize of the free pages on disk
+			file, err := os.OpenFile(path, os.O_RDWR, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pp := &physicalPage{file: file, fileOff: freeOff, usedSize: pageSize}
+			usedSize, rootOff, err := readEntryPageEntry(pp, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writeTieredPageEntry(pp, 0, usedSize+test.delta, rootOff); err != nil {
+				t.Fatal(err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Load the PageManager and check if it was repaired
+			pm, err := New(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pm.WasRepaired() != test.repaired {
+				t.Fatalf("WasRepaired should be %v", test.repaired)
+			}
+			expected := freePages
+			if test.delta < 0 {
+				expected += int(test.delta / pageSize)
+			}
+			if pm.freePages.availablePages() != expected {
+				t.Fatalf("There should be %v free pages but there were %v", expected, pm.freePages.availablePages())
+			}
+
+			// The repaired free pages should be usable
+			entry, err = pm.Open(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := entry.WriteAt(fastrand.Bytes(20*pageSize), int64(len(data))); err != nil {
+				t.Fatal(err)
+			}
+			if err := entry.Truncate(int64(len(data))); err != nil {
+				t.Fatal(err)
+			}
+			readData := make([]byte, len(data))
+			if _, err := entry.ReadAt(readData, 0); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(readData, data) {
+				t.Fatal("Read data doesn't match written data")
+			}
+
+			// Loading the free pages again shouldn't require a repair
+			freePages = pm.freePages.availablePages()
+			if err := pm.Close(); err != nil {
+				t.Fatal(err)
+			}
+			pm, err = New(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pm.Close()
+			if pm.WasRepaired() {
+				t.Fatal("WasRepaired should be false")
+			}
+			if pm.freePages.availablePages() != freePages {
+				t.Fatalf("There should be %v free pages but there were %v", freePages, pm.freePages.availablePages())
+			}
+		})
+	}
+}
+
+// TestFindOrphans tests if FindOrphans reports pages that were allocated but
+// never referenced by an entry
+func TestFindOrphans(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry and free some of its pages
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Truncate(5 * pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// There shouldn't be any orphans yet
+	orphans, err := pt.pm.FindOrphans([]Identifier{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Fatalf("There shouldn't be orphans but there were %v", orphans)
+	}
+
+	// Simulate a crash after allocating a page that was never added to an
+	// entry
+	page, err := pt.pm.managedAllocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphans, err = pt.pm.FindOrphans([]Identifier{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0] != page.fileOff {
+		t.Fatalf("The orphans should be [%v] but were %v", page.fileOff, orphans)
+	}
+
+	// Rebuilding the free pages should reclaim the orphan
+	if err := pt.pm.RebuildFreeList([]Identifier{id}); err != nil {
+		t.Fatal(err)
+	}
+	orphans, err = pt.pm.FindOrphans([]Identifier{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Fatalf("There shouldn't be orphans but there were %v", orphans)
+	}
+}
+
+// TestInitialSize tests if a new file is preallocated and if the preallocated
+// pages are added to the free pages
+func TestInitialSize(t *testing.T) {
+	testdir := build.TempDir("paging", t.Name())
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testdir, "data.dat")
+
+	// Create a PageManager with an initial size of 10MB
+	size := int64(10 * 1 << 20)
+	opts := DefaultOptions()
+	opts.InitialSize = size
+	pm, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	// The file should have the initial size and all the pages except for the
+	// pageTables of the free pages and the directory should be free
+	stat, err := pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != size {
+		t.Fatalf("File should have %v bytes but had %v", size, stat.Size())
+	}
+	numPages := uint64((size - dataOff) / pageSize)
+	expected := numPages - numTables(uint64(pm.freePages.availablePages())) - uint64(len(pm.dirPages))
+	if uint64(pm.freePages.availablePages()) != expected {
+		t.Fatalf("There should be %v free pages but there were %v", expected, pm.freePages.availablePages())
+	}
+	orphans, err := pm.FindOrphans(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Fatalf("There shouldn't be orphans but there were %v", orphans)
+	}
+
+	// Writing to an entry should reuse the preallocated pages
+	entry, _, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(100 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	stat, err = pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != size {
+		t.Fatalf("File should have %v bytes but had %v", size, stat.Size())
+	}
+
+	// An invalid initial size should be rejected
+	opts.InitialSize = pageSize + 1
+	if _, err := NewWithOptions(filepath.Join(testdir, "invalid.dat"), opts); err == nil {
+		t.Error("Creating a PageManager with an invalid initial size should fail")
+	}
+}
+
+// TestFork tests if modifying a fork of a PageManager doesn't affect the
+// original
+func TestFork(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	path := pt.pm.file.(*os.File).Name()
+
+	// Write some data
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(5*pageSize + 100)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fork the PageManager
+	fork, err := pt.pm.Fork(path + ".fork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fork.Close()
+
+	// The fork should contain the data
+	forkEntry, err := fork.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := forkEntry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Fatal("Read data doesn't match written data")
+	}
+
+	// Modify the fork
+	if _, err := forkEntry.WriteAt(fastrand.Bytes(pageSize), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := forkEntry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fork.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// The original should be unchanged
+	size, err := entry.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) {
+		t.Fatalf("Entry should have %v bytes but had %v", len(data), size)
+	}
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Fatal("Original was modified by the fork")
+	}
+
+	// Forking to an existing file should fail
+	if _, err := pt.pm.Fork(path); err == nil {
+		t.Error("Forking to an existing file should fail")
+	}
+}
+
+// TestCompactFreeList tests if sequential writes reuse contiguous pages after
+// the free pages were compacted
+func TestCompactFreeList(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create many small entries and delete them in random order to scatter
+	// the free pages
+	var ids []Identifier
+	for i := 0; i < 50; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write(fastrand.Bytes(4 * pageSize)); err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	for _, i := range fastrand.Perm(len(ids)) {
+		if err := pt.pm.Delete(ids[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Compact the free pages and write a large entry
+	if err := pt.pm.CompactFreeList(); err != nil {
+		t.Fatal(err)
+	}
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(200 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pages of the entry should be contiguous
+	contiguous := 0
+	for i := 1; i < len(entry.ep.pages); i++ {
+		if entry.ep.pages[i].fileOff == entry.ep.pages[i-1].fileOff+pageSize {
+			contiguous++
+		}
+	}
+	if contiguous < len(entry.ep.pages)-2 {
+		t.Fatalf("Only %v of %v pages are contiguous", contiguous, len(entry.ep.pages)-1)
+	}
+}
+
+// TestSeparateMetadata tests if the data pages of an entry are contiguous if
+// the metadata is stored separately
+func TestSeparateMetadata(t *testing.T) {
+	testdir := build.TempDir("paging", t.Name())
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testdir, "data.dat")
+	opts := DefaultOptions()
+	opts.SeparateMetadata = true
+	pm, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write an entry that needs multiple pageTables
+	entry, id, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(2000 * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// The data pages should be contiguous
+	for i := 1; i < len(entry.ep.pages); i++ {
+		if entry.ep.pages[i].fileOff != entry.ep.pages[i-1].fileOff+pageSize {
+			t.Fatalf("Page %v at %v doesn't follow the page at %v", i, entry.ep.pages[i].fileOff, entry.ep.pages[i-1].fileOff)
+		}
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the PageManager should free the unused metadata pages
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err = NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	orphans, err := pm.FindOrphans([]Identifier{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Fatalf("There shouldn't be orphans but there were %v", len(orphans))
+	}
+	entry, err = pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Fatal("Read data doesn't match written data")
+	}
+}
+
+// TestResizeCache tests if the cache can be resized while entries are read
+// and if the PageManager can be recovered with a different cache size
+func TestResizeCache(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := pt.pm.file.(*os.File).Name()
+	pt.pm.opts.MaxCachedEntries = 4
+
+	// Create 10 entries
+	var ids []Identifier
+	var datas [][]byte
+	for i := 0; i < 10; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := fastrand.Bytes(pageSize + i)
+		if _, err := entry.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		datas = append(datas, data)
+	}
+
+	// readEntries opens and reads the entries in random order
+	readEntries := func(pm *PageManager) error {
+		for _, i := range fastrand.Perm(len(ids)) {
+			entry, err := pm.Open(ids[i])
+			if err != nil {
+				return err
+			}
+			readData := make([]byte, len(datas[i]))
+			_, err = entry.ReadAt(readData, 0)
+			entry.Close()
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(readData, datas[i]) {
+				return fmt.Errorf("data of entry %v doesn't match", ids[i])
+			}
+		}
+		return nil
+	}
+
+	// Read the entries concurrently while the cache is resized
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := readEntries(pt.pm); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	for _, n := range []int{0, 20, 2, 10, 1} {
+		if err := pt.pm.ResizeCache(n); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// Without open entries the cache should respect the last size
+	if len(pt.pm.entryPages) > 1 {
+		t.Errorf("There should be at most %v cached entries but there were %v", 1, len(pt.pm.entryPages))
+	}
+	if err := readEntries(pt.pm); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.ResizeCache(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := readEntries(pt.pm); err != nil {
+		t.Fatal(err)
+	}
+	if len(pt.pm.entryPages) != 10 {
+		t.Errorf("There should be %v cached entries but there were %v", 10, len(pt.pm.entryPages))
+	}
+	if err := pt.pm.ResizeCache(-1); err == nil {
+		t.Error("Resizing the cache to a negative size should fail")
+	}
+
+	// Recover the PageManager with a different cache size
+	if err := pt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.MaxCachedEntries = 3
+	pm, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if err := readEntries(pm); err != nil {
+		t.Fatal(err)
+	}
+	if len(pm.entryPages) != 3 {
+		t.Errorf("There should be %v cached entries but there were %v", 3, len(pm.entryPages))
+	}
+}
+
+// TestMaxCachedEntries tests if idle entries are cached until the limit is
+// exceeded and if the least recently used ones are evicted
+func TestMaxCachedEntries(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	pt.pm.opts.MaxCachedEntries = 2
+
+	// Create 5 entries and keep the first one open
+	var ids []Identifier
+	var datas [][]byte
+	var open *Entry
+	for i := 0; i < 5; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := fastrand.Bytes(pageSize + i)
+		if _, err := entry.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			open = entry
+		} else if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		datas = append(datas, data)
+	}
+
+	// Only the open entry and the most recently used idle entry should be
+	// cached
+	if len(pt.pm.entryPages) != 2 {
+		t.Fatalf("There should be %v cached entries but there were %v", 2, len(pt.pm.entryPages))
+	}
+	for _, id := range []Identifier{ids[0], ids[4]} {
+		if _, exists := pt.pm.entryPages[id]; !exists {
+			t.Errorf("Entry %v should be cached", id)
+		}
+	}
+
+	// Opening an idle entry should reuse the cached entryPage
+	ep := pt.pm.entryPages[ids[4]]
+	entry, err := pt.pm.Open(ids[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ep != ep {
+		t.Error("Cached entryPage wasn't reused")
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Evicted entries should be recovered from disk
+	for i, id := range ids[1:4] {
+		entry, err := pt.pm.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readData := make([]byte, len(datas[i+1]))
+		if _, err := entry.ReadAt(readData, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(readData, datas[i+1]) {
+			t.Error("Read data doesn't match written data")
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pt.pm.entryPages) != 2 {
+		t.Fatalf("There should be %v cached entries but there were %v", 2, len(pt.pm.entryPages))
+	}
+
+	// Closed entries shouldn't be reported as open even if they are cached
+	if err := open.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(pt.pm.EntriesSorted()) != 0 {
+		t.Fatal("There shouldn't be open entries")
+	}
+}
+
+// TestMaxEntrySize tests if MaxEntrySize returns the size at which writes are
+// rejected with ErrEntryTooLarge
+func TestMaxEntrySize(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// The tallest tree addresses more bytes than an int64 can represent
+	maxSize := pt.pm.MaxEntrySize()
+	if math.Pow(numPageEntries, numTreeSlots)*pageSize < math.MaxInt64 {
+		t.Fatal("The tallest tree should address more than math.MaxInt64 bytes")
+	}
+	if maxSize != math.MaxInt64 {
+		t.Errorf("MaxEntrySize should be %v but was %v", int64(math.MaxInt64), maxSize)
+	}
+
+	// Writes that end beyond the maximum size are rejected
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	if _, err := entry.WriteAt([]byte{1, 2}, maxSize-1); err != ErrEntryTooLarge {
+		t.Errorf("error should be %v but was %v", ErrEntryTooLarge, err)
+	}
+	if _, err := entry.WriteAt([]byte{1}, maxSize); err != ErrEntryTooLarge {
+		t.Errorf("error should be %v but was %v", ErrEntryTooLarge, err)
+	}
+	if size, err := entry.Size(); err != nil || size != 0 {
+		t.Errorf("Rejected writes shouldn't change the entry but size was %v %v", size, err)
+	}
+
+	// Writes within the maximum size still work
+	if _, err := entry.WriteAt([]byte{1}, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLogger tests if the recovery of a PageManager is logged through the
+// Logger of the Options
+func TestLogger(t *testing.T) {
+	// Create temp dir
+	testdir := build.TempDir("paging", t.Name())
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testdir, "data.dat")
+
+	// Creating a new file doesn't recover anything
+	rl := new(recordingLogger)
+	opts := DefaultOptions()
+	opts.Logger = rl
+	pm, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.events) != 0 {
+		t.Errorf("Creating a file shouldn't log events but logged %v", rl.events)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recovering the file logs an info event
+	pm, err = NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	found := false
+	for _, event := range rl.events {
+		if event.level == "info" && strings.Contains(event.msg, "recovered") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Recovery should log an info event but logged %v", rl.events)
+	}
+
+	// The default Logger discards the events
+	pm2, err := New(path + ".default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm2.Close()
+	if _, ok := pm2.opts.Logger.(noopLogger); !ok {
+		t.Error("The default Logger should be a noopLogger")
+	}
+}
+
+// BenchmarkCreateWhileOpening benchmarks creating entries while another thread
+// keeps opening an entry whose tree needs to be recovered. The recovery
+// shouldn't block the creates
+func BenchmarkCreateWhileOpening(b *testing.B) {
+	pt, err := newPagingTester(b.Name())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create a large entry. It isn't cached after it was closed which is
+	// why every Open recovers its tree
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := entry.Truncate(20000 * pageSize); err != nil {
+		b.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	// Keep opening the large entry in the background
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			entry, err := pt.pm.Open(id)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			entry.Close()
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			entry, _, err := pt.pm.Create()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			entry.Close()
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+}
+
+// TestPinEntry tests if a pinned entry stays cached while the cache is
+// thrashed by other entries
+func TestPinEntry(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	pt.pm.opts.MaxCachedEntries = 2
+
+	// Create an entry, pin it and close it
+	pinned, pinnedID, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pinned.Write(fastrand.Bytes(2 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	pinned.Pin()
+	ep := pinned.ep
+	if err := pinned.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Thrash the cache with other entries
+	var ids []Identifier
+	for i := 0; i < 10; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		entry, err := pt.pm.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The pinned entry should still be cached
+	hits, misses := pt.pm.cacheHits, pt.pm.cacheMisses
+	entry, err := pt.pm.Open(pinnedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt.pm.cacheHits != hits+1 || pt.pm.cacheMisses != misses {
+		t.Errorf("Opening the pinned entry should be a cache hit: %v hits, %v misses", pt.pm.cacheHits-hits, pt.pm.cacheMisses-misses)
+	}
+	if entry.ep != ep || entry.ep.root != ep.root {
+		t.Error("The pageTables of the pinned entry should have been reused")
+	}
+
+	// Once it is unpinned, it can be evicted
+	if err := entry.Unpin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Unpin(); err == nil {
+		t.Error("Unpinning an unpinned entry should fail")
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		entry, err := pt.pm.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hits, misses = pt.pm.cacheHits, pt.pm.cacheMisses
+	entry, err = pt.pm.Open(pinnedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	if pt.pm.cacheHits != hits || pt.pm.cacheMisses != misses+1 {
+		t.Errorf("Opening the unpinned entry should be a cache miss: %v hits, %v misses", pt.pm.cacheHits-hits, pt.pm.cacheMisses-misses)
+	}
+}
+
+// TestOpenTruncatedFile tests if opening an entry whose pages were cut off by
+// truncating the file behind the PageManager's back returns ErrTruncatedFile
+func TestOpenTruncatedFile(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := pt.pm.file.(*os.File).Name()
+
+	// Create an entry with 3 pages at the end of the file
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(3 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut off half of the last page
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, stat.Size()-pageSize/2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the entry should return a descriptive error
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if _, err := pm.Open(id); err != ErrTruncatedFile {
+		t.Fatalf("Open should return %v but was %v", ErrTruncatedFile, err)
+	}
+}
+
+// TestRepairSizes tests if RepairSizes fixes an entry whose recorded size
+// exceeds the capacity of its pages
+func TestRepairSizes(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create two entries with 3 pages each
+	var ids []Identifier
+	var entries []*Entry
+	for i := 0; i < 2; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write(fastrand.Bytes(3 * pageSize)); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		entries = append(entries, entry)
+	}
+
+	// Record a size of 5 pages for the first entry
+	ep := entries[0].ep
+	if err := writeTieredPageEntry(ep.pp, ep.root.height, 5*pageSize, ep.root.pp.fileOff); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries can't be repaired while they are open
+	if _, err := pt.pm.RepairSizes(ids); err == nil {
+		t.Fatal("Repairing open entries should fail")
+	}
+	for _, entry := range entries {
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the first entry should be repaired
+	repaired, err := pt.pm.RepairSizes(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired != 1 {
+		t.Fatalf("1 entry should have been repaired but %v were", repaired)
+	}
+	for _, id := range ids {
+		entry, err := pt.pm.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size, err := entry.Size(); err != nil || size != 3*pageSize {
+			t.Errorf("Entry should have %v bytes but had %v %v", 3*pageSize, size, err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Repairing again shouldn't change anything
+	if repaired, err := pt.pm.RepairSizes(ids); err != nil || repaired != 0 {
+		t.Errorf("No entries should have been repaired but %v were %v", repaired, err)
+	}
+}
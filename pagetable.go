@@ -2,6 +2,7 @@ package pages
 
 import (
 	"encoding/binary"
+	"fmt"
 
 	"github.com/NebulousLabs/Sia/build"
 )
@@ -26,15 +27,19 @@ type (
 
 		// pp is the physical page on which the pageTable is stored
 		pp *physicalPage
+
+		// unloaded indicates that the children of the pageTable weren't read
+		// from disk yet
+		unloaded bool
 	}
 )
 
 // newPageTable is a helper function to create a pageTable
 func newPageTable(height int64, parent *pageTable, pm *PageManager) (*pageTable, error) {
 	// Allocate a page for the table
-	pp, err := pm.allocatePage()
+	pp, err := pm.allocateMetaPage()
 	if err != nil {
-		return nil, build.ExtendErr("failed to allocate page for new pageTable", err)
+		return nil, extendErr("failed to allocate page for new pageTable", err)
 	}
 
 	// Create and return the table
@@ -60,7 +65,7 @@ func extendPageTableTree(root *pageTable, pm *PageManager) (*pageTable, error) {
 	// Create a new root pageTable
 	newRoot, err := newPageTable(root.height+1, nil, pm)
 	if err != nil {
-		return nil, build.ExtendErr("Failed to create new pageTable to extend the tree", err)
+		return nil, extendErr("Failed to create new pageTable to extend the tree", err)
 	}
 
 	// Set the previous root pageTable to be the child of the new one
@@ -78,7 +83,12 @@ func (pt pageTable) marshal() ([]byte, error) {
 	if pt.height == 0 {
 		numEntries = uint64(len(pt.childPages))
 		for i := uint64(0); i < numEntries; i++ {
-			offsets = append(offsets, pt.childPages[uint64(i)].fileOff)
+			// Holes are marked with an offset of 0
+			var offset int64
+			if page := pt.childPages[uint64(i)]; page != nil {
+				offset = page.fileOff
+			}
+			offsets = append(offsets, offset)
 		}
 	} else {
 		numEntries = uint64(len(pt.childTables))
@@ -106,8 +116,22 @@ func (pt pageTable) marshal() ([]byte, error) {
 	return data, nil
 }
 
+// checkChildren returns an error if the pageTable points to pageTables and
+// pages at the same time
+func (pt pageTable) checkChildren() error {
+	if len(pt.childTables) > 0 && len(pt.childPages) > 0 {
+		return fmt.Errorf("pageTable at %v points to %v pageTables and %v pages",
+			pt.pp.fileOff, len(pt.childTables), len(pt.childPages))
+	}
+	return nil
+}
+
 // writeToDisk marshals a pageTable and writes it to disk
 func (pt pageTable) writeToDisk() error {
+	if err := pt.checkChildren(); err != nil {
+		return err
+	}
+
 	// Marshal the pageTable
 	data, err := pt.marshal()
 	if err != nil {
@@ -124,8 +148,7 @@ func (pt pageTable) writeToDisk() error {
 
 // Size returns the length of the pageTable if it was marshalled
 func (pt pageTable) Size() uint32 {
-	// 4 Bytes for the tableType
-	// 4 Bytes for the pageOffsts length
+	// 8 Bytes for the number of children
 	// 8 * children bytes for the elements
 	var children uint32
 	if pt.height == 0 {
@@ -133,5 +156,5 @@ func (pt pageTable) Size() uint32 {
 	} else {
 		children = uint32(len(pt.childTables))
 	}
-	return 4 + 4 + 8*children
+	return 8 + 8*children
 }
@@ -37,7 +37,7 @@ func TestMarshalLoad(t *testing.T) {
 	}
 
 	// Unmarshal the data and compare
-	entries, err := unmarshalPageTable(data)
+	entries, err := unmarshalPageTable(data, true)
 	if err != nil {
 		t.Errorf("Failed to unmarshal pageTable: %v", err)
 	}
@@ -52,3 +52,76 @@ func TestMarshalLoad(t *testing.T) {
 		}
 	}
 }
+
+// TestPageTableSize tests if Size returns the length of the marshalled
+// pageTable
+func TestPageTableSize(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	for _, numChildren := range []int{0, 1, 10, int(numPageEntries)} {
+		// Create tables with numChildren pages and tables
+		leaf, err := newPageTable(0, nil, pt.pm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		table, err := newPageTable(1, nil, pt.pm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < numChildren; i++ {
+			leaf.childPages[uint64(i)] = &physicalPage{fileOff: int64(i) * pageSize}
+			table.childTables[uint64(i)] = &pageTable{pp: &physicalPage{fileOff: int64(i) * pageSize}}
+		}
+
+		// Compare the size to the length of the marshalled tables
+		for _, p := range []*pageTable{leaf, table} {
+			data, err := p.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uint32(len(data)) != p.Size() {
+				t.Errorf("Size should be %v but was %v", len(data), p.Size())
+			}
+		}
+	}
+}
+
+// TestPageTableMixedChildren tests if a pageTable that points to pageTables
+// and pages at the same time is rejected
+func TestPageTableMixedChildren(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	table, err := newPageTable(1, nil, pt.pm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := newPageTable(0, table, pt.pm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.childTables[0] = child
+	if err := table.writeToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a page to the table which already points to a pageTable
+	page, err := pt.pm.managedAllocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.childPages[1] = page
+	if err := table.checkChildren(); err == nil {
+		t.Error("checkChildren should reject a pageTable with pageTables and pages")
+	}
+	if err := table.writeToDisk(); err == nil {
+		t.Error("writeToDisk should reject a pageTable with pageTables and pages")
+	}
+}
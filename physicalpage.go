@@ -4,14 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
 )
 
 type (
 	// physicalPage is a helper struct to easily write/read pages to/from disk
 	physicalPage struct {
 		// file is the file on which the page is stored
-		file *os.File
+		file backingFile
 
 		// fileOff is the offset of the page to the beginning of the file
 		fileOff int64
@@ -19,6 +18,10 @@ type (
 		// usedSize is the amount of bytes of the page that are currently in
 		// use
 		usedSize int64
+
+		// strict indicates if failed sanity checks should panic instead of
+		// returning an error
+		strict bool
 	}
 )
 
@@ -41,8 +44,11 @@ func (p *physicalPage) readAt(b []byte, off int64) (n int, err error) {
 
 	data := make([]byte, length)
 	n, err = p.file.ReadAt(data, p.fileOff+off)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
 	if int64(n) != length {
-		panic(fmt.Sprintf("Sanity Check: ReadAt should have read %v bytes instead of %v",
+		return 0, sanityCheckFailed(p.strict, fmt.Sprintf("Sanity Check: ReadAt should have read %v bytes instead of %v",
 			length, n))
 	}
 
@@ -66,15 +72,11 @@ func (p *physicalPage) writeAt(b []byte, off int64) (n int, err error) {
 		length = pageSize - off
 	}
 
-	n, err = p.file.WriteAt(b[:length], p.fileOff+off)
+	n, err = writeFull(p.file, b[:length], p.fileOff+off)
 
 	// Update the usedSize if necessary
 	if off+length > p.usedSize {
 		p.usedSize = off + length
 	}
-
-	if int64(n) != length {
-		panic(fmt.Sprintf("Sanity Check: WriteAt should have written %v bytes", length))
-	}
 	return
 }
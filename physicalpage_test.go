@@ -74,6 +74,58 @@ func TestPPWriteAt(t *testing.T) {
 	}
 }
 
+// TestPPShortWrites tests if writes are retried until all data is written if
+// the file returns short writes without an error
+func TestPPShortWrites(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Return short writes before any pages are allocated
+	pt.pm.file = &shortFile{backingFile: pt.pm.file, maxWrite: 100}
+
+	// Write a full page
+	pp, err := pt.pm.managedAllocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(pageSize)
+	n, err := pp.writeAt(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != pageSize {
+		t.Errorf("Should have written %v bytes but was %v", pageSize, n)
+	}
+	checkDataIntegrity(pt, t, pp.fileOff, data)
+
+	// Write to an entry which also writes its pageTables
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = fastrand.Bytes(3*pageSize + 10)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
 // TestPPReadAt tests the functionality of the physicalPage's readAt function
 func TestPPReadAt(t *testing.T) {
 	pt, err := newPagingTester(t.Name())
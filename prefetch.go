@@ -0,0 +1,103 @@
+package pages
+
+import (
+	"errors"
+	"io"
+)
+
+// prefetchPages is the maximum number of pages a prefetchReader reads ahead
+const prefetchPages = 4
+
+// errPrefetchClosed is returned by reads from a closed prefetchReader
+var errPrefetchClosed = errors.New("prefetch reader was closed")
+
+type (
+	// prefetchReader is the io.ReadCloser returned by NewPrefetchReader
+	prefetchReader struct {
+		// chunks receives the pages that were read ahead
+		chunks <-chan prefetchChunk
+
+		// buf is the unread remainder of the current chunk and err is the
+		// error that ended the prefetching
+		buf []byte
+		err error
+
+		// stop signals the prefetching goroutine to exit and done is
+		// closed once it did
+		stop   chan struct{}
+		done   chan struct{}
+		closed bool
+	}
+
+	// prefetchChunk is a page of data read by the prefetching goroutine
+	prefetchChunk struct {
+		data []byte
+		err  error
+	}
+)
+
+// NewPrefetchReader returns a reader for the data of the entry starting at the
+// beginning of the entry. A background goroutine reads up to prefetchPages
+// pages ahead of the consumer to hide the latency of the storage. The reader
+// doesn't move the cursor of the entry. It needs to be closed to stop the
+// goroutine and the entry needs to stay open until then
+func NewPrefetchReader(e *Entry) io.ReadCloser {
+	chunks := make(chan prefetchChunk, prefetchPages)
+	r := &prefetchReader{
+		chunks: chunks,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go r.threadedPrefetch(e.NewCursor(), chunks)
+	return r
+}
+
+// threadedPrefetch reads the entry one page at a time until it reaches the
+// end of the entry, fails or is stopped
+func (r *prefetchReader) threadedPrefetch(c *Cursor, chunks chan<- prefetchChunk) {
+	defer close(r.done)
+	for {
+		data := make([]byte, pageSize)
+		n, err := c.Read(data)
+		select {
+		case chunks <- prefetchChunk{data: data[:n], err: err}:
+		case <-r.stop:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read reads from the pages that were read ahead and waits for the next page
+// if none are buffered
+func (r *prefetchReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, errPrefetchClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		chunk := <-r.chunks
+		r.buf, r.err = chunk.data, chunk.err
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close stops the prefetching goroutine and waits for it to exit
+func (r *prefetchReader) Close() error {
+	if r.closed {
+		return errPrefetchClosed
+	}
+	r.closed = true
+	close(r.stop)
+	<-r.done
+	return nil
+}
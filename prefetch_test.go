@@ -0,0 +1,129 @@
+package pages
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestPrefetchReader tests if a prefetchReader reads the whole entry and if
+// closing it stops the prefetching goroutine
+func TestPrefetchReader(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	data := fastrand.Bytes(50*pageSize + 10)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the whole entry
+	r := NewPrefetchReader(entry)
+	readData, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Fatal("Data read by prefetchReader doesn't match written data")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close a reader before the end of the entry. The goroutine should be
+	// blocked by the full buffer and exit
+	r = NewPrefetchReader(entry)
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:len(buf)], buf) {
+		t.Fatal("Data read by prefetchReader doesn't match written data")
+	}
+	for start := time.Now(); len(r.(*prefetchReader).chunks) < prefetchPages; {
+		if time.Since(start) > time.Second {
+			t.Fatalf("%v pages should be buffered but were %v", prefetchPages, len(r.(*prefetchReader).chunks))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-r.(*prefetchReader).done:
+	default:
+		t.Fatal("Prefetching goroutine should have exited")
+	}
+	if _, err := r.Read(buf); err != errPrefetchClosed {
+		t.Errorf("Read should return %v but was %v", errPrefetchClosed, err)
+	}
+}
+
+// BenchmarkPrefetchReader benchmarks consuming an entry on storage with a high
+// latency with and without a prefetchReader. The consumer spends some time on
+// every page and the time spent waiting for Read is reported as ns/read
+func BenchmarkPrefetchReader(b *testing.B) {
+	for _, prefetch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("prefetch=%v", prefetch), func(b *testing.B) {
+			pt, err := newPagingTester(b.Name())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer pt.Close()
+			pt.pm.file = &delayFile{backingFile: pt.pm.file, delay: 100 * time.Microsecond}
+
+			entry, _, err := pt.pm.Create()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer entry.Close()
+			numPages := 100
+			if _, err := entry.Write(fastrand.Bytes(numPages * pageSize)); err != nil {
+				b.Fatal(err)
+			}
+
+			var waited time.Duration
+			reads := 0
+			data := make([]byte, pageSize)
+			b.SetBytes(int64(numPages) * pageSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var r io.Reader = entry.NewCursor()
+				if prefetch {
+					r = NewPrefetchReader(entry)
+				}
+				for {
+					start := time.Now()
+					_, err := io.ReadFull(r, data)
+					waited += time.Since(start)
+					reads++
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						b.Fatal(err)
+					}
+
+					// Process the page
+					time.Sleep(100 * time.Microsecond)
+				}
+				if rc, ok := r.(io.Closer); ok {
+					rc.Close()
+				}
+			}
+			b.ReportMetric(float64(waited.Nanoseconds())/float64(reads), "ns/read")
+		})
+	}
+}
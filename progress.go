@@ -0,0 +1,42 @@
+package pages
+
+type (
+	// progress reports the progress of an operation to the ProgressFn of
+	// the Options. To limit the overhead, progress is only reported every
+	// progressInterval bytes and once the operation is done
+	progress struct {
+		// fn is the function progress is reported to
+		fn func(done, total int64)
+
+		// total is the total number of bytes of the operation
+		total int64
+
+		// reported is the number of bytes that were reported last
+		reported int64
+	}
+)
+
+// newProgress creates a progress for an operation of total bytes. It returns
+// nil if no ProgressFn was specified
+func (p *PageManager) newProgress(total int64) *progress {
+	if p.opts.ProgressFn == nil {
+		return nil
+	}
+	return &progress{
+		fn:    p.opts.ProgressFn,
+		total: total,
+	}
+}
+
+// update reports that done bytes of the operation were processed. It is safe
+// to call update on a nil progress
+func (p *progress) update(done int64) {
+	if p == nil || done == p.reported {
+		return
+	}
+	if done-p.reported < progressInterval && done != p.total {
+		return
+	}
+	p.reported = done
+	p.fn(done, p.total)
+}
@@ -0,0 +1,69 @@
+package pages
+
+import "sync"
+
+type (
+	// rangeLock serializes operations on overlapping byte ranges while
+	// operations on disjoint ranges can proceed concurrently
+	rangeLock struct {
+		// held are the ranges that are currently locked
+		held []byteRange
+
+		// mu protects held and cond is used to wait for ranges to be
+		// unlocked
+		mu   *sync.Mutex
+		cond *sync.Cond
+	}
+
+	// byteRange is the range of bytes [off, end)
+	byteRange struct {
+		off int64
+		end int64
+	}
+)
+
+// newRangeLock creates a new rangeLock
+func newRangeLock() *rangeLock {
+	mu := new(sync.Mutex)
+	return &rangeLock{
+		mu:   mu,
+		cond: sync.NewCond(mu),
+	}
+}
+
+// overlaps returns true if r overlaps with one of the held ranges. The mu
+// lock needs to be acquired
+func (rl *rangeLock) overlaps(r byteRange) bool {
+	for _, h := range rl.held {
+		if r.off < h.end && h.off < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// lock locks the range [off, end). It blocks until no overlapping range is
+// locked anymore
+func (rl *rangeLock) lock(off, end int64) byteRange {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	r := byteRange{off: off, end: end}
+	for rl.overlaps(r) {
+		rl.cond.Wait()
+	}
+	rl.held = append(rl.held, r)
+	return r
+}
+
+// unlock unlocks a range that was locked before
+func (rl *rangeLock) unlock(r byteRange) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for i, h := range rl.held {
+		if h == r {
+			rl.held = append(rl.held[:i], rl.held[i+1:]...)
+			break
+		}
+	}
+	rl.cond.Broadcast()
+}
@@ -0,0 +1,189 @@
+package pages
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+// recordHeaderSize is the size of the length prefix of a record
+const recordHeaderSize = 8
+
+// AppendRecord appends a record to the end of the entry and returns its id.
+// Records are stored with a length prefix which allows for reading them back
+// by their id. The ids of the records of an entry are consecutive and start
+// at 0
+func (e *Entry) AppendRecord(record []byte) (uint64, error) {
+	if err := e.managedCopyOnWrite(); err != nil {
+		return 0, err
+	}
+	// Modifying the entry requires the whole tree to be loaded
+	if err := e.ep.managedLoadTree(); err != nil {
+		return 0, err
+	}
+
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, err
+	}
+	if err := e.checkWritable(); err != nil {
+		return 0, err
+	}
+	if err := e.indexRecords(); err != nil {
+		return 0, build.ExtendErr("failed to index records", err)
+	}
+
+	// Write the length prefixed record to the end of the entry
+	off := e.ep.usedSize
+	if err := e.checkMaxSize(off + recordHeaderSize + int64(len(record))); err != nil {
+		return 0, err
+	}
+	data := make([]byte, recordHeaderSize+len(record))
+	binary.LittleEndian.PutUint64(data, uint64(len(record)))
+	copy(data[recordHeaderSize:], record)
+	if _, err := e.writeAndTouch(data, off); err != nil {
+		return 0, err
+	}
+
+	// Add the record to the index
+	e.ep.records = append(e.ep.records, off)
+	e.ep.recordsEnd = e.ep.usedSize
+	return uint64(len(e.ep.records) - 1), e.pm.managedCheckpoint(int64(len(data)))
+}
+
+// ReadRecord reads the record with the specified id
+func (e *Entry) ReadRecord(id uint64) ([]byte, error) {
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return nil, err
+	}
+	if err := e.indexRecords(); err != nil {
+		return nil, build.ExtendErr("failed to index records", err)
+	}
+	if id >= uint64(len(e.ep.records)) {
+		return nil, fmt.Errorf("record %v doesn't exist", id)
+	}
+
+	// Read the length prefix and the record
+	off := e.ep.records[id]
+	header := make([]byte, recordHeaderSize)
+	if err := e.readFull(header, off); err != nil {
+		return nil, err
+	}
+	record := make([]byte, binary.LittleEndian.Uint64(header))
+	if err := e.readFull(record, off+recordHeaderSize); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// AppendFramed appends p with a length prefix to the end of the entry and
+// returns the offset of the frame. Frames use the same format as records but
+// are addressed by their offset instead of an index which is why the entry
+// doesn't need to be indexed
+func (e *Entry) AppendFramed(p []byte) (offset int64, err error) {
+	if err := e.managedCopyOnWrite(); err != nil {
+		return 0, err
+	}
+	// Modifying the entry requires the whole tree to be loaded
+	if err := e.ep.managedLoadTree(); err != nil {
+		return 0, err
+	}
+
+	e.ep.mu.Lock()
+	defer e.ep.mu.Unlock()
+	if err := e.checkGeneration(); err != nil {
+		return 0, err
+	}
+	if err := e.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	// Write the length prefixed frame to the end of the entry
+	offset = e.ep.usedSize
+	if err := e.checkMaxSize(offset + recordHeaderSize + int64(len(p))); err != nil {
+		return 0, err
+	}
+	data := make([]byte, recordHeaderSize+len(p))
+	binary.LittleEndian.PutUint64(data, uint64(len(p)))
+	copy(data[recordHeaderSize:], p)
+	if _, err := e.writeAndTouch(data, offset); err != nil {
+		return 0, err
+	}
+	return offset, e.pm.managedCheckpoint(int64(len(data)))
+}
+
+// ReadFrame reads the frame that was appended at offset by AppendFramed
+func (e *Entry) ReadFrame(offset int64) ([]byte, error) {
+	e.ep.mu.RLock()
+	defer e.ep.mu.RUnlock()
+	if err := e.checkGeneration(); err != nil {
+		return nil, err
+	}
+	if offset < 0 || offset > e.ep.usedSize-recordHeaderSize {
+		return nil, fmt.Errorf("no frame at offset %v", offset)
+	}
+
+	// Read the length prefix and the frame
+	header := make([]byte, recordHeaderSize)
+	if err := e.readFull(header, offset); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint64(header)
+	if length > uint64(e.ep.usedSize-offset-recordHeaderSize) {
+		return nil, fmt.Errorf("frame at %v exceeds the end of the entry", offset)
+	}
+	frame := make([]byte, length)
+	if err := e.readFull(frame, offset+recordHeaderSize); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// indexRecords adds the records that were appended since the last call to
+// the index of the entry. If the entry was truncated, the whole entry is
+// indexed again. The ep.mu write lock needs to be acquired
+func (e *Entry) indexRecords() error {
+	if e.ep.recordsEnd > e.ep.usedSize {
+		e.ep.records = nil
+		e.ep.recordsEnd = 0
+	}
+	header := make([]byte, recordHeaderSize)
+	for e.ep.recordsEnd < e.ep.usedSize {
+		if err := e.readFull(header, e.ep.recordsEnd); err != nil {
+			return err
+		}
+		end := e.ep.recordsEnd + recordHeaderSize + int64(binary.LittleEndian.Uint64(header))
+		if end > e.ep.usedSize || end < e.ep.recordsEnd {
+			return fmt.Errorf("record at %v exceeds the end of the entry", e.ep.recordsEnd)
+		}
+		e.ep.records = append(e.ep.records, e.ep.recordsEnd)
+		e.ep.recordsEnd = end
+	}
+	return nil
+}
+
+// readFull is a helper function that reads exactly len(p) bytes starting at
+// off. The ep.mu read lock needs to be acquired
+func (e *Entry) readFull(p []byte, off int64) error {
+	cursorPage := int64(0)
+	cursorOff := int64(0)
+	if err := e.seek(off, &cursorPage, &cursorOff); err != nil {
+		return err
+	}
+	for n := 0; n < len(p); {
+		read, err := e.read(p[n:], &cursorPage, &cursorOff)
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		n += read
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package pages
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestRecords tests if records of varying sizes can be appended and read back
+// in random order
+func TestRecords(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Append records of varying sizes. Some span multiple pages and one is
+	// empty
+	var records [][]byte
+	for i := 0; i < 50; i++ {
+		record := fastrand.Bytes(fastrand.Intn(2 * pageSize))
+		if i == 10 {
+			record = []byte{}
+		}
+		recordID, err := entry.AppendRecord(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recordID != uint64(i) {
+			t.Fatalf("Record id should be %v but was %v", i, recordID)
+		}
+		records = append(records, record)
+	}
+
+	// Read them back in random order
+	for _, i := range fastrand.Perm(len(records)) {
+		record, err := entry.ReadRecord(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(records[i], record) != 0 {
+			t.Errorf("Record %v doesn't match the appended record", i)
+		}
+	}
+	if _, err := entry.ReadRecord(uint64(len(records))); err == nil {
+		t.Error("Reading a record that doesn't exist should fail")
+	}
+
+	// Reopen the entry to rebuild the index and read the records again
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range fastrand.Perm(len(records)) {
+		record, err := entry.ReadRecord(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(records[i], record) != 0 {
+			t.Errorf("Record %v doesn't match the appended record after reopening the entry", i)
+		}
+	}
+
+	// Truncating the entry to the end of a record drops the following
+	// records
+	if err := entry.Truncate(entry.ep.records[20]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.ReadRecord(20); err == nil {
+		t.Error("Reading a truncated record should fail")
+	}
+	recordID, err := entry.AppendRecord(records[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recordID != 20 {
+		t.Errorf("Record id should be %v but was %v", 20, recordID)
+	}
+}
+
+// TestFramed tests if frames of varying sizes can be appended and read back by
+// the offsets returned by AppendFramed
+func TestFramed(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create new entry
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+
+	// Append frames of varying sizes. Some span multiple pages and one is
+	// empty
+	var frames [][]byte
+	var offsets []int64
+	expectedOff := int64(0)
+	for i := 0; i < 20; i++ {
+		frame := fastrand.Bytes(fastrand.Intn(2 * pageSize))
+		if i == 5 {
+			frame = []byte{}
+		}
+		off, err := entry.AppendFramed(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off != expectedOff {
+			t.Fatalf("Frame %v should start at %v but started at %v", i, expectedOff, off)
+		}
+		expectedOff += recordHeaderSize + int64(len(frame))
+		frames = append(frames, frame)
+		offsets = append(offsets, off)
+	}
+
+	// Read them back in random order
+	for _, i := range fastrand.Perm(len(frames)) {
+		frame, err := entry.ReadFrame(offsets[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(frames[i], frame) != 0 {
+			t.Errorf("Frame %v doesn't match the appended frame", i)
+		}
+	}
+
+	// Reading beyond the last frame or a frame whose length exceeds the
+	// entry fails
+	if _, err := entry.ReadFrame(expectedOff); err == nil {
+		t.Error("Reading a frame at the end of the entry should fail")
+	}
+	if err := entry.Truncate(expectedOff - 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.ReadFrame(offsets[len(offsets)-1]); err == nil {
+		t.Error("Reading a truncated frame should fail")
+	}
+}
@@ -3,11 +3,16 @@ package pages
 // TODO whenever usedSize changes update the entry on disk
 
 import (
+	"container/list"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/NebulousLabs/Sia/build"
 )
@@ -33,6 +38,31 @@ type (
 
 		// mu is used to lock all operations on the entries
 		mu *sync.RWMutex
+
+		// partial indicates that the pageTable tree was only partially
+		// recovered. Pages that are nil in pages and pageTables that are
+		// unloaded are read from disk on demand
+		partial bool
+
+		// lazyMu protects loading pages on demand while only the mu read
+		// lock is held
+		lazyMu *sync.Mutex
+
+		// loadedTables are the pageTables that were loaded on demand ordered
+		// from the most to the least recently used one and loadedElems maps
+		// them to their elements. If loadedTables isn't nil, pages isn't
+		// materialized and the number of loaded tables is limited by
+		// MaxLoadedTables
+		loadedTables *list.List
+		loadedElems  map[*pageTable]*list.Element
+
+		// atomicPinned is the number of times the entry was pinned. The
+		// pageTables of a pinned entry stay in memory
+		atomicPinned int32
+
+		// dirtyTables are the pageTables that were modified but not written
+		// to disk yet if pageTable writes are coalesced
+		dirtyTables map[*pageTable]struct{}
 	}
 
 	// entryPage is the first page of an Entry.
@@ -43,6 +73,42 @@ type (
 		// atomicInstanceCounter counts the number of open references to the
 		// entryPage. It is increased in Open and decreased in Close
 		instanceCounter uint64
+
+		// generation is the generation of the entry stored on the entryPage.
+		// It is set to 0 when the entry is deleted to invalidate open
+		// handles
+		generation uint64
+
+		// records are the offsets of the records of the entry that were
+		// indexed so far and recordsEnd is the offset of the end of the last
+		// indexed record
+		records    []int64
+		recordsEnd int64
+
+		// hash is the cached content hash of the entry. It is nil if the
+		// hash isn't cached. hashMu protects it and modTime from concurrent
+		// in-place writes
+		hash   []byte
+		hashMu *sync.Mutex
+
+		// atomicExclusive is 1 if an exclusive handle of the entry is open
+		atomicExclusive uint32
+
+		// atomicUnsynced is 1 if the entry might have been modified since it
+		// was last synced by Entry.Sync or Entry.Flush
+		atomicUnsynced uint32
+
+		// ranges serializes in-place writes to overlapping ranges of the
+		// entry
+		ranges *rangeLock
+
+		// maxSize is the maximum size of the entry that was set by
+		// SetMaxSize. 0 means that the size isn't limited
+		maxSize int64
+
+		// modTime is the time of the last modification of the entry in
+		// nanoseconds since the Unix epoch. It is 0 if it wasn't tracked
+		modTime int64
 	}
 
 	// recyclingPage is a tiered page that stores all the free pages
@@ -55,6 +121,35 @@ type (
 		// recyclingPage.
 		pagesToFree []*physicalPage
 	}
+
+	// recoveryState is the state that is shared between the recursive calls
+	// of recursiveRecovery
+	recoveryState struct {
+		// mu protects the fields of the recoveryState if subtrees are
+		// recovered concurrently
+		mu *sync.Mutex
+
+		// visited contains the offsets of all the pages that were visited
+		// during the recovery to detect cycles and pages that are
+		// referenced more than once
+		visited map[int64]struct{}
+
+		// numTables is the number of pageTables that were read so far
+		numTables int
+
+		// maxTables is the maximum number of pageTables that may be read. 0
+		// means that there is no limit
+		maxTables int
+
+		// workers limits the number of additional goroutines that recover
+		// subtrees concurrently. If it is nil the tree is recovered
+		// sequentially
+		workers chan struct{}
+
+		// fileSize is the size of the file when the recovery started. No
+		// page may end beyond it
+		fileSize int64
+	}
 )
 
 // AddPages adds multiple physical pages to the tree and increments the
@@ -65,22 +160,35 @@ func (ep *entryPage) addPages(pages []*physicalPage, addedBytes int64) error {
 		return nil
 	}
 
-	// Sanity check length of ep.pages
-	if int(ep.nextIndex())+len(pages) != len(ep.pages) {
-		panic("ep.pages should already contain the updated number of pages")
+	// Sanity check length of ep.pages. It might contain unused pages that
+	// were kept by TrimToSize before the new pages
+	if int(ep.nextIndex())+len(pages) > len(ep.pages) {
+		return sanityCheckFailed(ep.pm.opts.StrictMode, "ep.pages should already contain the updated number of pages")
 	}
 
 	// Add the pages to the entryPage
-	index := ep.nextIndex()
+	firstIndex := uint64(len(ep.pages) - len(pages))
+	index := firstIndex
 	for _, page := range pages {
 		root := ep.root
 		if err := ep.insertPage(index, page); err != nil {
-			return build.ExtendErr("failed to insert page", err)
+			// Remove the pages that were already inserted to keep the tree
+			// consistent with the usedSize
+			if err := ep.removePages(firstIndex, index); err != nil {
+				return build.ExtendErr("failed to remove inserted pages", err)
+			}
+			if err := ep.flushTables(); err != nil {
+				return err
+			}
+			return extendErr("failed to insert page", err)
 		}
 
 		// Check if root changed. If it did write down the entry for the last
 		// root with it's max value for usedBytes before changing ep.root.
 		if root != ep.root {
+			if err := ep.flushTables(); err != nil {
+				return err
+			}
 			bytesUsed := int64(maxPages(root.height) * pageSize)
 			if err := writeTieredPageEntry(ep.pp, root.height, bytesUsed, root.pp.fileOff); err != nil {
 				return err
@@ -89,6 +197,11 @@ func (ep *entryPage) addPages(pages []*physicalPage, addedBytes int64) error {
 		index++
 	}
 
+	// Write the modified pageTables before the metadata
+	if err := ep.flushTables(); err != nil {
+		return err
+	}
+
 	// Increment the usedSize
 	ep.usedSize += addedBytes
 
@@ -117,12 +230,18 @@ func (rp *recyclingPage) addPages(pages []*physicalPage) error {
 
 		root := rp.root
 		if err := rp.insertPage(index, page); err != nil {
+			if err := rp.flushTables(); err != nil {
+				return err
+			}
 			return build.ExtendErr("failed to insert page", err)
 		}
 
 		// Check if root changed. If it did write down the entry for the last
 		// root with it's max value for usedBytes before changing ep.root.
 		if root != rp.root {
+			if err := rp.flushTables(); err != nil {
+				return err
+			}
 			bytesUsed := int64(maxPages(root.height) * pageSize)
 			if err := writeTieredPageEntry(rp.pp, root.height, bytesUsed, root.pp.fileOff); err != nil {
 				return err
@@ -130,6 +249,12 @@ func (rp *recyclingPage) addPages(pages []*physicalPage) error {
 		}
 		index++
 	}
+
+	// Write the modified pageTables before the metadata
+	if err := rp.flushTables(); err != nil {
+		return err
+	}
+
 	// Increment the usedSize
 	rp.usedSize += int64(len(pages)) * pageSize
 
@@ -147,11 +272,19 @@ func (tp *tieredPage) defrag() ([]*physicalPage, error) {
 		return nil, err
 	}
 
+	// The number of children of the root can only be checked if it is loaded
+	if err := tp.loadTable(tp.root); err != nil {
+		return nil, err
+	}
+
 	// Defrag until the root node has multiple children
 	var err error
 	var pagesToFree []*physicalPage
 	for tp.root.height > 0 && len(tp.root.childTables) == 1 {
 		child := tp.root.childTables[0]
+		if err := tp.loadTable(child); err != nil {
+			return nil, err
+		}
 
 		// Write the previous pageEntry's entry
 		err = writeTieredPageEntry(tp.pp, child.height, tp.usedSize, child.pp.fileOff)
@@ -175,6 +308,20 @@ func (tp *tieredPage) defrag() ([]*physicalPage, error) {
 		tp.root.parent = nil
 	}
 
+	// An empty root doesn't need to be higher than 0. Its page can be reused
+	// for the lowered root since an empty pageTable looks the same at every
+	// height
+	if tp.root.height > 0 && len(tp.root.childTables) == 0 {
+		height := tp.root.height
+		tp.root.height = 0
+		if err := writeTieredPageEntry(tp.pp, 0, tp.usedSize, tp.root.pp.fileOff); err != nil {
+			return nil, err
+		}
+		if err := writeTieredPageEntry(tp.pp, height, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+
 	return pagesToFree, nil
 }
 
@@ -184,9 +331,116 @@ func (rp *recyclingPage) availablePages() int {
 }
 
 // nextIndex returns the next index that can be used to insert a page into the
-// tiered page
+// tiered page. A partially used last page still occupies an index.
 func (tp *tieredPage) nextIndex() uint64 {
-	return uint64(tp.usedSize / pageSize)
+	return uint64((tp.usedSize + pageSize - 1) / pageSize)
+}
+
+// loadTree recovers the whole pageTable tree if it was only partially
+// recovered. The mu write lock needs to be acquired
+func (tp *tieredPage) loadTree() error {
+	if !tp.partial {
+		return nil
+	}
+	if err := tp.recoverTree(tp.root.pp.fileOff, tp.root.height); err != nil {
+		return err
+	}
+	tp.partial = false
+	tp.loadedTables = nil
+	tp.loadedElems = nil
+	return nil
+}
+
+// managedLoadTree acquires the mu write lock and recovers the whole pageTable
+// tree if it was only partially recovered
+func (tp *tieredPage) managedLoadTree() error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.loadTree()
+}
+
+// managedPrepareTruncate acquires the mu write lock and makes sure that the
+// tree can be truncated to size bytes. If the tree was only partially
+// recovered, the pageTables are loaded on demand while the tree is truncated.
+// The whole tree is loaded if it grows, if its pages aren't materialized or
+// if it contains pages that were kept by TrimToSize
+func (tp *tieredPage) managedPrepareTruncate(size int64) error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if !tp.partial {
+		return nil
+	}
+	if size > tp.usedSize || tp.loadedTables != nil {
+		return tp.loadTree()
+	}
+	trimmed, err := tp.hasTrimmedPages()
+	if err != nil {
+		return build.ExtendErr("failed to check for trimmed pages", err)
+	}
+	if trimmed {
+		return tp.loadTree()
+	}
+	return nil
+}
+
+// hasTrimmedPages returns true if the tree contains pages beyond its usedSize
+// that were kept by TrimToSize. The pageTables on the path to the last page
+// are read from disk without loading them into the tree
+func (tp *tieredPage) hasTrimmedPages() (bool, error) {
+	last := tp.numPages() - 1
+	pp := tp.root.pp
+	for height := tp.root.height; ; height-- {
+		entries, err := readPageTable(pp)
+		if err != nil {
+			return false, err
+		}
+		if last < 0 {
+			return len(entries) > 0, nil
+		}
+		index := uint64(last) % numPageEntries
+		if height > 0 {
+			index = childIndex(uint64(last), height)
+		}
+		if uint64(len(entries)) > index+1 {
+			return true, nil
+		}
+		if height == 0 {
+			return false, nil
+		}
+		pp = &physicalPage{
+			file:     pp.file,
+			fileOff:  entries[index],
+			usedSize: pageSize,
+			strict:   pp.strict,
+		}
+	}
+}
+
+// loadTable loads the children of a pageTable that wasn't loaded yet while
+// the tree is truncated. A table that is truncated contains the last page of
+// the tree. The mu write lock needs to be acquired
+func (tp *tieredPage) loadTable(pt *pageTable) error {
+	if !pt.unloaded {
+		return nil
+	}
+	tp.lazyMu.Lock()
+	defer tp.lazyMu.Unlock()
+	var firstPage uint64
+	if last := tp.numPages() - 1; last > 0 {
+		firstPage = uint64(last) - uint64(last)%numPageEntries
+	}
+	if err := tp.loadChildren(pt, firstPage); err != nil {
+		return build.ExtendErr("failed to load pageTable", err)
+	}
+	return nil
+}
+
+// numPages returns the number of pages of the tree including holes
+func (tp *tieredPage) numPages() int64 {
+	if tp.loadedTables != nil {
+		return int64(tp.nextIndex())
+	}
+	return int64(len(tp.pages))
 }
 
 // maxPages return the number of pages the tree can contain
@@ -194,6 +448,12 @@ func (tp *tieredPage) maxPages() uint64 {
 	return maxPages(tp.root.height)
 }
 
+// childIndex returns the index of the child of a pageTable with a certain
+// height that leads to the page with the specified index
+func childIndex(index uint64, height int64) uint64 {
+	return (index / maxPages(height-1)) % numPageEntries
+}
+
 // cap returns the number of pages a tree with a certain height can contain.
 // The height starts at 0. This means a simple tree with 1 root node and
 // numPageEntries leaves would have height 1
@@ -210,50 +470,130 @@ func (tp *tieredPage) insertPage(index uint64, pp *physicalPage) error {
 	for maxPages := tp.maxPages(); index >= maxPages; maxPages = tp.maxPages() {
 		newRoot, err := extendPageTableTree(tp.root, tp.pm)
 		if err != nil {
-			return build.ExtendErr("Failed to extend the pageTable tree", err)
+			return extendErr("Failed to extend the pageTable tree", err)
 		}
 		tp.root = newRoot
 	}
 
 	// Search the tree for the correct pageTable to insert the page
 	pt := tp.root
-	var tableIndex uint64
-	var pageIndex = index
 	for pt.height > 0 {
-		tableIndex = pageIndex / maxPages(pt.height-1)
-		pageIndex /= numPageEntries
+		tableIndex := childIndex(index, pt.height)
 
 		// Check if the pageTable exists. If it doesn't, we have to create it
 		_, exists := pt.childTables[tableIndex]
 		if !exists {
 			newPt, err := newPageTable(pt.height-1, pt, tp.pm)
 			if err != nil {
-				return build.ExtendErr("failed to create a new pageTable", err)
+				return extendErr("failed to create a new pageTable", err)
 			}
 			pt.childTables[tableIndex] = newPt
-			if err := pt.writeToDisk(); err != nil {
+			if err := tp.writeTable(pt); err != nil {
 				return build.ExtendErr("failed to write pageTable to disk", err)
 			}
 		}
 		pt = pt.childTables[tableIndex]
 	}
 
-	// Sanity check the child pages
+	// Sanity check the child pages. Pages are always appended and holes are
+	// inserted as nil pages. The index needs to point to the next slot of
+	// the pageTable
 	if len(pt.childPages) == numPageEntries {
-		panic(fmt.Sprintf("We shouldn't insert if childPages is already full: index %v", index))
+		return sanityCheckFailed(tp.pm.opts.StrictMode, fmt.Sprintf("We shouldn't insert if childPages is already full: index %v", index))
 	}
-	if len(pt.childPages) > 0 && pt.childPages[index%numPageEntries-1] == nil {
-		panic("Inserting shouldn't create a gap")
+	if uint64(len(pt.childPages)) != index%numPageEntries {
+		return sanityCheckFailed(tp.pm.opts.StrictMode, fmt.Sprintf("Inserting page %v shouldn't create a gap. The pageTable has %v pages",
+			index, len(pt.childPages)))
 	}
 
 	// Insert page
 	pt.childPages[index%numPageEntries] = pp
-	if err := pt.writeToDisk(); err != nil {
-		return err
+	return tp.writeTable(pt)
+}
+
+// writeTable writes a modified pageTable to disk. If pageTable writes are
+// coalesced, the table is only marked dirty and written by the next call to
+// flushTables
+func (tp *tieredPage) writeTable(pt *pageTable) error {
+	if !tp.pm.opts.CoalesceTableWrites {
+		return pt.writeToDisk()
+	}
+	if tp.dirtyTables == nil {
+		tp.dirtyTables = make(map[*pageTable]struct{})
+	}
+	tp.dirtyTables[pt] = struct{}{}
+	return nil
+}
+
+// flushTables writes the dirty pageTables to disk
+func (tp *tieredPage) flushTables() error {
+	for pt := range tp.dirtyTables {
+		if err := pt.writeToDisk(); err != nil {
+			return build.ExtendErr("failed to write pageTable to disk", err)
+		}
+		delete(tp.dirtyTables, pt)
+	}
+	return nil
+}
+
+// removePages removes the pages with an index in the range [from, to) from
+// the lowest pageTables of the tree. It is used to undo inserting pages.
+// pageTables that become empty are not removed
+func (tp *tieredPage) removePages(from, to uint64) error {
+	// Remove the pages in reverse order to avoid gaps within the pageTables
+	for i := to; i > from; i-- {
+		index := i - 1
+
+		// Search the tree for the pageTable that contains the page
+		pt := tp.root
+		for pt != nil && pt.height > 0 {
+			pt = pt.childTables[childIndex(index, pt.height)]
+		}
+		if pt == nil {
+			continue
+		}
+
+		// Remove the page
+		delete(pt.childPages, index%numPageEntries)
+		if err := tp.writeTable(pt); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// replacePage replaces the page at the specified index with pp and writes the
+// modified pageTable to disk. It is used to materialize holes
+func (tp *tieredPage) replacePage(index uint64, pp *physicalPage) error {
+	// Search the tree for the pageTable that contains the page
+	pt := tp.root
+	for pt != nil && pt.height > 0 {
+		pt = pt.childTables[childIndex(index, pt.height)]
+	}
+	if pt == nil {
+		return fmt.Errorf("pageTable for page %v doesn't exist", index)
+	}
+	if _, exists := pt.childPages[index%numPageEntries]; !exists {
+		return fmt.Errorf("page at index %v doesn't exist", index)
+	}
+
+	// Replace the page
+	pt.childPages[index%numPageEntries] = pp
+	tp.pages[index] = pp
+	return pt.writeToDisk()
+}
+
+// hasHoles returns true if the range of length bytes starting at off contains
+// holes
+func (tp *tieredPage) hasHoles(off, length int64) bool {
+	for i := off / pageSize; i*pageSize < off+length && i < int64(len(tp.pages)); i++ {
+		if tp.pages[i] == nil {
+			return true
+		}
+	}
+	return false
+}
+
 // removePage removes a page at a given index from the tree and returns the
 // deleted page
 func (rp *recyclingPage) freePage() (page *physicalPage, err error) {
@@ -278,7 +618,7 @@ func (rp *recyclingPage) freePage() (page *physicalPage, err error) {
 	page = rp.pages[len(rp.pages)-1]
 
 	// Truncate by 1 page
-	_, pagesToFree1, err := rp.recursiveTruncate(rp.root, rp.usedSize-pageSize)
+	_, pagesToFree1, err := rp.recursiveTruncate(rp.root, rp.usedSize-pageSize, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -286,7 +626,7 @@ func (rp *recyclingPage) freePage() (page *physicalPage, err error) {
 	// The first truncated page is the one we would like to return so we
 	// shouldn't add it to the buffer
 	if pagesToFree1[0] != page {
-		panic("sanity check failed. Truncated page doesn't match the page to return")
+		return nil, sanityCheckFailed(rp.pm.opts.StrictMode, "sanity check failed. Truncated page doesn't match the page to return")
 	}
 	pagesToFree1 = pagesToFree1[1:]
 
@@ -301,9 +641,248 @@ func (rp *recyclingPage) freePage() (page *physicalPage, err error) {
 	return page, nil
 }
 
+// flushBuffer adds the pages of the pagesToFree buffer to the tree. The buffer
+// mostly contains the pageTables of the recycling page that were freed by
+// freePage and is lost if the PageManager is closed without flushing it
+func (rp *recyclingPage) flushBuffer() error {
+	if len(rp.pagesToFree) == 0 {
+		return nil
+	}
+	pages := rp.pagesToFree
+	rp.pagesToFree = nil
+	return rp.addPages(pages)
+}
+
+// repair makes the usedSize of the recycling page consistent with the pages
+// that were recovered from its tree. Pages beyond the usedSize were torn while
+// they were added and are dropped from the tree. If the tree contains fewer
+// pages than the usedSize, the usedSize is clamped. It returns true if the
+// recycling page had to be repaired
+func (rp *recyclingPage) repair() (bool, error) {
+	numPages := rp.nextIndex()
+	switch {
+	case uint64(len(rp.pages)) > numPages:
+		if _, err := dropPages(rp.root, 0, numPages); err != nil {
+			return false, build.ExtendErr("failed to drop torn pages", err)
+		}
+		rp.pages = rp.pages[:numPages]
+		return true, nil
+	case uint64(len(rp.pages)) < numPages:
+		rp.usedSize = int64(len(rp.pages)) * pageSize
+		if err := writeTieredPageEntry(rp.pp, rp.root.height, rp.usedSize, rp.root.pp.fileOff); err != nil {
+			return false, build.ExtendErr("failed to clamp usedSize", err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// dropPages removes the pages with an index of at least numPages from the
+// subtree of pt and updates the modified pageTables on disk. firstPage is the
+// index of the first page within the subtree. It returns true if pt is empty
+// afterwards
+func dropPages(pt *pageTable, firstPage uint64, numPages uint64) (bool, error) {
+	modified := false
+	if pt.height == 0 {
+		for i := range pt.childPages {
+			if firstPage+i >= numPages {
+				delete(pt.childPages, i)
+				modified = true
+			}
+		}
+	} else {
+		for i, child := range pt.childTables {
+			childFirstPage := firstPage + i*maxPages(pt.height-1)
+			empty := childFirstPage >= numPages
+			if !empty {
+				var err error
+				if empty, err = dropPages(child, childFirstPage, numPages); err != nil {
+					return false, err
+				}
+			}
+			if empty {
+				delete(pt.childTables, i)
+				modified = true
+			}
+		}
+	}
+	if modified {
+		if err := pt.writeToDisk(); err != nil {
+			return false, err
+		}
+	}
+	return len(pt.childPages) == 0 && len(pt.childTables) == 0, nil
+}
+
+// loadChildren reads the children of an unloaded pageTable from disk.
+// firstPage is the index of the first page within the subtree of the table
+// and is used to add loaded pages to tp.pages. The lazyMu needs to be
+// acquired
+func (tp *tieredPage) loadChildren(pt *pageTable, firstPage uint64) error {
+	entries, err := readPageTable(pt.pp)
+	if err != nil {
+		return err
+	}
+	for i, offset := range entries {
+		index := uint64(i)
+		if index >= numPageEntries {
+			return fmt.Errorf("child index %v of pageTable at %v is out of bounds",
+				index, pt.pp.fileOff)
+		}
+		pp := &physicalPage{
+			file:     pt.pp.file,
+			fileOff:  offset,
+			usedSize: pageSize,
+			strict:   pt.pp.strict,
+		}
+
+		// Add children of higher tables as unloaded pageTables
+		if pt.height > 0 {
+			pt.childTables[index] = &pageTable{
+				height:      pt.height - 1,
+				parent:      pt,
+				childTables: make(map[uint64]*pageTable),
+				childPages:  make(map[uint64]*physicalPage),
+				pp:          pp,
+				unloaded:    true,
+			}
+			continue
+		}
+
+		// Add children of the lowest tables as pages. Only the last page
+		// might not be full
+		pageIndex := firstPage + index
+		if pageIndex >= uint64(tp.numPages()) {
+			// Pages beyond the end of the entry were kept by TrimToSize
+			// and are only loaded with the whole tree
+			break
+		}
+		if offset == 0 {
+			// The page is a hole
+			pt.childPages[index] = nil
+			continue
+		}
+		if remainingBytes := tp.usedSize - int64(pageIndex)*pageSize; remainingBytes < pageSize {
+			pp.usedSize = remainingBytes
+		}
+		pt.childPages[index] = pp
+		if tp.loadedTables == nil {
+			tp.pages[pageIndex] = pp
+		}
+	}
+	if err := pt.checkChildren(); err != nil {
+		return err
+	}
+	pt.unloaded = false
+	return nil
+}
+
+// page returns the physical page at a specific index. If the tree was only
+// partially recovered, the page and the pageTables leading to it are loaded
+// from disk. If the page is a hole, nil is returned. The mu read lock needs to
+// be acquired
+func (tp *tieredPage) page(index int64) (*physicalPage, error) {
+	if index < 0 || index >= tp.numPages() {
+		return nil, fmt.Errorf("page at index %v doesn't exist", index)
+	}
+	if !tp.partial {
+		return tp.pages[index], nil
+	}
+	tp.lazyMu.Lock()
+	defer tp.lazyMu.Unlock()
+	if tp.loadedTables == nil && tp.pages[index] != nil {
+		return tp.pages[index], nil
+	}
+	if tp.loadedTables != nil {
+		defer tp.evictTables()
+	}
+
+	// Walk down the tree and load the missing tables
+	pt := tp.root
+	for {
+		if pt.unloaded {
+			firstPage := uint64(index) - uint64(index)%numPageEntries
+			if err := tp.loadChildren(pt, firstPage); err != nil {
+				return nil, build.ExtendErr("failed to load pageTable", err)
+			}
+		}
+		if tp.loadedTables != nil {
+			tp.touchTable(pt)
+		}
+		if pt.height == 0 {
+			break
+		}
+		child, exists := pt.childTables[childIndex(uint64(index), pt.height)]
+		if !exists {
+			return nil, fmt.Errorf("pageTable for page at index %v doesn't exist", index)
+		}
+		pt = child
+	}
+	page, exists := pt.childPages[uint64(index)%numPageEntries]
+	if !exists {
+		return nil, fmt.Errorf("page at index %v doesn't exist", index)
+	}
+	return page, nil
+}
+
+// touchTable marks a pageTable that was loaded on demand as the most recently
+// used one. The lazyMu needs to be acquired
+func (tp *tieredPage) touchTable(pt *pageTable) {
+	if elem, exists := tp.loadedElems[pt]; exists {
+		tp.loadedTables.MoveToFront(elem)
+		return
+	}
+	tp.loadedElems[pt] = tp.loadedTables.PushFront(pt)
+}
+
+// evictTables unloads the least recently used pageTables until no more than
+// MaxLoadedTables are loaded. The lazyMu needs to be acquired
+func (tp *tieredPage) evictTables() {
+	if atomic.LoadInt32(&tp.atomicPinned) > 0 {
+		return
+	}
+	for tp.loadedTables.Len() > tp.pm.opts.MaxLoadedTables {
+		pt := tp.loadedTables.Remove(tp.loadedTables.Back()).(*pageTable)
+		delete(tp.loadedElems, pt)
+		pt.childTables = make(map[uint64]*pageTable)
+		pt.childPages = make(map[uint64]*physicalPage)
+		pt.unloaded = true
+	}
+}
+
+// holeSize returns the number of bytes of the entry that belong to a hole at
+// the specified index
+func (tp *tieredPage) holeSize(index int64) int64 {
+	size := tp.usedSize - index*pageSize
+	if size > pageSize {
+		size = pageSize
+	}
+	if size < 0 {
+		size = 0
+	}
+	return size
+}
+
+// newEntryPage creates the entryPage of the entry with the specified
+// generation that is stored on the tieredPage. The entry starts out unsynced
+// since it might have been modified before it was loaded
+func newEntryPage(tp *tieredPage, generation uint64) *entryPage {
+	return &entryPage{
+		tieredPage:     tp,
+		generation:     generation,
+		hashMu:         new(sync.Mutex),
+		atomicUnsynced: 1,
+		ranges:         newRangeLock(),
+	}
+}
+
 // readEntryPageEntry reads the usedBytes of a pageTable and a ptr to the
 // pageTable at a specific offset of a page from disk
 func readEntryPageEntry(pp *physicalPage, index int64) (usedBytes int64, pageOff int64, err error) {
+	if err = checkSlotIndex(index); err != nil {
+		return
+	}
+
 	// Read the data from disk
 	entryData := make([]byte, tieredPageEntrySize)
 	_, err = pp.readAt(entryData, index*tieredPageEntrySize)
@@ -326,13 +905,36 @@ func readEntryPageEntry(pp *physicalPage, index int64) (usedBytes int64, pageOff
 	return
 }
 
+// readGeneration reads the generation stored on a tieredPage from disk
+func readGeneration(pp *physicalPage) (uint64, error) {
+	data := make([]byte, 8)
+	if _, err := pp.readAt(data, generationOff); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+// readContentHash reads the cached content hash of an entry from its
+// entryPage. If no hash was cached for the specified generation, nil is
+// returned
+func readContentHash(pp *physicalPage, generation uint64) ([]byte, error) {
+	data := make([]byte, 8+sha256.Size)
+	if _, err := pp.readAt(data, contentHashOff); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint64(data) != generation {
+		return nil, nil
+	}
+	return data[8:], nil
+}
+
 // readPageTable read the tableType and entries of a pageTable
 func readPageTable(pp *physicalPage) (entries []int64, err error) {
 	pageData := make([]byte, pageSize)
 	if _, err := pp.readAt(pageData, 0); err != nil {
 		return nil, err
 	}
-	return unmarshalPageTable(pageData)
+	return unmarshalPageTable(pageData, pp.strict)
 }
 
 // recoverTree recovers the pageTable tree recursively starting at the offset
@@ -343,6 +945,7 @@ func (tp *tieredPage) recoverTree(rootOff int64, height int64) (err error) {
 		file:     tp.pp.file,
 		fileOff:  rootOff,
 		usedSize: pageSize,
+		strict:   tp.pp.strict,
 	}
 
 	// Create the root object. Most of it's fields will be initialized in
@@ -354,20 +957,59 @@ func (tp *tieredPage) recoverTree(rootOff int64, height int64) (err error) {
 		childPages:  make(map[uint64]*physicalPage),
 	}
 
+	// Make sure that the file wasn't truncated below the root
+	stat, err := tp.pp.file.Stat()
+	if err != nil {
+		return build.ExtendErr("failed to get size of file", err)
+	}
+	if rootOff+pageSize > stat.Size() {
+		return ErrTruncatedFile
+	}
+
 	// Recover the tree recursively
-	remainingBytes := tp.usedSize
-	tp.pages, err = recursiveRecovery(root, height, &remainingBytes)
+	rs := &recoveryState{
+		mu:        new(sync.Mutex),
+		visited:   map[int64]struct{}{rootOff: struct{}{}},
+		maxTables: tp.pm.opts.MaxRecoveryNodes,
+		fileSize:  stat.Size(),
+	}
+	if tp.pm.opts.RecoveryWorkers > 1 {
+		rs.workers = make(chan struct{}, tp.pm.opts.RecoveryWorkers-1)
+	}
+	pages, err := recursiveRecovery(root, height, rs)
 	if err != nil {
 		return
 	}
 
+	// Only the last page of the entry might not be full
+	for i, page := range pages {
+		if page != nil {
+			page.usedSize = tp.holeSize(int64(i))
+			if page.fileOff+page.usedSize > rs.fileSize {
+				return ErrTruncatedFile
+			}
+		}
+	}
+
+	tp.pages = pages
 	tp.root = root
 	return
 }
 
 // recursiveRecovery is a helper function for recoverTree to recursively
-// recover pageTables starting from a specific parent
-func recursiveRecovery(parent *pageTable, height int64, remainingBytes *int64) (pages []*physicalPage, err error) {
+// recover pageTables starting from a specific parent. The subtrees of the
+// parent are recovered concurrently if there are idle workers. Their pages
+// are assembled in order of their index to keep the result deterministic
+func recursiveRecovery(parent *pageTable, height int64, rs *recoveryState) (pages []*physicalPage, err error) {
+	// Make sure we don't exceed the maximum number of tables
+	rs.mu.Lock()
+	rs.numTables++
+	exceeded := rs.maxTables > 0 && rs.numTables > rs.maxTables
+	rs.mu.Unlock()
+	if exceeded {
+		return nil, fmt.Errorf("recovery exceeded the limit of %v pageTables", rs.maxTables)
+	}
+
 	// Get the type and children of the table
 	entries, err := readPageTable(parent.pp)
 	if err != nil {
@@ -375,45 +1017,65 @@ func recursiveRecovery(parent *pageTable, height int64, remainingBytes *int64) (
 	}
 
 	// load children as pageTables
-	for _, offset := range entries {
+	var subtrees []*pageTable
+	for i, offset := range entries {
+		// The index of a child is its position within the table. Make sure
+		// that it is within bounds and that no page is referenced twice. A
+		// page that was visited before indicates a cycle or a corrupted
+		// table
+		index := uint64(i)
+		if index >= numPageEntries {
+			return nil, fmt.Errorf("child index %v of pageTable at %v is out of bounds",
+				index, parent.pp.fileOff)
+		}
+
+		// An offset of 0 marks a hole. Only pages can be holes
+		if offset == 0 && height == 0 {
+			parent.childPages[index] = nil
+			pages = append(pages, nil)
+			continue
+		}
+		rs.mu.Lock()
+		_, exists := rs.visited[offset]
+		rs.visited[offset] = struct{}{}
+		rs.mu.Unlock()
+		if exists {
+			return nil, fmt.Errorf("pageTable at %v references already visited offset %v",
+				parent.pp.fileOff, offset)
+		}
+
+		// The usedSize of the pages is set by recoverTree once all the
+		// pages are known
 		pp := &physicalPage{
 			file:     parent.pp.file,
 			fileOff:  offset,
 			usedSize: pageSize,
+			strict:   parent.pp.strict,
 		}
 
 		// Load children as pageTable
 		if height > 0 {
+			if offset+pageSize > rs.fileSize {
+				return nil, ErrTruncatedFile
+			}
 			pt := &pageTable{
-				height:      height,
+				height:      height - 1,
 				parent:      parent,
 				childTables: make(map[uint64]*pageTable),
 				childPages:  make(map[uint64]*physicalPage),
 				pp:          pp,
 			}
 
-			p, err := recursiveRecovery(pt, height-1, remainingBytes)
-			if err != nil {
-				return nil, err
-			}
-			pages = append(pages, p...)
-
-			// Set parent's fields
-			parent.childTables[uint64(len(parent.childTables)-1)] = pt
+			// Set parent's fields and recover the subtree later
+			parent.childTables[index] = pt
+			subtrees = append(subtrees, pt)
 			continue
 		}
 
 		// Load children as pages
 		if height == 0 {
-			if *remainingBytes > pageSize {
-				pp.usedSize = pageSize
-				*remainingBytes -= pageSize
-			} else {
-				pp.usedSize = *remainingBytes
-				*remainingBytes = 0
-			}
 			// Set parent's fields
-			parent.childPages[uint64(len(parent.childPages)-1)] = pp
+			parent.childPages[index] = pp
 			pages = append(pages, pp)
 			continue
 		}
@@ -423,14 +1085,119 @@ func recursiveRecovery(parent *pageTable, height int64, remainingBytes *int64) (
 			panic("Sanity check failed. Height cannot be a negative value")
 		}
 	}
+	if err := parent.checkChildren(); err != nil {
+		return nil, err
+	}
 
+	// Recover the subtrees. Use a worker if one is idle and recover the
+	// subtree in the current goroutine otherwise
+	results := make([][]*physicalPage, len(subtrees))
+	errs := make([]error, len(subtrees))
+	var wg sync.WaitGroup
+	for i, pt := range subtrees {
+		select {
+		case rs.workers <- struct{}{}:
+			wg.Add(1)
+			go func(i int, pt *pageTable) {
+				defer wg.Done()
+				results[i], errs[i] = recursiveRecovery(pt, height-1, rs)
+				<-rs.workers
+			}(i, pt)
+			continue
+		default:
+		}
+		results[i], errs[i] = recursiveRecovery(pt, height-1, rs)
+		if errs[i] != nil {
+			break
+		}
+	}
+	wg.Wait()
+	for i := range subtrees {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		pages = append(pages, results[i]...)
+	}
 	return
 }
 
+// truncateTree shrinks the tree to size bytes and defrags it afterwards. It
+// returns the pages that are no longer needed. The mu write lock needs to be
+// acquired
+func (tp *tieredPage) truncateTree(size int64, prog *progress) ([]*physicalPage, error) {
+	_, pagesToFree1, err := tp.recursiveTruncate(tp.root, size, prog)
+	if err != nil {
+		return nil, err
+	}
+	pagesToFree2, err := tp.defrag()
+	if err != nil {
+		return nil, err
+	}
+	return append(pagesToFree1, pagesToFree2...), nil
+}
+
+// truncateAll removes all the pages and pageTables of the tree in a single
+// pass and returns them. Unlike truncateTree, which removes the pages one by
+// one, only the root is written before it is lowered to a height of 0. The mu
+// write lock needs to be acquired
+func (tp *tieredPage) truncateAll() ([]*physicalPage, error) {
+	if err := tp.loadTree(); err != nil {
+		return nil, err
+	}
+
+	// Collect the pages and pageTables below the root
+	var pagesToFree []*physicalPage
+	var collect func(pt *pageTable)
+	collect = func(pt *pageTable) {
+		for _, child := range pt.childTables {
+			pagesToFree = append(pagesToFree, child.pp)
+			collect(child)
+		}
+		for _, page := range pt.childPages {
+			if page != nil {
+				pagesToFree = append(pagesToFree, page)
+			}
+		}
+	}
+	collect(tp.root)
+
+	// Persist the new size before the root is cleared. If the PageManager
+	// crashes in between, the pages that are still referenced by the root
+	// are recovered as unused pages like the ones kept by TrimToSize
+	tp.usedSize = 0
+	if err := writeTieredPageEntry(tp.pp, tp.root.height, 0, tp.root.pp.fileOff); err != nil {
+		return nil, err
+	}
+	tp.root.childTables = make(map[uint64]*pageTable)
+	tp.root.childPages = make(map[uint64]*physicalPage)
+	tp.pages = nil
+	tp.dirtyTables = nil
+	if err := tp.root.writeToDisk(); err != nil {
+		return nil, err
+	}
+
+	// Lower the empty root
+	pagesToFree2, err := tp.defrag()
+	if err != nil {
+		return nil, err
+	}
+	return append(pagesToFree, pagesToFree2...), nil
+}
+
 // recursiveTruncate is a helper function that recursively walks over the
-// allocated pages and deletes them until a certain size is reached
-func (tp *tieredPage) recursiveTruncate(pt *pageTable, size int64) (bool, []*physicalPage, error) {
+// allocated pages and deletes them until a certain size is reached. The
+// number of truncated bytes is reported to prog which might be nil
+func (tp *tieredPage) recursiveTruncate(pt *pageTable, size int64, prog *progress) (bool, []*physicalPage, error) {
 	var pagesToFree []*physicalPage
+	if tp.usedSize <= size {
+		return false, pagesToFree, nil
+	}
+
+	// Load the table if the tree was only partially recovered
+	if err := tp.loadTable(pt); err != nil {
+		return false, pagesToFree, err
+	}
+
 	// Call recursiveTruncate on child tables
 	if pt.height > 0 {
 		for i := uint64(len(pt.childTables)) - 1; i >= 0; i-- {
@@ -440,7 +1207,7 @@ func (tp *tieredPage) recursiveTruncate(pt *pageTable, size int64) (bool, []*phy
 			}
 
 			// Otherwise call truncate recursively
-			empty, freePages, err := tp.recursiveTruncate(pt.childTables[i], size)
+			empty, freePages, err := tp.recursiveTruncate(pt.childTables[i], size, prog)
 			if err != nil {
 				return false, pagesToFree, err
 			}
@@ -471,44 +1238,73 @@ func (tp *tieredPage) recursiveTruncate(pt *pageTable, size int64) (bool, []*phy
 
 	// Start removing pages
 	if pt.height == 0 {
+		modified := false
 		for i := uint64(len(pt.childPages)) - 1; i >= 0; i-- {
 			// Stop if entry is small enough
 			if tp.usedSize <= size {
-				return false, pagesToFree, nil
+				break
 			}
 			page := pt.childPages[i]
 
+			// A hole doesn't have a page but is treated like one
+			usedSize := tp.holeSize(int64(len(tp.pages)) - 1)
+			if page != nil {
+				usedSize = page.usedSize
+			}
+
 			// Check if we need to remove the whole page or if we can just
 			// truncate it
 			remainingTruncation := tp.usedSize - size
-			if remainingTruncation < page.usedSize {
-				page.usedSize = page.usedSize - remainingTruncation
+			if remainingTruncation < usedSize {
+				if page != nil {
+					page.usedSize = page.usedSize - remainingTruncation
+				}
 				tp.usedSize -= remainingTruncation
 				continue
 			}
 
 			// Remove the page from the entry's pages and the pageTable
 			delete(pt.childPages, i)
+			modified = true
 			removed := tp.pages[len(tp.pages)-1]
 			tp.pages = tp.pages[:len(tp.pages)-1]
 
 			// Sanity check. Removed pages should be the same
-			if removed.fileOff != page.fileOff {
-				panic(fmt.Sprintf("removed pages weren't the same %v != %v",
-					removed.fileOff, page.fileOff))
+			if removed != page {
+				return false, pagesToFree, sanityCheckFailed(tp.pm.opts.StrictMode, fmt.Sprintf("removed pages weren't the same %v != %v",
+					removed, page))
 			}
 
-			// add the page to pageToFree
-			pagesToFree = append(pagesToFree, page)
+			// add the page to pageToFree unless it is a hole
+			if page != nil {
+				pagesToFree = append(pagesToFree, page)
+			}
 
 			// Clear the removed page
-			tp.usedSize -= page.usedSize
+			tp.usedSize -= usedSize
+			if prog != nil {
+				prog.update(prog.total - (tp.usedSize - size))
+			}
 
-			// If the childTables are empty we can return right away
+			// If the childTables are empty we can return right away. The
+			// root isn't removed from the tree which is why it needs to be
+			// updated on disk
 			if len(pt.childPages) == 0 {
+				if pt.parent == nil {
+					if err := pt.writeToDisk(); err != nil {
+						return false, pagesToFree, err
+					}
+				}
 				return true, pagesToFree, nil
 			}
 		}
+
+		// Update pt on disk if pages were removed
+		if modified {
+			if err := pt.writeToDisk(); err != nil {
+				return false, pagesToFree, err
+			}
+		}
 		return false, pagesToFree, nil
 	}
 
@@ -516,11 +1312,12 @@ func (tp *tieredPage) recursiveTruncate(pt *pageTable, size int64) (bool, []*phy
 	panic("sanity check failed. height can't be a negative value.")
 }
 
-// unmarshalPageTable a pageTable
-func unmarshalPageTable(data []byte) (entries []int64, err error) {
+// unmarshalPageTable a pageTable. If strict is true, failed sanity checks
+// panic instead of returning an error
+func unmarshalPageTable(data []byte, strict bool) (entries []int64, err error) {
 	// The data should be at least 8 bytes long
 	if len(data) < 8 {
-		panic("input data is too shot")
+		return nil, sanityCheckFailed(strict, "input data is too shot")
 	}
 
 	// off is a offset used for unmarshaling the data
@@ -532,13 +1329,13 @@ func unmarshalPageTable(data []byte) (entries []int64, err error) {
 
 	// Sanity check numEntries
 	if numEntries > numPageEntries {
-		panic(fmt.Sprintf("Sanity check failed. numEntries(%v) > numPageEntries(%v)",
+		return nil, sanityCheckFailed(strict, fmt.Sprintf("Sanity check failed. numEntries(%v) > numPageEntries(%v)",
 			numEntries, numPageEntries))
 	}
 
 	// Sanity check the remaining data length
 	if uint64(len(data[off:])) < numEntries*8 {
-		panic(fmt.Sprintf("Sanity check failed. %v < %v", len(data[off:]), numEntries*8))
+		return nil, sanityCheckFailed(strict, fmt.Sprintf("Sanity check failed. %v < %v", len(data[off:]), numEntries*8))
 	}
 
 	// Unmarshal the entries
@@ -557,6 +1354,9 @@ func unmarshalPageTable(data []byte) (entries []int64, err error) {
 // writeTieredPageEntry writes the usedBytes of a pageTable and a ptr to the
 // pageTable at a specific offset in the entryPage
 func writeTieredPageEntry(pp *physicalPage, index int64, usedBytes int64, pageOff int64) error {
+	if err := checkSlotIndex(index); err != nil {
+		return err
+	}
 	data := make([]byte, tieredPageEntrySize)
 
 	// Marshal usedBytes and pageOff
@@ -569,3 +1369,414 @@ func writeTieredPageEntry(pp *physicalPage, index int64, usedBytes int64, pageOf
 	}
 	return nil
 }
+
+// writeGeneration writes the generation of a tieredPage to disk
+func writeGeneration(pp *physicalPage, generation uint64) error {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, generation)
+	if _, err := pp.writeAt(data, generationOff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readFreeListDirty reads the flag that indicates if the free pages of the
+// recycling page weren't persisted
+func readFreeListDirty(pp *physicalPage) (bool, error) {
+	data := make([]byte, 1)
+	if _, err := pp.readAt(data, freeListDirtyOff); err != nil {
+		return false, err
+	}
+	return data[0] == 1, nil
+}
+
+// writeFreeListDirty writes the flag that indicates if the free pages of the
+// recycling page weren't persisted
+func writeFreeListDirty(pp *physicalPage, dirty bool) error {
+	data := []byte{0}
+	if dirty {
+		data[0] = 1
+	}
+	if _, err := pp.writeAt(data, freeListDirtyOff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readTxnLog reads the Identifier of the entry that contains the log of a
+// committed transaction from the freePages entryPage
+func readTxnLog(pp *physicalPage) (Identifier, error) {
+	data := make([]byte, 8)
+	if _, err := pp.readAt(data, txnLogOff); err != nil {
+		return 0, err
+	}
+	return Identifier(binary.LittleEndian.Uint64(data)), nil
+}
+
+// writeTxnLog writes the Identifier of the entry that contains the log of a
+// committed transaction to the freePages entryPage
+func writeTxnLog(pp *physicalPage, id Identifier) error {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(id))
+	if _, err := pp.writeAt(data, txnLogOff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readIndexID reads the Identifier of the index entry from the freePages
+// entryPage
+func readIndexID(pp *physicalPage) (Identifier, error) {
+	data := make([]byte, 8)
+	if _, err := pp.readAt(data, indexOff); err != nil {
+		return 0, err
+	}
+	return Identifier(binary.LittleEndian.Uint64(data)), nil
+}
+
+// writeIndexID writes the Identifier of the index entry to the freePages
+// entryPage
+func writeIndexID(pp *physicalPage, id Identifier) error {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(id))
+	if _, err := pp.writeAt(data, indexOff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readReplaceLog reads the log of an unfinished Entry.Replace from the
+// freePages entryPage. slots is nil if the tree of the scratch entry wasn't
+// logged yet
+func readReplaceLog(pp *physicalPage) (id, scratchID Identifier, slots []byte, err error) {
+	data := make([]byte, replaceLogSize)
+	if _, err = pp.readAt(data, replaceLogOff); err != nil {
+		return
+	}
+	id = Identifier(binary.LittleEndian.Uint64(data[0:8]))
+	scratchID = Identifier(binary.LittleEndian.Uint64(data[8:16]))
+	if !isZero(data[16:]) {
+		slots = data[16:]
+	}
+	return
+}
+
+// writeReplaceLog writes the log of an unfinished Entry.Replace to the
+// freePages entryPage. Writing 0 Identifiers and nil slots clears it
+func writeReplaceLog(pp *physicalPage, id, scratchID Identifier, slots []byte) error {
+	data := make([]byte, replaceLogSize)
+	binary.LittleEndian.PutUint64(data[0:8], uint64(id))
+	binary.LittleEndian.PutUint64(data[8:16], uint64(scratchID))
+	copy(data[16:], slots)
+	if _, err := pp.writeAt(data, replaceLogOff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeContentHash writes the content hash of an entry to its entryPage.
+// Writing a nil hash invalidates the cached hash
+func writeContentHash(pp *physicalPage, generation uint64, hash []byte) error {
+	data := make([]byte, 8+sha256.Size)
+	if hash != nil {
+		binary.LittleEndian.PutUint64(data, generation)
+		copy(data[8:], hash)
+	}
+	if _, err := pp.writeAt(data, contentHashOff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readMaxSize reads the maximum size of the entry from the entryPage. It
+// returns 0 if no maximum size was set for the generation of the entry
+func readMaxSize(pp *physicalPage, generation uint64) (int64, error) {
+	data := make([]byte, 16)
+	if _, err := pp.readAt(data, maxSizeOff); err == io.EOF {
+		// The maximum size was never written
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if binary.LittleEndian.Uint64(data) != generation {
+		return 0, nil
+	}
+	return int64(binary.LittleEndian.Uint64(data[8:])), nil
+}
+
+// writeMaxSize writes the maximum size of the entry to the entryPage
+func writeMaxSize(pp *physicalPage, generation uint64, maxSize int64) error {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint64(data, generation)
+	binary.LittleEndian.PutUint64(data[8:], uint64(maxSize))
+	if _, err := pp.writeAt(data, maxSizeOff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readEntryMarker returns true if the marker of a live entry is stored on the
+// page
+func readEntryMarker(pp *physicalPage) (bool, error) {
+	data := make([]byte, 8)
+	if _, err := pp.readAt(data, entryMarkerOff); err != nil {
+		return false, err
+	}
+	return binary.LittleEndian.Uint64(data) == entryMarker^uint64(pp.fileOff), nil
+}
+
+// writeEntryMarker marks the page as the entryPage of a live entry or clears
+// the mark if live is false
+func writeEntryMarker(pp *physicalPage, live bool) error {
+	data := make([]byte, 8)
+	if live {
+		binary.LittleEndian.PutUint64(data, entryMarker^uint64(pp.fileOff))
+	}
+	if _, err := pp.writeAt(data, entryMarkerOff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readModTime reads the time of the last modification of the entry from the
+// entryPage. It returns 0 if it wasn't recorded for the generation of the
+// entry
+func readModTime(pp *physicalPage, generation uint64) (int64, error) {
+	data := make([]byte, 16)
+	if _, err := pp.readAt(data, modTimeOff); err == io.EOF {
+		// The modification time was never written
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if binary.LittleEndian.Uint64(data) != generation {
+		return 0, nil
+	}
+	return int64(binary.LittleEndian.Uint64(data[8:])), nil
+}
+
+// writeModTime writes the time of the last modification of the entry to the
+// entryPage
+func writeModTime(pp *physicalPage, generation uint64, modTime int64) error {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint64(data, generation)
+	binary.LittleEndian.PutUint64(data[8:], uint64(modTime))
+	if _, err := pp.writeAt(data, modTimeOff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readAttrs reads the attributes of the entry with the specified generation
+// from its entryPage. Attributes of a different generation belong to a
+// previous entry and are ignored
+func readAttrs(pp *physicalPage, generation uint64) (map[string][]byte, error) {
+	attrs := make(map[string][]byte)
+	data := make([]byte, pageSize-attrsOff)
+	if _, err := pp.readAt(data, attrsOff); err == io.EOF {
+		// The attributes were never written
+		return attrs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint64(data) != generation {
+		return attrs, nil
+	}
+	length := binary.LittleEndian.Uint32(data[8:])
+	if length > maxAttrsSize {
+		return nil, fmt.Errorf("attributes of size %v exceed the maximum of %v", length, maxAttrsSize)
+	}
+	data = data[12 : 12+length]
+	for len(data) > 0 {
+		var key, value []byte
+		var err error
+		if key, data, err = readAttrField(data); err != nil {
+			return nil, err
+		}
+		if value, data, err = readAttrField(data); err != nil {
+			return nil, err
+		}
+		attrs[string(key)] = value
+	}
+	return attrs, nil
+}
+
+// readAttrField reads a length-prefixed field of the marshalled attributes
+// and returns the remaining data
+func readAttrField(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("attribute field is missing its length")
+	}
+	length := int(binary.LittleEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < length {
+		return nil, nil, fmt.Errorf("attribute field of length %v exceeds the attributes", length)
+	}
+	field := append([]byte{}, data[:length]...)
+	return field, data[length:], nil
+}
+
+// marshalAttrs marshals the attributes of an entry sorted by their keys
+func marshalAttrs(attrs map[string][]byte) ([]byte, error) {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var data []byte
+	for _, key := range keys {
+		for _, field := range [][]byte{[]byte(key), attrs[key]} {
+			if len(field) > math.MaxUint16 {
+				return nil, ErrAttrsTooLarge
+			}
+			var length [2]byte
+			binary.LittleEndian.PutUint16(length[:], uint16(len(field)))
+			data = append(data, length[:]...)
+			data = append(data, field...)
+		}
+	}
+	if len(data) > maxAttrsSize {
+		return nil, ErrAttrsTooLarge
+	}
+	return data, nil
+}
+
+// writeAttrs writes the attributes of the entry with the specified generation
+// to its entryPage
+func writeAttrs(pp *physicalPage, generation uint64, attrs map[string][]byte) error {
+	attrData, err := marshalAttrs(attrs)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 12+len(attrData))
+	binary.LittleEndian.PutUint64(data, generation)
+	binary.LittleEndian.PutUint32(data[8:], uint32(len(attrData)))
+	copy(data[12:], attrData)
+	if _, err := pp.writeAt(data, attrsOff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// check verifies the invariants of the tree. It makes sure that the
+// pageTables don't contain gaps, that the heights of the pageTables decrease
+// by one per level, that all pages are within the bounds of the file and
+// that the usedSize is consistent with the number of pages. The whole tree
+// needs to be loaded and the mu read lock needs to be acquired
+func (tp *tieredPage) check(fileSize int64) error {
+	if tp.root.parent != nil {
+		return errors.New("root pageTable has a parent")
+	}
+	if tp.nextIndex() > uint64(len(tp.pages)) {
+		return fmt.Errorf("usedSize %v requires %v pages but there are %v",
+			tp.usedSize, tp.nextIndex(), len(tp.pages))
+	}
+	numPages, err := tp.checkTable(tp.root, 0, fileSize)
+	if err != nil {
+		return err
+	}
+	if numPages != uint64(len(tp.pages)) {
+		return fmt.Errorf("tree contains %v pages but there are %v", numPages, len(tp.pages))
+	}
+
+	// Only the last page might not be full. The pages after it were kept by
+	// TrimToSize and need to be unused
+	last := int(tp.nextIndex()) - 1
+	for i, page := range tp.pages {
+		if i < last && page != nil && page.usedSize != pageSize {
+			return fmt.Errorf("page %v isn't the last page but isn't full either", i)
+		}
+		if i > last && page != nil && page.usedSize != 0 {
+			return fmt.Errorf("page %v is beyond the end of the entry but is used", i)
+		}
+	}
+	return nil
+}
+
+// checkTable is a helper function for check that verifies the invariants of
+// a pageTable and its subtree. firstPage is the index of the first page of the
+// subtree. It returns the number of pages within the subtree
+func (tp *tieredPage) checkTable(pt *pageTable, firstPage uint64, fileSize int64) (uint64, error) {
+	if err := checkOffset(pt.pp.fileOff, fileSize); err != nil {
+		return 0, build.ExtendErr(fmt.Sprintf("pageTable at height %v is invalid", pt.height), err)
+	}
+	if pt.unloaded {
+		return 0, fmt.Errorf("pageTable at %v isn't loaded", pt.pp.fileOff)
+	}
+
+	// The lowest tables only point to pages
+	if pt.height == 0 {
+		if len(pt.childTables) > 0 {
+			return 0, fmt.Errorf("pageTable at %v has height 0 but points to pageTables", pt.pp.fileOff)
+		}
+		for i := uint64(0); i < uint64(len(pt.childPages)); i++ {
+			page, exists := pt.childPages[i]
+			if !exists {
+				return 0, fmt.Errorf("pageTable at %v has a gap at index %v", pt.pp.fileOff, i)
+			}
+			if page != nil {
+				if err := checkOffset(page.fileOff, fileSize); err != nil {
+					return 0, build.ExtendErr(fmt.Sprintf("page %v is invalid", firstPage+i), err)
+				}
+			}
+			if firstPage+i >= uint64(len(tp.pages)) || tp.pages[firstPage+i] != page {
+				return 0, fmt.Errorf("page %v doesn't match the pageTable at %v", firstPage+i, pt.pp.fileOff)
+			}
+		}
+		return uint64(len(pt.childPages)), nil
+	}
+
+	// Higher tables only point to tables with a height decreased by one
+	if len(pt.childPages) > 0 {
+		return 0, fmt.Errorf("pageTable at %v has height %v but points to pages", pt.pp.fileOff, pt.height)
+	}
+	var numPages uint64
+	for i := uint64(0); i < uint64(len(pt.childTables)); i++ {
+		child, exists := pt.childTables[i]
+		if !exists {
+			return 0, fmt.Errorf("pageTable at %v has a gap at index %v", pt.pp.fileOff, i)
+		}
+		if child.height != pt.height-1 {
+			return 0, fmt.Errorf("child of pageTable at %v should have height %v but has %v",
+				pt.pp.fileOff, pt.height-1, child.height)
+		}
+		if child.parent != pt {
+			return 0, fmt.Errorf("child of pageTable at %v has the wrong parent", pt.pp.fileOff)
+		}
+		n, err := tp.checkTable(child, firstPage+i*maxPages(child.height), fileSize)
+		if err != nil {
+			return 0, err
+		}
+
+		// Only the last child might not be full
+		if i < uint64(len(pt.childTables))-1 && n != maxPages(child.height) {
+			return 0, fmt.Errorf("child %v of pageTable at %v isn't the last child but isn't full either",
+				i, pt.pp.fileOff)
+		}
+		numPages += n
+	}
+	return numPages, nil
+}
+
+// checkOffset checks if a page at the specified offset is within the bounds
+// of a file with the specified size
+func checkOffset(fileOff int64, fileSize int64) error {
+	if fileOff < dataOff || fileOff%pageSize != 0 {
+		return fmt.Errorf("offset %v is not a valid page offset", fileOff)
+	}
+	if fileOff+pageSize > fileSize {
+		return fmt.Errorf("offset %v is beyond the end of the file", fileOff)
+	}
+	return nil
+}
+
+// checkSlotIndex makes sure that a slot index refers to one of the
+// numTreeSlots slots at the beginning of an entryPage. Larger indices would
+// overwrite the metadata that follows the slots or the next page on disk
+func checkSlotIndex(index int64) error {
+	if index < 0 || index >= numTreeSlots {
+		return fmt.Errorf("slot index %v is out of range [0, %v)", index, numTreeSlots)
+	}
+	return nil
+}
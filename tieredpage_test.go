@@ -1,6 +1,10 @@
 package pages
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
 	"testing"
 
 	"github.com/NebulousLabs/fastrand"
@@ -39,6 +43,53 @@ func TestWriteReadPageEntryPageEntry(t *testing.T) {
 
 }
 
+// TestWriteTieredPageEntryOutOfRange tests that slots outside of the slot array
+// of an entryPage are neither written nor read
+func TestWriteTieredPageEntryOutOfRange(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(pt)
+	}
+	defer pt.Close()
+
+	// Create an entry and remember its page
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(100)); err != nil {
+		t.Fatal(err)
+	}
+	before := make([]byte, pageSize)
+	if _, err := entry.ep.pp.readAt(before, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Out of range indices are rejected without touching the page. Index
+	// numTreeSlots would overwrite the generation and pageSize/16 the next
+	// page
+	for _, index := range []int64{-1, numTreeSlots, pageSize / tieredPageEntrySize} {
+		if err := writeTieredPageEntry(entry.ep.pp, index, 1, pageSize); err == nil {
+			t.Errorf("writing slot %v should fail", index)
+		}
+		if _, _, err := readEntryPageEntry(entry.ep.pp, index); err == nil {
+			t.Errorf("reading slot %v should fail", index)
+		}
+	}
+	after := make([]byte, pageSize)
+	if _, err := entry.ep.pp.readAt(after, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("entryPage was modified")
+	}
+
+	// The last slot can still be written
+	if err := writeTieredPageEntry(entry.ep.pp, numTreeSlots-1, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // TestAddPage tests if entryPage addPages works as expected
 func TestAddPage(t *testing.T) {
 	pt, err := newPagingTester(t.Name())
@@ -187,6 +238,70 @@ func TestFreePage(t *testing.T) {
 	}
 }
 
+// TestRecyclingPageDefrag tests if the height of the recycling page's tree
+// drops once its pages are reused and if the freed pageTables survive
+// reopening the PageManager
+func TestRecyclingPageDefrag(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := pt.pm.file.(*os.File).Name()
+
+	// Free enough pages to increase the height of the recycling page's tree
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(make([]byte, 2*numPageEntries*pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if pt.pm.freePages.root.height == 0 {
+		t.Fatal("Height of the recycling page's tree should have increased")
+	}
+
+	// Reuse all the free pages
+	entry2, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pt.pm.freePages.availablePages() > 0 {
+		if _, err := entry2.Write(make([]byte, pageSize)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pt.pm.freePages.root.height != 0 {
+		t.Errorf("Height of the recycling page's tree should be %v but was %v",
+			0, pt.pm.freePages.root.height)
+	}
+
+	// Free some pages and reopen the PageManager. The pageTables that were
+	// freed by the recycling page shouldn't be lost
+	if err := entry2.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	for len(pt.pm.freePages.pagesToFree) == 0 {
+		if _, err := pt.pm.freePages.freePage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	freePages := pt.pm.freePages.availablePages()
+	if err := pt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if pm.freePages.availablePages() != freePages {
+		t.Errorf("There should be %v free pages but there were %v", freePages, pm.freePages.availablePages())
+	}
+}
+
 // TestInsertPage tests the funtionality of the pageTable's InsertPage call
 func TestInsertPage(t *testing.T) {
 	// Get a paging tester
@@ -237,3 +352,451 @@ func TestInsertPage(t *testing.T) {
 		}
 	}
 }
+
+// TestInsertSparsePages tests if pages can be inserted at non-contiguous
+// indices with holes between them and if inserting a page with a gap fails
+func TestInsertSparsePages(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	pt.pm.opts.StrictMode = false
+
+	// Get a new entry
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert pages at non-contiguous indices and holes between them
+	indices := map[uint64]bool{0: true, 3: true, numPageEntries - 1: true, numPageEntries: true, 1100: true}
+	for i := uint64(0); i <= 1100; i++ {
+		var pp *physicalPage
+		if indices[i] {
+			pp, err = pt.pm.allocatePage()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := entry.ep.insertPage(i, pp); err != nil {
+			t.Fatalf("Inserting page %v failed: %v", i, err)
+		}
+	}
+
+	// Check the pages of the leaves
+	for i := uint64(0); i <= 1100; i++ {
+		leaf := entry.ep.root.childTables[childIndex(i, 1)]
+		page, exists := leaf.childPages[i%numPageEntries]
+		if !exists {
+			t.Fatalf("Page %v should exist", i)
+		}
+		if (page != nil) != indices[i] {
+			t.Fatalf("Page %v should be a hole: %v", i, !indices[i])
+		}
+	}
+
+	// Inserting a page that creates a gap should fail
+	if err := entry.ep.insertPage(1102, nil); err == nil {
+		t.Error("Inserting a page with a gap should fail")
+	}
+}
+
+// TestRecoverDuplicateOffset tests if recovering a pageTable that references
+// the same page twice fails
+func TestRecoverDuplicateOffset(t *testing.T) {
+	// Get a paging tester
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Get a new entry
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hand-build a table that points to the same page twice
+	table, err := newPageTable(0, nil, pt.pm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp, err := pt.pm.allocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.childPages[0] = pp
+	table.childPages[1] = pp
+	if err := table.writeToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recovering the table should fail
+	entry.ep.usedSize = 2 * pageSize
+	if err := entry.ep.recoverTree(table.pp.fileOff, 0); err == nil {
+		t.Error("Recovering a table with a duplicate offset should fail")
+	}
+
+	// Fix the table and try again
+	pp2, err := pt.pm.allocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.childPages[1] = pp2
+	if err := table.writeToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.ep.recoverTree(table.pp.fileOff, 0); err != nil {
+		t.Fatalf("Failed to recover tree: %v", err)
+	}
+	if entry.ep.root.childPages[0].fileOff != pp.fileOff || entry.ep.root.childPages[1].fileOff != pp2.fileOff {
+		t.Error("Recovered pages were assigned to the wrong indices")
+	}
+}
+
+// TestRecoverCycle tests if recovering a tree with a self-referential
+// pageTable fails instead of looping
+func TestRecoverCycle(t *testing.T) {
+	// Get a paging tester
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Get a new entry
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hand-build a table that points to itself
+	table, err := newPageTable(1, nil, pt.pm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.childTables[0] = table
+	if err := table.writeToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recovering the table should fail
+	entry.ep.usedSize = pageSize
+	if err := entry.ep.recoverTree(table.pp.fileOff, 1); err == nil {
+		t.Error("Recovering a self-referential table should fail")
+	}
+}
+
+// TestRecoverMaxNodes tests if recovery fails if the tree exceeds the
+// configured maximum number of pageTables
+func TestRecoverMaxNodes(t *testing.T) {
+	// Get a paging tester
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Get a new entry
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write enough data to create a tree consisting of 3 pageTables
+	if _, err := entry.Write(fastrand.Bytes((numPageEntries + 1) * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the entry should fail if we only allow for 2 tables
+	pt.pm.opts.MaxRecoveryNodes = 2
+	if _, err := pt.pm.Open(id); err == nil {
+		t.Error("Open should fail if the tree exceeds MaxRecoveryNodes")
+	}
+
+	// With 3 tables it should work
+	pt.pm.opts.MaxRecoveryNodes = 3
+	if _, err := pt.pm.Open(id); err != nil {
+		t.Errorf("Failed to open entry: %v", err)
+	}
+}
+
+// TestConcurrentRecovery tests if recovering a tree with multiple workers
+// results in the same tree as recovering it sequentially
+func TestConcurrentRecovery(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Get a new entry and grow it to a tree of height 1 with multiple
+	// children
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(4*numPageEntries*pageSize + 100)
+	if err := entry.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]*physicalPage(nil), entry.ep.pages...)
+
+	// Recover the tree concurrently and compare the pages
+	pt.pm.opts.RecoveryWorkers = 8
+	if err := entry.ep.recoverTree(entry.ep.root.pp.fileOff, entry.ep.root.height); err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.ep.pages) != len(expected) {
+		t.Fatalf("Recovered tree should have %v pages but had %v", len(expected), len(entry.ep.pages))
+	}
+	for i, page := range entry.ep.pages {
+		if page.fileOff != expected[i].fileOff || page.usedSize != expected[i].usedSize {
+			t.Fatalf("Page %v should be %v but was %v", i, *expected[i], *page)
+		}
+	}
+	if len(entry.ep.root.childTables) != 5 {
+		t.Errorf("Root should have %v children but had %v", 5, len(entry.ep.root.childTables))
+	}
+	if err := entry.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tableWrites is a helper function that returns the number of writes to the
+// pageTables of a tree
+func tableWrites(pt *pageTable, cf *countingFile) int {
+	writes := cf.writes[pt.pp.fileOff]
+	for _, child := range pt.childTables {
+		writes += tableWrites(child, cf)
+	}
+	return writes
+}
+
+// writeMB is a helper function that writes 1MB to a new entry with table
+// writes coalesced or not and returns the number of pageTable writes
+func writeMB(pt *pagingTester, coalesce bool) (int, error) {
+	pt.pm.opts.CoalesceTableWrites = coalesce
+	cf := &countingFile{
+		backingFile: pt.pm.file,
+		writes:      make(map[int64]int),
+	}
+	pt.pm.file = cf
+	defer func() {
+		pt.pm.file = cf.backingFile
+	}()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		return 0, err
+	}
+	defer entry.Close()
+	if _, err := entry.Write(fastrand.Bytes(1 << 20)); err != nil {
+		return 0, err
+	}
+	return tableWrites(entry.ep.root, cf), nil
+}
+
+// TestCoalesceTableWrites tests if coalescing pageTable writes writes each
+// pageTable only once per write
+func TestCoalesceTableWrites(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Without coalescing the table is written once per page. Zeroing the
+	// table's page during allocation is another write
+	writes, err := writeMB(pt, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writes != (1<<20)/pageSize+1 {
+		t.Errorf("There should be %v table writes but there were %v", (1<<20)/pageSize+1, writes)
+	}
+
+	// With coalescing it is written only once
+	writes, err = writeMB(pt, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writes != 2 {
+		t.Errorf("There should be %v table writes but there were %v", 2, writes)
+	}
+}
+
+// BenchmarkTableWrites benchmarks writing 1MB to an entry with and without
+// coalescing pageTable writes
+func BenchmarkTableWrites(b *testing.B) {
+	for _, coalesce := range []bool{false, true} {
+		b.Run(fmt.Sprintf("coalesce=%v", coalesce), func(b *testing.B) {
+			pt, err := newPagingTester(b.Name())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer pt.Close()
+
+			writes := 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n, err := writeMB(pt, coalesce)
+				if err != nil {
+					b.Fatal(err)
+				}
+				writes += n
+			}
+			b.ReportMetric(float64(writes)/float64(b.N), "tableWrites/op")
+		})
+	}
+}
+
+// BenchmarkRecovery benchmarks the recovery of a 100k page entry with and
+// without concurrent workers
+func BenchmarkRecovery(b *testing.B) {
+	pt, err := newPagingTester(b.Name())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create the entry
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := entry.Truncate(100000 * pageSize); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, workers := range []int{0, 8} {
+		b.Run(fmt.Sprintf("workers=%v", workers), func(b *testing.B) {
+			pt.pm.opts.RecoveryWorkers = workers
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := entry.ep.recoverTree(entry.ep.root.pp.fileOff, entry.ep.root.height); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkTailReadAt benchmarks reading the last page of a lazily opened 1M
+// page entry. Only the pageTables on the path to the last page should be
+// loaded
+func BenchmarkTailReadAt(b *testing.B) {
+	pt, err := newPagingTester(b.Name())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pt.Close()
+	pt.pm.opts.SparseWrites = true
+
+	// Create a sparse entry and write its last page
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		b.Fatal(err)
+	}
+	size := int64(1000000 * pageSize)
+	data := fastrand.Bytes(pageSize)
+	if _, err := entry.WriteAt(data, size-pageSize); err != nil {
+		b.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	// Reopen the entry without loading its tree
+	pt.pm.opts.LazyOpen = true
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer entry.Close()
+
+	readData := make([]byte, pageSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := entry.ReadAt(readData, size-int64(len(readData))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	if !bytes.Equal(readData, data) {
+		b.Fatal("Read data doesn't match written data")
+	}
+
+	// Count the loaded pageTables
+	var loaded func(pt *pageTable) int
+	loaded = func(pt *pageTable) int {
+		if pt.unloaded {
+			return 0
+		}
+		n := 1
+		for _, child := range pt.childTables {
+			n += loaded(child)
+		}
+		return n
+	}
+	if n := loaded(entry.ep.root); int64(n) > entry.ep.root.height+1 {
+		b.Fatalf("Only %v pageTables should be loaded but were %v", entry.ep.root.height+1, n)
+	}
+}
+
+// BenchmarkSequentialRead benchmarks reading a 10k page entry sequentially
+// with and without separate metadata. The fraction of data pages that follow
+// their predecessor on disk is reported as contiguous
+func BenchmarkSequentialRead(b *testing.B) {
+	for _, separate := range []bool{false, true} {
+		b.Run(fmt.Sprintf("separate=%v", separate), func(b *testing.B) {
+			pt, err := newPagingTester(b.Name())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer pt.Close()
+			pt.pm.opts.SeparateMetadata = separate
+
+			// Write the entry in small chunks to interleave the allocations
+			// of data pages and pageTables
+			entry, _, err := pt.pm.Create()
+			if err != nil {
+				b.Fatal(err)
+			}
+			chunk := fastrand.Bytes(10 * pageSize)
+			for i := 0; i < 1000; i++ {
+				if _, err := entry.Write(chunk); err != nil {
+					b.Fatal(err)
+				}
+			}
+			contiguous := 0
+			for i := 1; i < len(entry.ep.pages); i++ {
+				if entry.ep.pages[i].fileOff == entry.ep.pages[i-1].fileOff+pageSize {
+					contiguous++
+				}
+			}
+
+			data := make([]byte, 64*pageSize)
+			b.SetBytes(int64(len(entry.ep.pages)) * pageSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := entry.Seek(0, io.SeekStart); err != nil {
+					b.Fatal(err)
+				}
+				for {
+					if _, err := entry.Read(data); err == io.EOF {
+						break
+					} else if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(contiguous)/float64(len(entry.ep.pages)-1), "contiguous")
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package pages
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+const (
+	// txnHeaderSize is the size of the header of a marshalled write of a
+	// transaction
+	txnHeaderSize = 25
+)
+
+var (
+	// ErrTxnDone is returned if a transaction is used after it was committed
+	// or rolled back
+	ErrTxnDone = errors.New("transaction was already committed or rolled back")
+)
+
+type (
+	// Txn buffers writes to multiple entries until they are committed
+	// together. Either all or none of the writes of a committed transaction
+	// survive a crash
+	Txn struct {
+		pm     *PageManager
+		writes []txnWrite
+		done   bool
+	}
+
+	// TxnEntry is an entry whose writes are buffered by a transaction
+	TxnEntry struct {
+		txn *Txn
+		id  Identifier
+	}
+
+	// txnWrite is a buffered write of a transaction. If truncate is true,
+	// the entry is truncated to off bytes instead
+	txnWrite struct {
+		id       Identifier
+		off      int64
+		data     []byte
+		truncate bool
+	}
+)
+
+// Begin starts a new transaction
+func (p *PageManager) Begin() *Txn {
+	return &Txn{pm: p}
+}
+
+// Entry returns the entry with the specified Identifier scoped to the
+// transaction. ErrNotFound is returned if id doesn't belong to an entry
+func (txn *Txn) Entry(id Identifier) (*TxnEntry, error) {
+	if txn.done {
+		return nil, ErrTxnDone
+	}
+	if !txn.pm.Exists(id) {
+		return nil, ErrNotFound
+	}
+	return &TxnEntry{txn: txn, id: id}, nil
+}
+
+// WriteAt buffers a write of p at off until the transaction is committed
+func (te *TxnEntry) WriteAt(p []byte, off int64) (int, error) {
+	if te.txn.done {
+		return 0, ErrTxnDone
+	}
+	if off < 0 {
+		return 0, errors.New("Cannot write at negative offset")
+	}
+	te.txn.writes = append(te.txn.writes, txnWrite{
+		id:   te.id,
+		off:  off,
+		data: append([]byte(nil), p...),
+	})
+	return len(p), nil
+}
+
+// Truncate buffers changing the size of the entry to size bytes until the
+// transaction is committed. The pages that are freed by the truncations of a
+// transaction are added to the free pages at once
+func (te *TxnEntry) Truncate(size int64) error {
+	if te.txn.done {
+		return ErrTxnDone
+	}
+	if size < 0 {
+		return errors.New("Cannot truncate entry to negative size")
+	}
+	te.txn.writes = append(te.txn.writes, txnWrite{
+		id:       te.id,
+		off:      size,
+		truncate: true,
+	})
+	return nil
+}
+
+// TruncateAll changes the size of the entries with the specified Identifiers
+// to size bytes within a single transaction. Either all or none of the
+// entries are truncated if the PageManager crashes
+func (p *PageManager) TruncateAll(ids []Identifier, size int64) error {
+	txn := p.Begin()
+	for _, id := range ids {
+		te, err := txn.Entry(id)
+		if err != nil {
+			txn.Rollback()
+			return build.ExtendErr(fmt.Sprintf("failed to add entry %v to transaction", id), err)
+		}
+		if err := te.Truncate(size); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// Rollback discards the buffered writes of the transaction
+func (txn *Txn) Rollback() error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	txn.done = true
+	txn.writes = nil
+	return nil
+}
+
+// Commit applies the buffered writes of the transaction. The writes are
+// written to a log entry first which is synced together with a reference to it
+// before the writes are applied. If the PageManager crashes before the
+// reference is persisted, none of the writes are applied. Otherwise the log is
+// replayed when the PageManager is recovered
+func (txn *Txn) Commit() error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	txn.done = true
+	if len(txn.writes) == 0 {
+		return nil
+	}
+	p := txn.pm
+	p.txnMu.Lock()
+	defer p.txnMu.Unlock()
+
+	// Open the entries before anything is logged
+	entries := make(map[Identifier]*Entry)
+	defer func() {
+		for _, entry := range entries {
+			entry.Close()
+		}
+	}()
+	for _, w := range txn.writes {
+		if _, exists := entries[w.id]; exists {
+			continue
+		}
+		entry, err := p.Open(w.id)
+		if err != nil {
+			return build.ExtendErr(fmt.Sprintf("failed to open entry %v", w.id), err)
+		}
+		entries[w.id] = entry
+	}
+
+	// Write the log to a new entry and persist it
+	logEntry, logID, err := p.Create()
+	if err != nil {
+		return build.ExtendErr("failed to create log entry", err)
+	}
+	if _, err := logEntry.Write(marshalTxnWrites(txn.writes)); err != nil {
+		logEntry.Close()
+		p.Delete(logID)
+		return build.ExtendErr("failed to write log", err)
+	}
+	if err := logEntry.Flush(); err != nil {
+		logEntry.Close()
+		p.Delete(logID)
+		return build.ExtendErr("failed to flush log", err)
+	}
+	logEntry.Close()
+
+	// Commit the transaction by persisting the reference to the log
+	if err := writeTxnLog(p.freePages.pp, logID); err != nil {
+		return build.ExtendErr("failed to write reference to log", err)
+	}
+	if err := p.file.Sync(); err != nil {
+		return build.ExtendErr("failed to commit transaction", err)
+	}
+
+	// Apply the writes. If this fails, the log is replayed on recovery
+	return p.applyTxnLog(logID, txn.writes, entries)
+}
+
+// applyTxnLog applies the writes of a committed transaction to the entries,
+// syncs them and deletes the log entry afterwards
+func (p *PageManager) applyTxnLog(logID Identifier, writes []txnWrite, entries map[Identifier]*Entry) error {
+	var pagesToFree []*physicalPage
+	for _, w := range writes {
+		if w.truncate {
+			freed, err := entries[w.id].managedResize(w.off)
+			if err != nil {
+				return build.ExtendErr(fmt.Sprintf("failed to truncate entry %v", w.id), err)
+			}
+			pagesToFree = append(pagesToFree, freed...)
+			continue
+		}
+		if _, err := entries[w.id].WriteAt(w.data, w.off); err != nil {
+			return build.ExtendErr(fmt.Sprintf("failed to apply write to entry %v", w.id), err)
+		}
+	}
+
+	// Free the pages of all truncations at once
+	if err := p.managedAddFreePages(pagesToFree); err != nil {
+		return build.ExtendErr("failed to free truncated pages", err)
+	}
+	for _, entry := range entries {
+		if err := entry.Flush(); err != nil {
+			return build.ExtendErr("failed to flush entry", err)
+		}
+	}
+
+	// Remove the reference to the log before it is deleted to not replay a
+	// log whose pages were reused
+	if err := writeTxnLog(p.freePages.pp, 0); err != nil {
+		return build.ExtendErr("failed to clear reference to log", err)
+	}
+	if err := p.file.Sync(); err != nil {
+		return build.ExtendErr("failed to sync file", err)
+	}
+	return p.Delete(logID)
+}
+
+// replayTxnLog applies the writes of a transaction that was committed but
+// maybe not applied before the PageManager was closed
+func (p *PageManager) replayTxnLog() error {
+	logID, err := readTxnLog(p.freePages.pp)
+	if err != nil {
+		return build.ExtendErr("failed to read reference to log", err)
+	}
+	if logID == 0 {
+		return nil
+	}
+	p.opts.Logger.Info("replaying transaction log %v", logID)
+
+	// Read the log
+	logEntry, err := p.Open(logID)
+	if err != nil {
+		return build.ExtendErr("failed to open log entry", err)
+	}
+	size, err := logEntry.Size()
+	if err != nil {
+		logEntry.Close()
+		return err
+	}
+	data := make([]byte, size)
+	if _, err := logEntry.ReadAt(data, 0); err != nil && err != io.EOF {
+		logEntry.Close()
+		return build.ExtendErr("failed to read log", err)
+	}
+	logEntry.Close()
+	writes, err := unmarshalTxnWrites(data)
+	if err != nil {
+		return build.ExtendErr("failed to unmarshal log", err)
+	}
+
+	// Apply the writes again
+	entries := make(map[Identifier]*Entry)
+	defer func() {
+		for _, entry := range entries {
+			entry.Close()
+		}
+	}()
+	for _, w := range writes {
+		if _, exists := entries[w.id]; exists {
+			continue
+		}
+		entry, err := p.Open(w.id)
+		if err != nil {
+			return build.ExtendErr(fmt.Sprintf("failed to open entry %v", w.id), err)
+		}
+		entries[w.id] = entry
+	}
+	return p.applyTxnLog(logID, writes, entries)
+}
+
+// marshalTxnWrites marshals the writes of a transaction. Every write is stored
+// as the Identifier of the entry, the offset, the length of the data and a
+// flag that marks truncations followed by the data
+func marshalTxnWrites(writes []txnWrite) []byte {
+	var data []byte
+	header := make([]byte, txnHeaderSize)
+	for _, w := range writes {
+		binary.LittleEndian.PutUint64(header[0:8], uint64(w.id))
+		binary.LittleEndian.PutUint64(header[8:16], uint64(w.off))
+		binary.LittleEndian.PutUint64(header[16:24], uint64(len(w.data)))
+		header[24] = 0
+		if w.truncate {
+			header[24] = 1
+		}
+		data = append(data, header...)
+		data = append(data, w.data...)
+	}
+	return data
+}
+
+// unmarshalTxnWrites unmarshals the writes of a transaction
+func unmarshalTxnWrites(data []byte) ([]txnWrite, error) {
+	var writes []txnWrite
+	for len(data) > 0 {
+		if len(data) < txnHeaderSize {
+			return nil, errors.New("log is too short for the header of a write")
+		}
+		w := txnWrite{
+			id:       Identifier(binary.LittleEndian.Uint64(data[0:8])),
+			off:      int64(binary.LittleEndian.Uint64(data[8:16])),
+			truncate: data[24] == 1,
+		}
+		length := binary.LittleEndian.Uint64(data[16:24])
+		data = data[txnHeaderSize:]
+		if uint64(len(data)) < length {
+			return nil, fmt.Errorf("log is too short for a write of %v bytes", length)
+		}
+		w.data = data[:length]
+		data = data[length:]
+		writes = append(writes, w)
+	}
+	return writes, nil
+}
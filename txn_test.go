@@ -0,0 +1,231 @@
+package pages
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestTxnCommit tests if the writes of a committed transaction are applied to
+// all of its entries or none of them if the PageManager crashes during the
+// commit
+func TestTxnCommit(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	path := pt.pm.file.(*os.File).Name()
+	cf := &crashFile{backingFile: pt.pm.file}
+	pt.pm.file = cf
+
+	// Create two entries and persist their initial data
+	var ids []Identifier
+	var oldData, newData [][]byte
+	for i := 0; i < 2; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := fastrand.Bytes(pageSize + 10)
+		if _, err := entry.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		oldData = append(oldData, data)
+
+		// The transaction overwrites the end of the data and extends it
+		updated := append([]byte(nil), data...)
+		updated = append(updated[:100], fastrand.Bytes(3*pageSize)...)
+		newData = append(newData, updated)
+	}
+
+	// Write to both entries within a transaction and commit it
+	synced := len(cf.history)
+	txn := pt.pm.Begin()
+	for i, id := range ids {
+		te, err := txn.Entry(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := te.WriteAt(newData[i][100:], 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing is applied before the commit
+	readEntry := func(pm *PageManager, id Identifier) []byte {
+		entry, err := pm.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer entry.Close()
+		size, err := entry.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, size)
+		if _, err := entry.ReadAt(data, 0); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	if !bytes.Equal(readEntry(pt.pm, ids[0]), oldData[0]) {
+		t.Fatal("Writes shouldn't be applied before the commit")
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		if !bytes.Equal(readEntry(pt.pm, id), newData[i]) {
+			t.Fatalf("Entry %v doesn't contain the committed data", i)
+		}
+	}
+	if err := txn.Commit(); err != ErrTxnDone {
+		t.Errorf("Second commit should return %v but was %v", ErrTxnDone, err)
+	}
+
+	// Simulate a crash at every sync of the commit. Either both entries
+	// contain the old data or both contain the new data
+	var numOld, numNew int
+	for i, contents := range cf.history[synced:] {
+		crashedPath := fmt.Sprintf("%v.crashed%v", path, i)
+		if err := ioutil.WriteFile(crashedPath, contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+		pm, err := New(crashedPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data0, data1 := readEntry(pm, ids[0]), readEntry(pm, ids[1])
+		switch {
+		case bytes.Equal(data0, oldData[0]) && bytes.Equal(data1, oldData[1]):
+			numOld++
+		case bytes.Equal(data0, newData[0]) && bytes.Equal(data1, newData[1]):
+			numNew++
+		default:
+			t.Errorf("Crash at sync %v partially applied the transaction", i)
+		}
+		if logID, err := readTxnLog(pm.freePages.pp); err != nil || logID != 0 {
+			t.Errorf("Log should be cleared after the recovery but was %v %v", logID, err)
+		}
+		if err := pm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if numOld == 0 || numNew == 0 {
+		t.Errorf("Crashes should recover the old and the new data but recovered %v old and %v new", numOld, numNew)
+	}
+}
+
+// TestTxnRollback tests if a rolled back transaction discards its writes
+func TestTxnRollback(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+
+	txn := pt.pm.Begin()
+	te, err := txn.Entry(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := te.WriteAt(fastrand.Bytes(pageSize), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != ErrTxnDone {
+		t.Errorf("Commit should return %v but was %v", ErrTxnDone, err)
+	}
+	if _, err := te.WriteAt([]byte{1}, 0); err != ErrTxnDone {
+		t.Errorf("WriteAt should return %v but was %v", ErrTxnDone, err)
+	}
+	size, err := entry.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 0 {
+		t.Errorf("Rolled back writes shouldn't be applied but entry has %v bytes", size)
+	}
+}
+
+// TestTruncateAll tests if multiple entries are truncated at once and if their
+// pages are freed
+func TestTruncateAll(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create three entries with 5 pages of data each
+	var ids []Identifier
+	var datas [][]byte
+	for i := 0; i < 3; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := fastrand.Bytes(5 * pageSize)
+		if _, err := entry.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		datas = append(datas, data)
+	}
+
+	// Truncating a missing entry shouldn't truncate any of the others
+	if err := pt.pm.TruncateAll(append(ids, 1234), pageSize+10); err == nil {
+		t.Fatal("Truncating a missing entry should fail")
+	}
+	freePages := pt.pm.freePages.availablePages()
+
+	// Truncate all entries to 2 pages
+	if err := pt.pm.TruncateAll(ids, pageSize+10); err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		entry, err := pt.pm.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, datas[i][:pageSize+10]) {
+			t.Errorf("Entry %v should have %v bytes but had %v", i, pageSize+10, len(data))
+		}
+	}
+
+	// Every entry frees 3 data pages. The entryPage, root and data page of
+	// the deleted log are freed as well
+	if pt.pm.freePages.availablePages() != freePages+3*3+3 {
+		t.Errorf("There should be %v free pages but there were %v", freePages+3*3+3, pt.pm.freePages.availablePages())
+	}
+}
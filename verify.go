@@ -0,0 +1,257 @@
+package pages
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+// VerifyError is returned by OpenVerified if the file contains problems
+type VerifyError struct {
+	// Problems are the problems that were found by Verify
+	Problems []error
+}
+
+// Error returns the problems of the VerifyError
+func (ve *VerifyError) Error() string {
+	problems := make([]string, 0, len(ve.Problems))
+	for _, problem := range ve.Problems {
+		problems = append(problems, problem.Error())
+	}
+	return fmt.Sprintf("found %v problems: %v", len(ve.Problems), strings.Join(problems, "; "))
+}
+
+// OpenVerified recovers an existing PageManager and verifies the structure of
+// the free pages and of the entries with the specified Identifiers. If any
+// problems are found, no PageManager is returned and the error is a
+// *VerifyError containing the problems. Failed sanity checks are reported as
+// problems instead of causing a panic
+func OpenVerified(filePath string, ids []Identifier) (*PageManager, error) {
+	return OpenVerifiedWithOptions(filePath, DefaultOptions(), ids)
+}
+
+// OpenVerifiedWithOptions is like OpenVerified but uses the specified
+// options. StrictMode is always disabled
+func OpenVerifiedWithOptions(filePath string, opts Options, ids []Identifier) (*PageManager, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return nil, build.ExtendErr("failed to find database file", err)
+	}
+	opts.StrictMode = false
+	pm, err := NewWithOptions(filePath, opts)
+	if err != nil {
+		return nil, &VerifyError{Problems: []error{err}}
+	}
+	if problems := pm.Verify(ids); len(problems) > 0 {
+		pm.Close()
+		return nil, &VerifyError{Problems: problems}
+	}
+	return pm, nil
+}
+
+// Verify checks the pageTable trees of the free pages and of the entries with
+// the specified Identifiers and makes sure that no page is used twice. The
+// entries of the index used by Put and Get are verified as well. It returns
+// all the problems that were found. Entries can't be open while they are
+// verified
+func (p *PageManager) Verify(ids []Identifier) []error {
+	p.indexMu.Lock()
+	defer p.indexMu.Unlock()
+	ids, indexErr := p.withIndexIDs(ids)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := p.openEntries(); n > 0 {
+		return []error{fmt.Errorf("can't verify entries while %v entries are open", n)}
+	}
+	stat, err := p.file.Stat()
+	if err != nil {
+		return []error{build.ExtendErr("failed to get size of file", err)}
+	}
+
+	// Remember the owner of every page to find pages that are used twice
+	var problems []error
+	if indexErr != nil {
+		problems = append(problems, indexErr)
+	}
+	owners := make(map[int64]string)
+	use := func(off int64, owner string) {
+		if prev, used := owners[off]; used {
+			problems = append(problems, fmt.Errorf("page at %v is used by %v and %v", off, prev, owner))
+			return
+		}
+		owners[off] = owner
+	}
+	useTree := func(tp *tieredPage, owner string) {
+		var useTables func(pt *pageTable)
+		useTables = func(pt *pageTable) {
+			use(pt.pp.fileOff, owner)
+			for _, child := range pt.childTables {
+				useTables(child)
+			}
+		}
+		if tp.root != nil {
+			useTables(tp.root)
+		}
+		for _, page := range tp.pages {
+			if page != nil {
+				use(page.fileOff, owner)
+			}
+		}
+	}
+
+	// Verify the free pages
+	if err := p.freePages.check(stat.Size()); err != nil {
+		problems = append(problems, build.ExtendErr("free pages are corrupt", err))
+	}
+	useTree(p.freePages.tieredPage, "the free pages")
+	for _, page := range p.freePages.pagesToFree {
+		use(page.fileOff, "the free pages")
+	}
+	for _, page := range p.metaPages {
+		use(page.fileOff, "the metadata extent")
+	}
+	for _, page := range p.gapPages {
+		use(page.fileOff, "the alignment gaps")
+	}
+	for _, page := range p.dirPages {
+		use(page.fileOff, "the directory")
+	}
+	for _, page := range p.zeroPages {
+		use(page.fileOff, "the zero pool")
+	}
+	for _, page := range p.reservedZeroPages {
+		use(page.fileOff, "the zero pool")
+	}
+
+	// Verify the entries. Their pages are collected in order of the ids to
+	// report pages that are used twice deterministically
+	eps, entryProblems := p.checkEntries(ids, stat.Size())
+	for i, id := range ids {
+		if entryProblems[i] != nil {
+			problems = append(problems, entryProblems[i])
+		}
+		if eps[i] == nil {
+			continue
+		}
+		owner := fmt.Sprintf("entry %v", id)
+		use(eps[i].pp.fileOff, owner)
+		useTree(eps[i].tieredPage, owner)
+	}
+	return problems
+}
+
+// checkEntries loads and checks the entries with the specified Identifiers.
+// Up to VerifyWorkers entries are loaded and checked concurrently. The
+// entryPages of the entries that could be loaded and their problems are
+// returned in order of the ids. The p.mu lock needs to be acquired
+func (p *PageManager) checkEntries(ids []Identifier, fileSize int64) ([]*entryPage, []error) {
+	eps := make([]*entryPage, len(ids))
+	problems := make([]error, len(ids))
+
+	// Reading an entryPage doesn't allocate pages which is why it's safe to
+	// do concurrently. Reserved entries don't have a root yet
+	check := func(i int) {
+		ep, err := p.peekEntryPage(ids[i], false)
+		if err != nil {
+			problems[i] = build.ExtendErr(fmt.Sprintf("failed to load entry %v", ids[i]), err)
+			return
+		}
+		eps[i] = ep
+		if ep.root == nil {
+			return
+		}
+		if err := ep.check(fileSize); err != nil {
+			problems[i] = build.ExtendErr(fmt.Sprintf("entry %v is corrupt", ids[i]), err)
+		}
+	}
+	workers := p.opts.VerifyWorkers
+	if workers > len(ids) {
+		workers = len(ids)
+	}
+	if workers > 1 {
+		indices := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range indices {
+					check(i)
+				}
+			}()
+		}
+		for i := range ids {
+			indices <- i
+		}
+		close(indices)
+		wg.Wait()
+	} else {
+		for i := range ids {
+			check(i)
+		}
+	}
+	return eps, problems
+}
+
+// VerifyFreeListDisjoint returns the sorted offsets of the pages that are free
+// but also used by the entries with the specified Identifiers or by the
+// pageTables of the free pages. Such a page was freed twice or while it was
+// still in use and would be handed out a second time. This is a cheaper
+// subset of Verify. Entries can't be open while the pages are compared
+func (p *PageManager) VerifyFreeListDisjoint(ids []Identifier) ([]int64, error) {
+	p.indexMu.Lock()
+	defer p.indexMu.Unlock()
+	ids, err := p.withIndexIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := p.openEntries(); n > 0 {
+		return nil, fmt.Errorf("can't verify free pages while %v entries are open", n)
+	}
+
+	// Collect the free pages
+	free := make(map[int64]struct{})
+	for _, page := range p.freePages.pages {
+		free[page.fileOff] = struct{}{}
+	}
+	for _, page := range p.freePages.pagesToFree {
+		free[page.fileOff] = struct{}{}
+	}
+
+	// Collect the live pages
+	used := make(map[int64]struct{})
+	used[p.freePages.pp.fileOff] = struct{}{}
+	markTables(p.freePages.root, used)
+	for _, page := range p.dirPages {
+		used[page.fileOff] = struct{}{}
+	}
+	for _, id := range ids {
+		ep, err := p.peekEntryPage(id, false)
+		if err != nil {
+			return nil, build.ExtendErr(fmt.Sprintf("failed to load entry %v", id), err)
+		}
+		used[ep.pp.fileOff] = struct{}{}
+		markTables(ep.root, used)
+		for _, page := range ep.pages {
+			if page != nil {
+				used[page.fileOff] = struct{}{}
+			}
+		}
+	}
+
+	var overlap []int64
+	for off := range free {
+		if _, isUsed := used[off]; isUsed {
+			overlap = append(overlap, off)
+		}
+	}
+	sort.Slice(overlap, func(i, j int) bool {
+		return overlap[i] < overlap[j]
+	})
+	return overlap, nil
+}
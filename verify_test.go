@@ -0,0 +1,300 @@
+package pages
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestOpenVerified tests if OpenVerified returns the problems of a corrupted
+// file instead of a PageManager
+func TestOpenVerified(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := pt.pm.file.(*os.File).Name()
+
+	// Create an entry with 3 pages
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(3 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	rootOff := entry.ep.root.pp.fileOff
+	if err := pt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The intact file should be verified
+	pm, err := OpenVerified(path, []Identifier{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Point the second page of the entry to a page beyond the end of the
+	// file
+	file, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := make([]byte, 8)
+	binary.PutVarint(offset, stat.Size()+10*pageSize)
+	if _, err := file.WriteAt(offset, rootOff+16); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// OpenVerified should return the problem
+	pm, err = OpenVerified(path, []Identifier{id})
+	if pm != nil {
+		pm.Close()
+		t.Fatal("OpenVerified shouldn't return a PageManager for a corrupted file")
+	}
+	verifyErr, ok := err.(*VerifyError)
+	if !ok {
+		t.Fatalf("error should be a VerifyError but was %v", err)
+	}
+	if len(verifyErr.Problems) == 0 {
+		t.Fatal("VerifyError should contain problems")
+	}
+}
+
+// TestOpenVerifiedWorkers tests if OpenVerifiedWithOptions reports the
+// corrupt entry among several entries when they are checked concurrently
+func TestOpenVerifiedWorkers(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := pt.pm.file.(*os.File).Name()
+
+	// Create some entries with 3 pages
+	var ids []Identifier
+	var rootOffs []int64
+	for i := 0; i < 10; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write(fastrand.Bytes(3 * pageSize)); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		rootOffs = append(rootOffs, entry.ep.root.pp.fileOff)
+	}
+	if err := pt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.VerifyWorkers = 4
+
+	// The intact file should be verified
+	pm, err := OpenVerifiedWithOptions(path, opts, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Point the second page of one entry beyond the end of the file
+	corrupt := 6
+	file, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := make([]byte, 8)
+	binary.PutVarint(offset, stat.Size()+10*pageSize)
+	if _, err := file.WriteAt(offset, rootOffs[corrupt]+16); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the corrupt entry should be reported
+	pm, err = OpenVerifiedWithOptions(path, opts, ids)
+	if pm != nil {
+		pm.Close()
+		t.Fatal("OpenVerified shouldn't return a PageManager for a corrupted file")
+	}
+	verifyErr, ok := err.(*VerifyError)
+	if !ok {
+		t.Fatalf("error should be a VerifyError but was %v", err)
+	}
+	if len(verifyErr.Problems) != 1 {
+		t.Fatalf("There should be 1 problem but there were %v", verifyErr.Problems)
+	}
+	if !strings.Contains(verifyErr.Problems[0].Error(), fmt.Sprintf("entry %v", ids[corrupt])) {
+		t.Errorf("Problem should be about entry %v but was %v", ids[corrupt], verifyErr.Problems[0])
+	}
+}
+
+// BenchmarkVerify benchmarks verifying many entries with and without
+// concurrent workers
+func BenchmarkVerify(b *testing.B) {
+	pt, err := newPagingTester(b.Name())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create the entries
+	var ids []Identifier
+	for i := 0; i < 50; i++ {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := entry.Truncate(2000 * pageSize); err != nil {
+			b.Fatal(err)
+		}
+		if err := entry.Close(); err != nil {
+			b.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	for _, workers := range []int{0, 8} {
+		b.Run(fmt.Sprintf("workers=%v", workers), func(b *testing.B) {
+			pt.pm.opts.VerifyWorkers = workers
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if problems := pt.pm.Verify(ids); len(problems) > 0 {
+					b.Fatal(problems)
+				}
+			}
+		})
+	}
+}
+
+// TestVerifyFreeListDisjoint tests if a page that was added to the free pages
+// while it is still used by an entry is detected
+func TestVerifyFreeListDisjoint(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry with 3 pages and free a page of another entry to
+	// have free pages
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(3 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	other, otherID, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Write(fastrand.Bytes(pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.Delete(otherID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The free pages of a consistent file are disjoint from the entry
+	livePage := entry.ep.pages[1]
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	overlap, err := pt.pm.VerifyFreeListDisjoint([]Identifier{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlap) != 0 {
+		t.Fatalf("There should be no overlap but there was %v", overlap)
+	}
+
+	// Free a page of the entry that is still in use
+	if err := pt.pm.managedAddFreePages([]*physicalPage{livePage}); err != nil {
+		t.Fatal(err)
+	}
+	overlap, err = pt.pm.VerifyFreeListDisjoint([]Identifier{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlap) != 1 || overlap[0] != livePage.fileOff {
+		t.Errorf("Overlap should be [%v] but was %v", livePage.fileOff, overlap)
+	}
+}
+
+// TestVerifyReserved tests if a reserved entry is verified without creating
+// its root from the free pages that were already collected
+func TestVerifyReserved(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Free the pages of a deleted entry and reserve an entry
+	entry, deletedID, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(2 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.Delete(deletedID); err != nil {
+		t.Fatal(err)
+	}
+	id, err := pt.pm.Reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	available := pt.pm.freePages.availablePages()
+
+	// Neither of the checks should report a problem or take a free page
+	ids := []Identifier{id}
+	if problems := pt.pm.Verify(ids); len(problems) != 0 {
+		t.Errorf("Expected no problems but got %v", problems)
+	}
+	overlap, err := pt.pm.VerifyFreeListDisjoint(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlap) != 0 {
+		t.Errorf("There should be no overlap but there was %v", overlap)
+	}
+	orphans, err := pt.pm.FindOrphans(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("Expected no orphans but got %v", orphans)
+	}
+	if pt.pm.freePages.availablePages() != available {
+		t.Errorf("There should be %v free pages but there were %v", available, pt.pm.freePages.availablePages())
+	}
+}
@@ -0,0 +1,119 @@
+package pages
+
+import (
+	"io"
+	"sync"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+// startZeroPool starts the background thread that keeps the pool of
+// pre-zeroed pages filled if ZeroPoolPages was specified
+func (p *PageManager) startZeroPool() {
+	if p.opts.ZeroPoolPages <= 0 {
+		return
+	}
+	if p.stopChan == nil {
+		p.stopChan = make(chan struct{})
+		p.wg = new(sync.WaitGroup)
+	}
+	p.zeroPoolChan = make(chan struct{}, 1)
+	p.wg.Add(1)
+	go p.threadedRefillZeroPool()
+}
+
+// threadedRefillZeroPool fills the pool of pre-zeroed pages and refills it
+// whenever allocatePage signals that it is running low until the PageManager
+// is closed
+func (p *PageManager) threadedRefillZeroPool() {
+	defer p.wg.Done()
+	for {
+		// There is no caller to report errors to. Allocations fall back to
+		// zeroing pages synchronously until the next refill succeeds
+		if err := p.managedRefillZeroPool(); err != nil {
+			p.opts.Logger.Error("failed to refill zero pool: %v", err)
+		}
+		select {
+		case <-p.stopChan:
+			return
+		case <-p.zeroPoolChan:
+		}
+	}
+}
+
+// managedRefillZeroPool tops up the pool of pre-zeroed pages. The pages are
+// reserved at the end of the file while the lock is held but they are zeroed
+// and synced without holding it. They are only added to the pool after the
+// sync to guarantee that a page that is handed out is zero on disk. Until then
+// they are tracked in reservedZeroPages to not be mistaken for orphans
+func (p *PageManager) managedRefillZeroPool() error {
+	// Reserve the missing pages by growing the file
+	p.mu.Lock()
+	n := int64(p.opts.ZeroPoolPages - len(p.zeroPages))
+	if n <= 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	fileEnd, err := p.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	fileOff := fileEnd
+	if fileOff%pageSize != 0 {
+		fileOff += (pageSize - fileOff%pageSize)
+	}
+	if fileOff < dataOff {
+		fileOff = dataOff
+	}
+	if err := p.file.Truncate(fileOff + n*pageSize); err != nil {
+		p.mu.Unlock()
+		return build.ExtendErr("failed to reserve pages", err)
+	}
+	pages := make([]*physicalPage, 0, n)
+	for i := int64(0); i < n; i++ {
+		pages = append(pages, &physicalPage{
+			file:    p.file,
+			fileOff: fileOff + i*pageSize,
+			strict:  p.opts.StrictMode,
+		})
+	}
+	p.reservedZeroPages = pages
+	p.mu.Unlock()
+
+	// Zero the pages and persist them. If that fails the reserved pages are
+	// given back
+	_, err = writeFull(p.file, make([]byte, n*pageSize), fileOff)
+	if err == nil {
+		err = p.file.Sync()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reservedZeroPages = nil
+	if err != nil {
+		if returnErr := p.returnExtent(pages); returnErr != nil {
+			p.opts.Logger.Error("failed to return reserved pages: %v", returnErr)
+		}
+		return extendErr("failed to zero reserved pages", err)
+	}
+	p.zeroPages = append(p.zeroPages, pages...)
+	return nil
+}
+
+// takeZeroPage returns a page of the pool of pre-zeroed pages or nil if the
+// pool is empty. The background thread is signalled to refill the pool once
+// less than half of its pages are left
+func (p *PageManager) takeZeroPage() *physicalPage {
+	if len(p.zeroPages) == 0 {
+		return nil
+	}
+	page := p.zeroPages[0]
+	p.zeroPages = p.zeroPages[1:]
+	if len(p.zeroPages) < p.opts.ZeroPoolPages/2 {
+		select {
+		case p.zeroPoolChan <- struct{}{}:
+		default:
+		}
+	}
+	return page
+}
@@ -0,0 +1,172 @@
+package pages
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+// waitForZeroPool waits until the pool of pre-zeroed pages of pm is full
+func waitForZeroPool(pm *PageManager) bool {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		pm.mu.Lock()
+		full := len(pm.zeroPages) == pm.opts.ZeroPoolPages
+		pm.mu.Unlock()
+		if full {
+			return true
+		}
+	}
+	return false
+}
+
+// TestZeroPool tests if pages are allocated from the pool of pre-zeroed pages
+// and if the pool is refilled and freed on Close
+func TestZeroPool(t *testing.T) {
+	// Create temp dir
+	testdir := build.TempDir("paging", t.Name())
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testdir, "data.dat")
+
+	// The pool can't be combined with aligned allocations
+	opts := DefaultOptions()
+	opts.ZeroPoolPages = 8
+	opts.AllocAlignment = 4 * pageSize
+	if _, err := NewWithOptions(path, opts); err == nil {
+		t.Fatal("Creating a PageManager with a zero pool and alignment should fail")
+	}
+
+	// Create a PageManager with a zero pool and wait for it to be filled
+	opts.AllocAlignment = 0
+	pm, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitForZeroPool(pm) {
+		t.Fatal("Zero pool wasn't filled")
+	}
+	pm.mu.Lock()
+	poolOffsets := make(map[int64]struct{})
+	for _, page := range pm.zeroPages {
+		poolOffsets[page.fileOff] = struct{}{}
+	}
+	pm.mu.Unlock()
+
+	// The allocated pages are taken from the pool and are zero on disk
+	for i := 0; i < 6; i++ {
+		page, err := pm.managedAllocatePage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, exists := poolOffsets[page.fileOff]; !exists {
+			t.Fatalf("Page %v at %v wasn't taken from the zero pool", i, page.fileOff)
+		}
+		data := make([]byte, pageSize)
+		if _, err := pm.file.ReadAt(data, page.fileOff); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, make([]byte, pageSize)) {
+			t.Fatalf("Page %v at %v isn't zero", i, page.fileOff)
+		}
+	}
+
+	// Taking more than half of the pages triggers a refill
+	if !waitForZeroPool(pm) {
+		t.Fatal("Zero pool wasn't refilled")
+	}
+
+	// The unused pages of the pool are freed on Close
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if available := pm.freePages.availablePages(); available < opts.ZeroPoolPages {
+		t.Errorf("There should be at least %v free pages but there were %v", opts.ZeroPoolPages, available)
+	}
+}
+
+// TestZeroPoolReservedPages tests if the pages that are reserved for the zero
+// pool but not yet zeroed are neither reported as orphans nor by Verify
+func TestZeroPoolReservedPages(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Refill the pool of the PageManager manually and block the sync after
+	// the pages were reserved
+	bf := &blockingSyncFile{
+		backingFile: pt.pm.file,
+		syncing:     make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+	pt.pm.file = bf
+	pt.pm.opts.ZeroPoolPages = 8
+	errChan := make(chan error)
+	go func() {
+		errChan <- pt.pm.managedRefillZeroPool()
+	}()
+	<-bf.syncing
+
+	// The reserved pages aren't orphaned
+	orphans, err := pt.pm.FindOrphans(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("Expected no orphans but got %v", orphans)
+	}
+	if problems := pt.pm.Verify(nil); len(problems) != 0 {
+		t.Errorf("Expected no problems but got %v", problems)
+	}
+
+	// After the sync the pages are added to the pool
+	close(bf.release)
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if len(pt.pm.zeroPages) != 8 || len(pt.pm.reservedZeroPages) != 0 {
+		t.Errorf("Expected 8 pooled and 0 reserved pages but got %v and %v",
+			len(pt.pm.zeroPages), len(pt.pm.reservedZeroPages))
+	}
+}
+
+// BenchmarkAllocatePage benchmarks the latency of allocating pages at the end
+// of the file with and without the pool of pre-zeroed pages
+func BenchmarkAllocatePage(b *testing.B) {
+	run := func(b *testing.B, zeroPoolPages int) {
+		testdir := build.TempDir("paging", b.Name())
+		if err := os.MkdirAll(testdir, 0700); err != nil {
+			b.Fatal(err)
+		}
+		opts := DefaultOptions()
+		opts.ZeroPoolPages = zeroPoolPages
+		pm, err := NewWithOptions(filepath.Join(testdir, "data.dat"), opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer pm.Close()
+		if !waitForZeroPool(pm) {
+			b.Fatal("Zero pool wasn't filled")
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := pm.managedAllocatePage(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("Sync", func(b *testing.B) { run(b, 0) })
+	b.Run("ZeroPool", func(b *testing.B) { run(b, 1024) })
+}